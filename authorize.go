@@ -1,7 +1,14 @@
 package cedarsqlizer
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
 	"log/slog"
+	"maps"
+	"slices"
+	"strings"
 
 	"github.com/cedar-policy/cedar-go"
 	"github.com/cedar-policy/cedar-go/types"
@@ -15,48 +22,599 @@ type FieldMapper = sqlizer.FieldMapper
 
 var DefaultFieldMapper = sqlizer.DefaultFieldMapper
 
+type Options = sqlizer.Options
+
 type AuthorizeSQLRequest struct {
+	// Principal is folded into the SQL when set. When left zero it stays
+	// partial and `principal.*` attributes become columns through the
+	// FieldMapper, e.g. to list the users allowed to see a document.
 	Principal cedar.EntityUID
 	Action    cedar.EntityUID
-	Context   cedar.Value
+	// Resource is left zero for a row filter: resource stays partial and its
+	// attributes become columns, answering "which rows can the principal
+	// see". When set, resource is looked up in the entities instead and the
+	// SQL is the decision for that one resource, "1 = 1" or "1 = 0",
+	// answering "can the principal see this row".
+	Resource cedar.EntityUID
+	// ResourceType, when set, is the type of the rows filtered, e.g. the
+	// Document of a documents table. Policies whose resource scope names
+	// another type are left out, and an `is` scope naming this type holds,
+	// so the filter only references the columns of that one table.
+	ResourceType cedar.EntityType
+	// Context is folded into the SQL when set, else it stays partial, see
+	// Options.PartialContext.
+	Context cedar.Value
+	// TreatMissingContextAsUnknown keeps the attributes a policy reads from a
+	// partially populated Context but that it lacks symbolic, instead of
+	// failing: `context.tenant` stays a path mapped through the FieldMapper,
+	// like an attribute of a partial context, and `context has tenant` is
+	// unknown too, rendered as `<mapped column> IS NOT NULL`.
+	TreatMissingContextAsUnknown bool
 
 	FieldMapper FieldMapper
+	Options     Options
+
+	// EmptyOnAllow returns an empty filter with no args instead of "1 = 1"
+	// when every row is allowed, so a caller with its own WHERE conditions
+	// can leave the filter out rather than AND it in.
+	EmptyOnAllow bool
 }
 
-func AuthorizeSQL(policies cedar.PolicyIterator, entities cedar.EntityGetter, req *AuthorizeSQLRequest) (string, []interface{}, error) {
+func newEnv(entities cedar.EntityGetter, req *AuthorizeSQLRequest) eval.Env {
 	var context types.Value
 	if req.Context != nil {
 		context = req.Context
 	} else {
 		context = eval.Variable("context")
 	}
-	env := eval.Env{
+	var principal types.Value = req.Principal
+	if req.Principal == (cedar.EntityUID{}) {
+		principal = eval.Variable("principal")
+	}
+	var resource types.Value = req.Resource
+	if req.Resource == (cedar.EntityUID{}) {
+		resource = eval.Variable("resource")
+	}
+	return eval.Env{
 		Entities:  entities,
-		Principal: req.Principal,
+		Principal: principal,
 		Action:    req.Action,
-		Resource:  eval.Variable("resource"),
+		Resource:  resource,
 		Context:   context,
 	}
+}
+
+// missingContext lists, for req.TreatMissingContextAsUnknown, the context
+// attributes the policies read but the request's context lacks.
+type missingContext map[cedar.String]bool
+
+func findMissingContext(req *AuthorizeSQLRequest, policies iter.Seq2[cedar.PolicyID, *cedar.Policy]) missingContext {
+	record, ok := req.Context.(cedar.Record)
+	if !req.TreatMissingContextAsUnknown || !ok {
+		return nil
+	}
+	missing := missingContext{}
+	for _, p := range policies {
+		ast.Inspect(eval.PolicyToNode((*ast.Policy)(p.AST())), func(n ast.IsNode) bool {
+			if attr, ok := contextAccess(n); ok {
+				if _, ok := record.Get(attr); !ok {
+					missing[attr] = true
+				}
+			}
+			return true
+		})
+	}
+	return missing
+}
+
+// contextAccess returns the attribute read by `context.attr` or tested by
+// `context has attr`.
+func contextAccess(n ast.IsNode) (cedar.String, bool) {
+	var o ast.StrOpNode
+	switch v := n.(type) {
+	case ast.NodeTypeAccess:
+		o = v.StrOpNode
+	case ast.NodeTypeHas:
+		o = v.StrOpNode
+	default:
+		return "", false
+	}
+	v, ok := o.Arg.(ast.NodeTypeVariable)
+	return o.Value, ok && v.Name == "context"
+}
+
+// env sets every missing attribute to a variable named after its path, e.g.
+// "context.tenant", which the sqlizer maps through the FieldMapper.
+func (m missingContext) env(env eval.Env) eval.Env {
+	if len(m) == 0 {
+		return env
+	}
+	attrs := cedar.RecordMap{}
+	for k, v := range env.Context.(cedar.Record).All() {
+		attrs[k] = v
+	}
+	for attr := range m {
+		attrs[attr] = eval.Variable("context." + attr)
+	}
+	env.Context = cedar.NewRecord(attrs)
+	return env
+}
+
+// missingHas stands in for `context has attr` of a missing attribute during
+// partial evaluation, as `context.attr has ""`, which cannot fold.
+const missingHas = ""
+
+// partial is partial for an env set up by env. `context has attr` of a
+// missing attribute stays in the residual rather than holding for the
+// variable standing in for it, so the sqlizer renders it as a null check on
+// the mapped path. The context record folded into the residual around a
+// missing attribute is written back as `context.attr`.
+func (m missingContext) partial(env eval.Env, p *ast.Policy) (bool, ast.IsNode, error) {
+	if len(m) == 0 {
+		return partial(env, p)
+	}
+	rewritten := *p
+	rewritten.Conditions = make([]ast.ConditionType, len(p.Conditions))
+	for i, c := range p.Conditions {
+		c.Body = rewrite(c.Body, func(n ast.IsNode) ast.IsNode {
+			if has, ok := n.(ast.NodeTypeHas); ok {
+				if v, ok := has.Arg.(ast.NodeTypeVariable); ok && v.Name == "context" && m[has.Value] {
+					return ast.NodeTypeHas{StrOpNode: ast.StrOpNode{Arg: ast.NodeTypeAccess{StrOpNode: has.StrOpNode}, Value: missingHas}}
+				}
+			}
+			return n
+		})
+		rewritten.Conditions[i] = c
+	}
+	satisfied, isNode, err := partial(env, &rewritten)
+	if isNode == nil || err != nil {
+		return satisfied, isNode, err
+	}
+	return satisfied, rewrite(isNode, func(n ast.IsNode) ast.IsNode {
+		if has, ok := n.(ast.NodeTypeHas); ok && has.Value == missingHas {
+			if a, ok := has.Arg.(ast.NodeTypeAccess); ok && m[a.Value] {
+				return ast.NodeTypeHas{StrOpNode: a.StrOpNode}
+			}
+		}
+		if a, ok := n.(ast.NodeTypeAccess); ok && m[a.Value] {
+			if v, ok := a.Arg.(ast.NodeValue); ok {
+				if r, ok := v.Value.(cedar.Record); ok {
+					if val, _ := r.Get(a.Value); val == eval.Variable("context."+a.Value) {
+						return ast.NodeTypeAccess{StrOpNode: ast.StrOpNode{Arg: ast.NodeTypeVariable{Name: "context"}, Value: a.Value}}
+					}
+				}
+			}
+		}
+		return n
+	}), nil
+}
+
+// rewrite rebuilds n bottom up, replacing every node by fn of it.
+func rewrite(n ast.IsNode, fn func(ast.IsNode) ast.IsNode) ast.IsNode {
+	binary := func(b ast.BinaryNode) ast.BinaryNode {
+		return ast.BinaryNode{Left: rewrite(b.Left, fn), Right: rewrite(b.Right, fn)}
+	}
+	unary := func(u ast.UnaryNode) ast.UnaryNode {
+		return ast.UnaryNode{Arg: rewrite(u.Arg, fn)}
+	}
+	strOp := func(o ast.StrOpNode) ast.StrOpNode {
+		return ast.StrOpNode{Arg: rewrite(o.Arg, fn), Value: o.Value}
+	}
+	switch v := n.(type) {
+	case nil:
+		return nil
+	case ast.NodeTypeIfThenElse:
+		n = ast.NodeTypeIfThenElse{If: rewrite(v.If, fn), Then: rewrite(v.Then, fn), Else: rewrite(v.Else, fn)}
+	case ast.NodeTypeOr:
+		n = ast.NodeTypeOr{BinaryNode: binary(v.BinaryNode)}
+	case ast.NodeTypeAnd:
+		n = ast.NodeTypeAnd{BinaryNode: binary(v.BinaryNode)}
+	case ast.NodeTypeLessThan:
+		n = ast.NodeTypeLessThan{BinaryNode: binary(v.BinaryNode)}
+	case ast.NodeTypeLessThanOrEqual:
+		n = ast.NodeTypeLessThanOrEqual{BinaryNode: binary(v.BinaryNode)}
+	case ast.NodeTypeGreaterThan:
+		n = ast.NodeTypeGreaterThan{BinaryNode: binary(v.BinaryNode)}
+	case ast.NodeTypeGreaterThanOrEqual:
+		n = ast.NodeTypeGreaterThanOrEqual{BinaryNode: binary(v.BinaryNode)}
+	case ast.NodeTypeNotEquals:
+		n = ast.NodeTypeNotEquals{BinaryNode: binary(v.BinaryNode)}
+	case ast.NodeTypeEquals:
+		n = ast.NodeTypeEquals{BinaryNode: binary(v.BinaryNode)}
+	case ast.NodeTypeIn:
+		n = ast.NodeTypeIn{BinaryNode: binary(v.BinaryNode)}
+	case ast.NodeTypeHasTag:
+		n = ast.NodeTypeHasTag{BinaryNode: binary(v.BinaryNode)}
+	case ast.NodeTypeGetTag:
+		n = ast.NodeTypeGetTag{BinaryNode: binary(v.BinaryNode)}
+	case ast.NodeTypeSub:
+		n = ast.NodeTypeSub{BinaryNode: binary(v.BinaryNode)}
+	case ast.NodeTypeAdd:
+		n = ast.NodeTypeAdd{BinaryNode: binary(v.BinaryNode)}
+	case ast.NodeTypeMult:
+		n = ast.NodeTypeMult{BinaryNode: binary(v.BinaryNode)}
+	case ast.NodeTypeContains:
+		n = ast.NodeTypeContains{BinaryNode: binary(v.BinaryNode)}
+	case ast.NodeTypeContainsAll:
+		n = ast.NodeTypeContainsAll{BinaryNode: binary(v.BinaryNode)}
+	case ast.NodeTypeContainsAny:
+		n = ast.NodeTypeContainsAny{BinaryNode: binary(v.BinaryNode)}
+	case ast.NodeTypeNegate:
+		n = ast.NodeTypeNegate{UnaryNode: unary(v.UnaryNode)}
+	case ast.NodeTypeNot:
+		n = ast.NodeTypeNot{UnaryNode: unary(v.UnaryNode)}
+	case ast.NodeTypeIsEmpty:
+		n = ast.NodeTypeIsEmpty{UnaryNode: unary(v.UnaryNode)}
+	case ast.NodeTypeAccess:
+		n = ast.NodeTypeAccess{StrOpNode: strOp(v.StrOpNode)}
+	case ast.NodeTypeHas:
+		n = ast.NodeTypeHas{StrOpNode: strOp(v.StrOpNode)}
+	case ast.NodeTypeLike:
+		n = ast.NodeTypeLike{Arg: rewrite(v.Arg, fn), Value: v.Value}
+	case ast.NodeTypeIs:
+		n = ast.NodeTypeIs{Left: rewrite(v.Left, fn), EntityType: v.EntityType}
+	case ast.NodeTypeIsIn:
+		n = ast.NodeTypeIsIn{NodeTypeIs: ast.NodeTypeIs{Left: rewrite(v.Left, fn), EntityType: v.EntityType}, Entity: rewrite(v.Entity, fn)}
+	case ast.NodeTypeExtensionCall:
+		args := make([]ast.IsNode, len(v.Args))
+		for i, a := range v.Args {
+			args[i] = rewrite(a, fn)
+		}
+		n = ast.NodeTypeExtensionCall{Name: v.Name, Args: args}
+	case ast.NodeTypeRecord:
+		elements := make([]ast.RecordElementNode, len(v.Elements))
+		for i, e := range v.Elements {
+			elements[i] = ast.RecordElementNode{Key: e.Key, Value: rewrite(e.Value, fn)}
+		}
+		n = ast.NodeTypeRecord{Elements: elements}
+	case ast.NodeTypeSet:
+		elements := make([]ast.IsNode, len(v.Elements))
+		for i, e := range v.Elements {
+			elements[i] = rewrite(e, fn)
+		}
+		n = ast.NodeTypeSet{Elements: elements}
+	}
+	return fn(n)
+}
+
+func (req *AuthorizeSQLRequest) fieldMapper() FieldMapper {
+	if req.FieldMapper != nil {
+		return req.FieldMapper
+	}
+	return DefaultFieldMapper
+}
+
+func AuthorizeSQL(policies cedar.PolicyIterator, entities cedar.EntityGetter, req *AuthorizeSQLRequest) (string, []interface{}, error) {
+	return AuthorizeSQLContext(context.Background(), policies, entities, req)
+}
+
+// AuthorizeSQLContext is AuthorizeSQL stopping with ctx.Err() once ctx is
+// done, checked between policies.
+func AuthorizeSQLContext(ctx context.Context, policies cedar.PolicyIterator, entities cedar.EntityGetter, req *AuthorizeSQLRequest) (string, []interface{}, error) {
+	return authorizeSQL(ctx, policies.All(), entities, req)
+}
+
+func authorizeSQL(ctx context.Context, policies iter.Seq2[cedar.PolicyID, *cedar.Policy], entities cedar.EntityGetter, req *AuthorizeSQLRequest) (string, []interface{}, error) {
+	r, err := combine(ctx, policies, entities, req)
+	if err != nil {
+		return "", nil, err
+	}
+	mapper := req.fieldMapper()
+	sql, args, err := sqlizer.ToSqlWithOptions(r.node, r.env, mapper, req.Options)
+	if err != nil {
+		return "", nil, policyError(err, r.env, mapper, req.Options, r.forbidsRemains, r.permitsRemains)
+	}
+	if req.EmptyOnAllow && sql == "1 = 1" {
+		return "", nil, nil
+	}
+	return sql, args, nil
+}
+
+// PolicyContribution is the part of an AuthorizeSQL filter one policy
+// contributed, e.g. to log which policy made a row visible.
+type PolicyContribution struct {
+	PolicyID cedar.PolicyID
+	Effect   cedar.Effect
+	// SQL is "1 = 1" for a policy satisfied outright, else its residual.
+	SQL  string
+	Args []interface{}
+}
+
+// AuthorizeSQLContributions is AuthorizeSQL also returning the policies the
+// filter was combined from, ordered by policy ID: the unconditional forbid
+// that denies everything, or else the permits and forbids that remain in it.
+func AuthorizeSQLContributions(policies cedar.PolicyIterator, entities cedar.EntityGetter, req *AuthorizeSQLRequest) (string, []interface{}, []PolicyContribution, error) {
+	r, err := combine(context.Background(), policies.All(), entities, req)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	mapper := req.fieldMapper()
+	sql, args, err := sqlizer.ToSqlWithOptions(r.node, r.env, mapper, req.Options)
+	if err != nil {
+		return "", nil, nil, policyError(err, r.env, mapper, req.Options, r.forbidsRemains, r.permitsRemains)
+	}
+
+	var contributions []PolicyContribution
+	unconditional := func(effect cedar.Effect, pids []cedar.PolicyID) {
+		for _, pid := range pids {
+			contributions = append(contributions, PolicyContribution{PolicyID: pid, Effect: effect, SQL: "1 = 1"})
+		}
+	}
+	remaining := func(effect cedar.Effect, remains map[cedar.PolicyID]ast.IsNode) error {
+		for pid, isNode := range remains {
+			sql, args, err := sqlizer.ToSqlWithOptions(isNode, r.env, mapper, req.Options)
+			if err != nil {
+				return &PolicyError{PolicyID: pid, Effect: effect, Residual: utils.NString(isNode), Err: err}
+			}
+			contributions = append(contributions, PolicyContribution{PolicyID: pid, Effect: effect, SQL: sql, Args: args})
+		}
+		return nil
+	}
+	if len(r.forbids) > 0 {
+		unconditional(cedar.Forbid, r.forbids)
+	} else {
+		if len(r.permits) > 0 {
+			unconditional(cedar.Permit, r.permits)
+		} else if err := remaining(cedar.Permit, r.permitsRemains); err != nil {
+			return "", nil, nil, err
+		}
+		if err := remaining(cedar.Forbid, r.forbidsRemains); err != nil {
+			return "", nil, nil, err
+		}
+	}
+	slices.SortFunc(contributions, func(a, b PolicyContribution) int {
+		return strings.Compare(string(a.PolicyID), string(b.PolicyID))
+	})
+	return sql, args, contributions, nil
+}
+
+// Decision tells whether the rows a request may see depend on its filter.
+type Decision int
+
+const (
+	// Deny means no row passes; the query can be skipped.
+	Deny Decision = iota
+	// Allow means every row passes; the filter can be left out.
+	Allow
+	// Conditional means the rows that pass are the ones the filter keeps.
+	Conditional
+)
+
+func (d Decision) String() string {
+	switch d {
+	case Deny:
+		return "deny"
+	case Allow:
+		return "allow"
+	}
+	return "conditional"
+}
+
+// AuthorizeSQLDecision is AuthorizeSQL also reporting the decision, so the
+// caller can skip the query on Deny instead of running it with "1 = 0".
+func AuthorizeSQLDecision(policies cedar.PolicyIterator, entities cedar.EntityGetter, req *AuthorizeSQLRequest) (Decision, string, []interface{}, error) {
+	sql, args, err := AuthorizeSQL(policies, entities, req)
+	if err != nil {
+		return Deny, "", nil, err
+	}
+	switch sql {
+	case "1 = 1", "":
+		return Allow, sql, args, nil
+	case "1 = 0":
+		return Deny, sql, args, nil
+	}
+	return Conditional, sql, args, nil
+}
+
+// AuthorizeJoinSQL is AuthorizeSQL producing a condition for the ON clause of
+// a join, e.g. `LEFT JOIN documents d ON <filter>`: `resource.*` paths are
+// rendered against alias and other paths go through req.FieldMapper. An
+// unconditional decision becomes TRUE or FALSE, 1 or 0 on SQLite, so the ON
+// clause keeps it.
+func AuthorizeJoinSQL(policies cedar.PolicyIterator, entities cedar.EntityGetter, req *AuthorizeSQLRequest, alias string) (string, []interface{}, error) {
+	join := *req
+	join.FieldMapper = joinMapper{alias: sqlizer.WithTableAlias("resource", alias), FieldMapper: req.fieldMapper()}
+	// an ON clause needs a condition
+	join.EmptyOnAllow = false
+	sql, args, err := AuthorizeSQL(policies, entities, &join)
+	if err != nil {
+		return "", nil, err
+	}
+	sqlite := req.Options.Dialect == sqlizer.SQLite
+	switch {
+	case sql == "1 = 1" && sqlite:
+		sql = "1"
+	case sql == "1 = 1":
+		sql = "TRUE"
+	case sql == "1 = 0" && sqlite:
+		sql = "0"
+	case sql == "1 = 0":
+		sql = "FALSE"
+	}
+	return sql, args, nil
+}
+
+// joinMapper maps resource paths onto the joined alias and every other path
+// through the request's mapper. A typed request mapper keeps its column types,
+// for resource paths too, and an expression mapper its expressions for the
+// paths that are not aliased.
+type joinMapper struct {
+	FieldMapper
+	alias sqlizer.AliasMapper
+}
+
+func (m joinMapper) Map(name string) (string, error) {
+	column, err := m.MapColumn(name)
+	return column.Column, err
+}
+
+func (m joinMapper) MapColumn(name string) (sqlizer.ColumnSpec, error) {
+	typed, isTyped := m.FieldMapper.(sqlizer.TypedFieldMapper)
+	if !isResourcePath(name) {
+		if isTyped {
+			return typed.MapColumn(name)
+		}
+		column, err := m.FieldMapper.Map(name)
+		return sqlizer.ColumnSpec{Column: column}, err
+	}
+	aliased, err := m.alias.Map(name)
+	if err != nil {
+		return sqlizer.ColumnSpec{}, err
+	}
+	var column sqlizer.ColumnSpec
+	if isTyped {
+		// only the type is kept, the request mapper may not know the path
+		if c, err := typed.MapColumn(name); err == nil {
+			column = c
+		}
+	}
+	column.Column, column.Raw = aliased, false
+	return column, nil
+}
+
+func (m joinMapper) MapExpr(name string) (sqlizer.Sqlizer, bool, error) {
+	if sm, ok := m.FieldMapper.(sqlizer.SqlFieldMapper); ok && !isResourcePath(name) {
+		return sm.MapExpr(name)
+	}
+	return nil, false, nil
+}
+
+func isResourcePath(name string) bool {
+	return name == "resource" || strings.HasPrefix(name, "resource.")
+}
+
+// ResidualNode returns the combined residual that AuthorizeSQL renders, and
+// the env to render it with, so callers can transform the node before
+// passing both to sqlizer.ToSql themselves.
+func ResidualNode(policies cedar.PolicyIterator, entities cedar.EntityGetter, req *AuthorizeSQLRequest) (ast.IsNode, eval.Env, error) {
+	r, err := combine(context.Background(), policies.All(), entities, req)
+	if err != nil {
+		return nil, eval.Env{}, err
+	}
+	return r.node, r.env, nil
+}
+
+// Diagnostics explains the SQL AuthorizeSQLDiagnostics returns.
+type Diagnostics struct {
+	// Residual is the combined condition left after partial evaluation, the
+	// one the SQL was rendered from, with its boolean constants folded and
+	// written by utils.NString, e.g. `resource.owner = User::"bob"`.
+	Residual string
+}
+
+// AuthorizeSQLDiagnostics is AuthorizeSQL also returning Diagnostics, e.g.
+// to log the remaining policy condition next to the SQL it became.
+func AuthorizeSQLDiagnostics(policies cedar.PolicyIterator, entities cedar.EntityGetter, req *AuthorizeSQLRequest) (string, []interface{}, Diagnostics, error) {
+	r, err := combine(context.Background(), policies.All(), entities, req)
+	if err != nil {
+		return "", nil, Diagnostics{}, err
+	}
+	diagnostics := Diagnostics{Residual: utils.NString(foldBooleans(r.node))}
+	mapper := req.fieldMapper()
+	sql, args, err := sqlizer.ToSqlWithOptions(r.node, r.env, mapper, req.Options)
+	if err != nil {
+		return "", nil, diagnostics, policyError(err, r.env, mapper, req.Options, r.forbidsRemains, r.permitsRemains)
+	}
+	return sql, args, diagnostics, nil
+}
+
+// foldBooleans drops the true and false operands combine leaves around the
+// residual, e.g. `true AND (false OR x) AND !false` becomes x, the way the SQL
+// folds them.
+func foldBooleans(n ast.IsNode) ast.IsNode {
+	return rewrite(n, func(n ast.IsNode) ast.IsNode {
+		switch v := n.(type) {
+		case ast.NodeTypeAnd:
+			left, lok := boolValue(v.Left)
+			right, rok := boolValue(v.Right)
+			switch {
+			case lok && !left, rok && !right:
+				return ast.False().AsIsNode()
+			case lok:
+				return v.Right
+			case rok:
+				return v.Left
+			}
+		case ast.NodeTypeOr:
+			left, lok := boolValue(v.Left)
+			right, rok := boolValue(v.Right)
+			switch {
+			case lok && left, rok && right:
+				return ast.True().AsIsNode()
+			case lok:
+				return v.Right
+			case rok:
+				return v.Left
+			}
+		case ast.NodeTypeNot:
+			if b, ok := boolValue(v.Arg); ok {
+				return ast.Boolean(!b).AsIsNode()
+			}
+		}
+		return n
+	})
+}
+
+func boolValue(n ast.IsNode) (bool, bool) {
+	if v, ok := n.(ast.NodeValue); ok {
+		if b, ok := v.Value.(cedar.Boolean); ok {
+			return bool(b), true
+		}
+	}
+	return false, false
+}
+
+// residual is the outcome of partially evaluating a policy set.
+type residual struct {
+	env  eval.Env
+	node ast.IsNode
+	// the policies satisfied outright
+	permits []cedar.PolicyID
+	forbids []cedar.PolicyID
+	// the residuals of the policies that were neither satisfied nor dropped
+	permitsRemains map[cedar.PolicyID]ast.IsNode
+	forbidsRemains map[cedar.PolicyID]ast.IsNode
+}
+
+// combine partially evaluates every policy against req and combines the
+// residuals into a single node: a row passes when any permit holds and no
+// forbid does.
+func combine(ctx context.Context, policies iter.Seq2[cedar.PolicyID, *cedar.Policy], entities cedar.EntityGetter, req *AuthorizeSQLRequest) (residual, error) {
+	missing := findMissingContext(req, policies)
+	env := missing.env(newEnv(entities, req))
 
 	var forbids []cedar.PolicyID
 	var permits []cedar.PolicyID
 	var permitsRemains = make(map[cedar.PolicyID]ast.IsNode)
 	var forbidsRemains = make(map[cedar.PolicyID]ast.IsNode)
 	var node ast.Node
+	// without a permit that applies, every row is denied, as in cedar
 	var permitsNode ast.Node = ast.False()
 	var forbidsNode ast.Node = ast.False()
-	for pid, p := range policies.All() {
-		a := (*ast.Policy)(p.AST())
-		satisfied, isNode, err := partial(env, a)
+	for pid, p := range policies {
+		if err := ctx.Err(); err != nil {
+			return residual{}, err
+		}
+		if len(permits) > 0 && p.Effect() == cedar.Permit {
+			// an unconditional permit already allows every row, other
+			// permits can't widen it; forbids can still narrow it
+			continue
+		}
+		a, ok := scopeToType((*ast.Policy)(p.AST()), req.ResourceType)
+		if !ok {
+			continue
+		}
+		satisfied, isNode, err := missing.partial(env, a)
 		if err != nil {
-			return "", nil, err
+			return residual{}, err
+		}
+		if satisfied && p.Effect() == cedar.Forbid {
+			// an unconditional forbid denies every row, nothing can undo it
+			forbids = append(forbids, pid)
+			break
 		}
 		if satisfied {
-			if p.Effect() == cedar.Permit {
-				permits = append(permits, pid)
-			} else {
-				forbids = append(forbids, pid)
-			}
+			permits = append(permits, pid)
 		}
 		if isNode != nil {
 			if p.Effect() == cedar.Permit {
@@ -72,15 +630,13 @@ func AuthorizeSQL(policies cedar.PolicyIterator, entities cedar.EntityGetter, re
 		for _, pid := range forbids {
 			slog.Debug("forbid policy", "pid", pid)
 		}
-	} else if len(permits) > 0 {
+	} else {
 		node = ast.True()
 		for _, pid := range permits {
 			slog.Debug("permit policy", "pid", pid)
 		}
-	} else {
-		node = ast.True()
 		// permitsreamin determine every row that satisfies any of the permits
-		if len(permitsRemains) > 0 {
+		if len(permits) == 0 && len(permitsRemains) > 0 {
 			for _, isNode := range permitsRemains {
 				if permitsNode.AsIsNode() == nil {
 					permitsNode = ast.NewNode(isNode)
@@ -89,7 +645,7 @@ func AuthorizeSQL(policies cedar.PolicyIterator, entities cedar.EntityGetter, re
 				}
 			}
 		}
-		if permitsNode.AsIsNode() != nil {
+		if len(permits) == 0 && permitsNode.AsIsNode() != nil {
 			node = node.And(permitsNode)
 		}
 
@@ -113,14 +669,164 @@ func AuthorizeSQL(policies cedar.PolicyIterator, entities cedar.EntityGetter, re
 
 	}
 
-	var mapper FieldMapper
-	if req.FieldMapper != nil {
-		mapper = req.FieldMapper
-	} else {
-		mapper = DefaultFieldMapper
+	return residual{env: env, node: node.AsIsNode(), permits: permits, forbids: forbids, permitsRemains: permitsRemains, forbidsRemains: forbidsRemains}, nil
+}
+
+// FilterResult is the SQL filter of a single policy.
+type FilterResult struct {
+	Effect cedar.Effect
+	SQL    string
+	Args   []interface{}
+}
+
+// PolicyFilters renders the filter of every policy that applies to req on its
+// own, instead of combining them like AuthorizeSQL, so each can be run
+// separately, e.g. to count the rows a policy grants or denies. Policies
+// satisfied outright get "1 = 1"; policies that cannot apply are left out.
+func PolicyFilters(policies cedar.PolicyIterator, entities cedar.EntityGetter, req *AuthorizeSQLRequest) (map[cedar.PolicyID]FilterResult, error) {
+	missing := findMissingContext(req, policies.All())
+	env := missing.env(newEnv(entities, req))
+	mapper := req.fieldMapper()
+	filters := make(map[cedar.PolicyID]FilterResult)
+	for pid, p := range policies.All() {
+		a, ok := scopeToType((*ast.Policy)(p.AST()), req.ResourceType)
+		if !ok {
+			continue
+		}
+		satisfied, isNode, err := missing.partial(env, a)
+		if err != nil {
+			return nil, err
+		}
+		if satisfied {
+			isNode = ast.True().AsIsNode()
+		} else if isNode == nil {
+			continue
+		}
+		sql, args, err := sqlizer.ToSqlWithOptions(isNode, env, mapper, req.Options)
+		if err != nil {
+			return nil, &PolicyError{PolicyID: pid, Effect: p.Effect(), Residual: utils.NString(isNode), Err: err}
+		}
+		filters[pid] = FilterResult{Effect: p.Effect(), SQL: sql, Args: args}
+	}
+	return filters, nil
+}
+
+// PolicyError reports the policy whose residual could not be rendered to SQL.
+type PolicyError struct {
+	PolicyID cedar.PolicyID
+	Effect   cedar.Effect
+	// Residual is the policy's remaining condition, rendered by utils.NString.
+	Residual string
+	Err      error
+}
+
+func (e *PolicyError) Error() string {
+	effect := "permit"
+	if e.Effect == cedar.Forbid {
+		effect = "forbid"
+	}
+	return fmt.Sprintf("%s policy %s contains unsupported construct %s: %v", effect, e.PolicyID, e.Residual, e.Err)
+}
+
+func (e *PolicyError) Unwrap() error {
+	return e.Err
+}
+
+// policyError finds the residual responsible for err, forbids first, and
+// wraps err with its provenance. It only runs once the combined filter has
+// failed, so the common path pays nothing for it.
+func policyError(err error, env eval.Env, mapper FieldMapper, opts Options, forbidsRemains, permitsRemains map[cedar.PolicyID]ast.IsNode) error {
+	for _, effect := range []cedar.Effect{cedar.Forbid, cedar.Permit} {
+		remains := permitsRemains
+		if effect == cedar.Forbid {
+			remains = forbidsRemains
+		}
+		for _, pid := range slices.Sorted(maps.Keys(remains)) {
+			if _, _, perr := sqlizer.ToSqlWithOptions(remains[pid], env, mapper, opts); perr != nil {
+				perr := &PolicyError{PolicyID: pid, Effect: effect, Residual: utils.NString(remains[pid]), Err: perr}
+				if errors.Is(perr, sqlizer.ErrInvalidFieldName) {
+					slog.Warn("policy references an unmapped attribute", "policy", pid, "effect", effect, "err", perr.Err)
+				}
+				return perr
+			}
+		}
+	}
+	return err
+}
+
+// ReferencedColumns reports, per resource type, every mapped column the SQL
+// generated for req could reference, so indexes can be checked before a policy
+// set ships. Policies whose resource scope does not name a type are reported
+// under the empty entity type. With req.ResourceType set, policies are scoped
+// to it as AuthorizeSQL scopes them and every column is reported under it.
+func ReferencedColumns(policies cedar.PolicyIterator, entities cedar.EntityGetter, req *AuthorizeSQLRequest) (map[cedar.EntityType][]string, error) {
+	missing := findMissingContext(req, policies.All())
+	env := missing.env(newEnv(entities, req))
+	mapper := req.fieldMapper()
+	columns := make(map[cedar.EntityType][]string)
+	for _, p := range policies.All() {
+		typ := resourceScopeType((*ast.Policy)(p.AST()).Resource)
+		if req.ResourceType != "" {
+			typ = req.ResourceType
+		}
+		a, ok := scopeToType((*ast.Policy)(p.AST()), req.ResourceType)
+		if !ok {
+			continue
+		}
+		_, isNode, err := missing.partial(env, a)
+		if err != nil {
+			return nil, err
+		}
+		if isNode == nil {
+			continue
+		}
+		cols, err := sqlizer.ColumnsWithOptions(isNode, mapper, req.Options)
+		if err != nil {
+			return nil, err
+		}
+		for _, col := range cols {
+			if !slices.Contains(columns[typ], col) {
+				columns[typ] = append(columns[typ], col)
+			}
+		}
+		slices.Sort(columns[typ])
+	}
+	return columns, nil
+}
+
+func resourceScopeType(scope ast.IsResourceScopeNode) cedar.EntityType {
+	switch s := scope.(type) {
+	case ast.ScopeTypeEq:
+		return s.Entity.Type
+	case ast.ScopeTypeIs:
+		return s.Type
+	case ast.ScopeTypeIsIn:
+		return s.Type
+	}
+	return ""
+}
+
+// scopeToType narrows the resource scope of p to resources of typ: ok is
+// false when the scope names another type, and an `is typ` scope is dropped
+// as it always holds. p is returned as is when typ is empty.
+func scopeToType(p *ast.Policy, typ cedar.EntityType) (scoped *ast.Policy, ok bool) {
+	if typ == "" {
+		return p, true
+	}
+	if t := resourceScopeType(p.Resource); t != "" && t != typ {
+		return nil, false
+	}
+	switch s := p.Resource.(type) {
+	case ast.ScopeTypeIs:
+		narrowed := *p
+		narrowed.Resource = ast.ScopeTypeAll{}
+		return &narrowed, true
+	case ast.ScopeTypeIsIn:
+		narrowed := *p
+		narrowed.Resource = ast.ScopeTypeIn{Entity: s.Entity}
+		return &narrowed, true
 	}
-	sql, args, err := sqlizer.ToSql(node.AsIsNode(), env, mapper)
-	return sql, args, err
+	return p, true
 }
 
 func partial(env eval.Env, p *ast.Policy) (satisfied bool, isNode ast.IsNode, err error) {
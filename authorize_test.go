@@ -1,6 +1,8 @@
 package cedarsqlizer
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"reflect"
 	"slices"
@@ -9,6 +11,7 @@ import (
 
 	"github.com/cedar-policy/cedar-go"
 	"github.com/cedar-policy/cedar-go/types"
+	"github.com/cedar-policy/cedar-go/x/exp/ast"
 	"github.com/jaredzhou/cedar-sqlizer/sqlizer"
 )
 
@@ -34,6 +37,7 @@ var entitiesStr = `
             "id": "bob"
         },
         "attrs": {
+            "role": "engineering"
         }
     },
     {
@@ -60,7 +64,7 @@ var entitiesStr = `
 type docMapper struct{}
 
 func (m docMapper) Map(name string) (string, error) {
-	validDocFields := []string{"owner", "is_public"}
+	validDocFields := []string{"owner", "is_public", "type", "team"}
 	if strings.HasPrefix(name, "resource.") {
 		field := strings.TrimPrefix(name, "resource.")
 		if slices.Contains(validDocFields, field) {
@@ -79,9 +83,8 @@ func (m docMapper) Map(name string) (string, error) {
 	return name, fmt.Errorf("%s: %w", name, sqlizer.ErrInvalidFieldName)
 }
 
-func TestAuthorizeSQL(t *testing.T) {
-	t.Parallel()
-	psStr := `
+// viewDocumentPolicies is the policy set the AuthorizeSQL fixtures run against.
+var viewDocumentPolicies = `
 	permit(principal, action == Action::"ViewDocument", resource)
 	when {context.is_authenticated} 
     when {resource.owner == principal || resource.is_public == true}; 
@@ -97,7 +100,10 @@ func TestAuthorizeSQL(t *testing.T) {
     forbid(principal, action == Action::"ViewDocument", resource)
     when {principal has block && principal.block == true} ;
 	`
-	ps, err := cedar.NewPolicySetFromBytes("", []byte(psStr))
+
+func TestAuthorizeSQL(t *testing.T) {
+	t.Parallel()
+	ps, err := cedar.NewPolicySetFromBytes("", []byte(viewDocumentPolicies))
 	if err != nil {
 		t.Fatal("new policy set error", err)
 	}
@@ -177,3 +183,1311 @@ func TestAuthorizeSQL(t *testing.T) {
 		})
 	}
 }
+
+func TestAuthorizeSQLAdminShortCircuit(t *testing.T) {
+	t.Parallel()
+	psStr := `
+	permit(principal, action == Action::"ViewDocument", resource)
+	when {resource.owner == principal || principal in Group::"admin"};
+	`
+	ps, err := cedar.NewPolicySetFromBytes("", []byte(psStr))
+	if err != nil {
+		t.Fatal("new policy set error", err)
+	}
+	var entities types.EntityMap
+	err = entities.UnmarshalJSON([]byte(entitiesStr))
+	if err != nil {
+		t.Fatal("unmarshal entities error", err)
+	}
+	tests := []struct {
+		name      string
+		principal string
+		want      string
+		args      []interface{}
+	}{
+		{
+			name:      "admin sees every document",
+			principal: "alice",
+			want:      "1 = 1",
+		},
+		{
+			name:      "non admin sees their own documents",
+			principal: "bob",
+			want:      "document.owner = ?",
+			args:      []interface{}{"bob"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sql, args, err := AuthorizeSQL(ps, entities, &AuthorizeSQLRequest{
+				Principal:   cedar.NewEntityUID("User", cedar.String(tt.principal)),
+				Action:      cedar.NewEntityUID("Action", "ViewDocument"),
+				FieldMapper: docMapper{},
+			})
+			if err != nil {
+				t.Fatal("authorize sql error", err)
+			}
+			if sql != tt.want {
+				t.Fatalf("want %s, got %s", tt.want, sql)
+			}
+			if len(args) != len(tt.args) || (len(args) > 0 && !reflect.DeepEqual(args, tt.args)) {
+				t.Fatalf("want args %v, got %v", tt.args, args)
+			}
+		})
+	}
+}
+
+func TestAuthorizeSQLPrincipalHas(t *testing.T) {
+	t.Parallel()
+	psStr := `
+	permit(principal, action == Action::"ViewDocument", resource)
+	when {principal has role && resource.team == principal.role};
+	`
+	ps, err := cedar.NewPolicySetFromBytes("", []byte(psStr))
+	if err != nil {
+		t.Fatal("new policy set error", err)
+	}
+	var entities types.EntityMap
+	err = entities.UnmarshalJSON([]byte(entitiesStr))
+	if err != nil {
+		t.Fatal("unmarshal entities error", err)
+	}
+	tests := []struct {
+		name      string
+		principal string
+		want      string
+		args      []interface{}
+	}{
+		{
+			name:      "has folds away and the role is bound",
+			principal: "bob",
+			want:      "document.team = ?",
+			args:      []interface{}{"engineering"},
+		},
+		{
+			name:      "without the attribute nothing is visible",
+			principal: "charlie",
+			want:      "1 = 0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sql, args, err := AuthorizeSQL(ps, entities, &AuthorizeSQLRequest{
+				Principal:   cedar.NewEntityUID("User", cedar.String(tt.principal)),
+				Action:      cedar.NewEntityUID("Action", "ViewDocument"),
+				FieldMapper: docMapper{},
+			})
+			if err != nil {
+				t.Fatal("authorize sql error", err)
+			}
+			if sql != tt.want {
+				t.Fatalf("want %s, got %s", tt.want, sql)
+			}
+			if len(args) != len(tt.args) || (len(args) > 0 && !reflect.DeepEqual(args, tt.args)) {
+				t.Fatalf("want args %v, got %v", tt.args, args)
+			}
+		})
+	}
+}
+
+func TestAuthorizeSQLPrincipalAttributes(t *testing.T) {
+	t.Parallel()
+	psStr := `
+	permit(principal, action == Action::"ViewDocument", resource)
+	when {resource.team == principal.role};
+	`
+	ps, err := cedar.NewPolicySetFromBytes("", []byte(psStr))
+	if err != nil {
+		t.Fatal("new policy set error", err)
+	}
+	var entities types.EntityMap
+	err = entities.UnmarshalJSON([]byte(entitiesStr))
+	if err != nil {
+		t.Fatal("unmarshal entities error", err)
+	}
+	mapper := sqlizer.WithTableAlias("resource", "document").WithTableAlias("principal", "users")
+	tests := []struct {
+		name      string
+		principal cedar.EntityUID
+		want      string
+		args      []interface{}
+	}{
+		{
+			name:      "concrete principal folds to a value",
+			principal: cedar.NewEntityUID("User", "bob"),
+			want:      "document.team = ?",
+			args:      []interface{}{"engineering"},
+		},
+		{
+			name: "partial principal becomes a column",
+			want: "document.team = users.role",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sql, args, err := AuthorizeSQL(ps, entities, &AuthorizeSQLRequest{
+				Principal:   tt.principal,
+				Action:      cedar.NewEntityUID("Action", "ViewDocument"),
+				FieldMapper: mapper,
+			})
+			if err != nil {
+				t.Fatal("authorize sql error", err)
+			}
+			if sql != tt.want {
+				t.Fatalf("want %s, got %s", tt.want, sql)
+			}
+			if len(args) != len(tt.args) || (len(args) > 0 && !reflect.DeepEqual(args, tt.args)) {
+				t.Fatalf("want args %v, got %v", tt.args, args)
+			}
+		})
+	}
+}
+
+func TestAuthorizeSQLUnconditionalPermitKeepsForbids(t *testing.T) {
+	t.Parallel()
+	psStr := `
+	permit(principal, action == Action::"ViewDocument", resource);
+
+	permit(principal, action == Action::"ViewDocument", resource)
+	when {resource.owner == principal};
+
+	forbid(principal, action == Action::"ViewDocument", resource)
+	when {resource.is_public == false};
+	`
+	ps, err := cedar.NewPolicySetFromBytes("", []byte(psStr))
+	if err != nil {
+		t.Fatal("new policy set error", err)
+	}
+	var entities types.EntityMap
+	err = entities.UnmarshalJSON([]byte(entitiesStr))
+	if err != nil {
+		t.Fatal("unmarshal entities error", err)
+	}
+	sql, args, err := AuthorizeSQL(ps, entities, &AuthorizeSQLRequest{
+		Principal:   cedar.NewEntityUID("User", "bob"),
+		Action:      cedar.NewEntityUID("Action", "ViewDocument"),
+		FieldMapper: docMapper{},
+	})
+	if err != nil {
+		t.Fatal("authorize sql error", err)
+	}
+	want := "NOT (document.is_public = ?)"
+	if sql != want {
+		t.Fatalf("want %s, got %s", want, sql)
+	}
+	if !reflect.DeepEqual(args, []interface{}{false}) {
+		t.Fatalf("want args [false], got %v", args)
+	}
+}
+
+// BenchmarkAuthorizeSQLUnconditionalPermit measures a large policy set with
+// one unconditional permit: once it is found the remaining permits are not
+// partially evaluated.
+func BenchmarkAuthorizeSQLUnconditionalPermit(b *testing.B) {
+	var psStr strings.Builder
+	psStr.WriteString(`permit(principal, action == Action::"ViewDocument", resource);`)
+	for i := range 500 {
+		fmt.Fprintf(&psStr, `
+		permit(principal, action == Action::"ViewDocument", resource)
+		when {resource.owner == principal && resource.level > %d && context.is_authenticated};`, i)
+	}
+	ps, err := cedar.NewPolicySetFromBytes("", []byte(psStr.String()))
+	if err != nil {
+		b.Fatal("new policy set error", err)
+	}
+	var entities types.EntityMap
+	err = entities.UnmarshalJSON([]byte(entitiesStr))
+	if err != nil {
+		b.Fatal("unmarshal entities error", err)
+	}
+	req := &AuthorizeSQLRequest{
+		Principal: cedar.NewEntityUID("User", "bob"),
+		Action:    cedar.NewEntityUID("Action", "ViewDocument"),
+	}
+	for b.Loop() {
+		if _, _, err := AuthorizeSQL(ps, entities, req); err != nil {
+			b.Fatal("authorize sql error", err)
+		}
+	}
+}
+
+func TestAuthorizeJoinSQL(t *testing.T) {
+	t.Parallel()
+	ps, err := cedar.NewPolicySetFromBytes("", []byte(viewDocumentPolicies))
+	if err != nil {
+		t.Fatal("new policy set error", err)
+	}
+	var entities types.EntityMap
+	err = entities.UnmarshalJSON([]byte(entitiesStr))
+	if err != nil {
+		t.Fatal("unmarshal entities error", err)
+	}
+	tests := []struct {
+		name      string
+		principal string
+		mapper    FieldMapper
+		opts      Options
+		want      string
+		args      []interface{}
+	}{
+		{
+			name:      "allow all is kept as true",
+			principal: "alice",
+			want:      "TRUE",
+		},
+		{
+			name:      "columns use the alias",
+			principal: "bob",
+			want:      "(d.owner = ? OR d.is_public = ?)",
+			args:      []interface{}{"bob", true},
+		},
+		{
+			name:      "a typed mapper keeps its column types",
+			principal: "bob",
+			mapper:    collatedOwnerMapper{},
+			want:      `(d.owner = ? COLLATE "C" OR d.is_public = ?)`,
+			args:      []interface{}{"bob", true},
+		},
+		{
+			name:      "deny all is kept as false",
+			principal: "charlie",
+			want:      "FALSE",
+		},
+		{
+			name:      "sqlite keeps deny all as 0",
+			principal: "charlie",
+			opts:      Options{Dialect: sqlizer.SQLite},
+			want:      "0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sql, args, err := AuthorizeJoinSQL(ps, entities, &AuthorizeSQLRequest{
+				Principal: cedar.NewEntityUID("User", cedar.String(tt.principal)),
+				Action:    cedar.NewEntityUID("Action", "ViewDocument"),
+				Context: cedar.NewRecord(cedar.RecordMap{
+					"is_authenticated": cedar.Boolean(true),
+				}),
+				FieldMapper: tt.mapper,
+				Options:     tt.opts,
+			}, "d")
+			if err != nil {
+				t.Fatal("authorize sql error", err)
+			}
+			if sql != tt.want {
+				t.Fatalf("want %s, got %s", tt.want, sql)
+			}
+			if len(args) != len(tt.args) || (len(args) > 0 && !reflect.DeepEqual(args, tt.args)) {
+				t.Fatalf("want args %v, got %v", tt.args, args)
+			}
+		})
+	}
+}
+
+// collatedOwnerMapper compares the document owner under the C collation.
+type collatedOwnerMapper struct {
+	docMapper
+}
+
+func (m collatedOwnerMapper) MapColumn(name string) (sqlizer.ColumnSpec, error) {
+	field, err := m.Map(name)
+	column := sqlizer.ColumnSpec{Column: field}
+	if name == "resource.owner" {
+		column.Type, column.Collation = sqlizer.TypeString, "C"
+	}
+	return column, err
+}
+
+type groupMapper struct {
+	docMapper
+}
+
+func (m groupMapper) MapColumn(name string) (sqlizer.ColumnSpec, error) {
+	if name == "resource.group" {
+		return sqlizer.ColumnSpec{Column: "document.group_id", Type: sqlizer.TypeEntity}, nil
+	}
+	field, err := m.Map(name)
+	return sqlizer.ColumnSpec{Column: field}, err
+}
+
+func TestAuthorizeSQLPrincipalParents(t *testing.T) {
+	t.Parallel()
+	psStr := `
+	permit(principal, action == Action::"ViewDocument", resource)
+	when {principal in resource.group};
+	`
+	ps, err := cedar.NewPolicySetFromBytes("", []byte(psStr))
+	if err != nil {
+		t.Fatal("new policy set error", err)
+	}
+	var entities types.EntityMap
+	err = entities.UnmarshalJSON([]byte(entitiesStr))
+	if err != nil {
+		t.Fatal("unmarshal entities error", err)
+	}
+	tests := []struct {
+		name         string
+		principal    string
+		maxExpansion int
+		want         string
+		args         []interface{}
+		err          error
+	}{
+		{
+			name:      "alice expands to alice and the admin group",
+			principal: "alice",
+			want:      "document.group_id IN (?, ?)",
+			args:      []interface{}{"alice", "admin"},
+		},
+		{
+			name:      "bob has no parents",
+			principal: "bob",
+			want:      "document.group_id IN (?)",
+			args:      []interface{}{"bob"},
+		},
+		{
+			name:         "expansion is capped",
+			principal:    "alice",
+			maxExpansion: 1,
+			err:          sqlizer.ErrMaxExpansion,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sql, args, err := AuthorizeSQL(ps, entities, &AuthorizeSQLRequest{
+				Principal:   cedar.NewEntityUID("User", cedar.String(tt.principal)),
+				Action:      cedar.NewEntityUID("Action", "ViewDocument"),
+				FieldMapper: groupMapper{},
+				Options:     Options{MaxExpansion: tt.maxExpansion},
+			})
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("want error %v, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("authorize sql error", err)
+			}
+			if sql != tt.want {
+				t.Fatalf("want %s, got %s", tt.want, sql)
+			}
+			if !reflect.DeepEqual(args, tt.args) {
+				t.Fatalf("want args %v, got %v", tt.args, args)
+			}
+		})
+	}
+}
+
+func TestAuthorizeSQLResourceType(t *testing.T) {
+	t.Parallel()
+	psStr := `
+	permit(principal, action == Action::"ViewDocument", resource is Document)
+	when {resource.owner == principal};
+	`
+	ps, err := cedar.NewPolicySetFromBytes("", []byte(psStr))
+	if err != nil {
+		t.Fatal("new policy set error", err)
+	}
+	var entities types.EntityMap
+	err = entities.UnmarshalJSON([]byte(entitiesStr))
+	if err != nil {
+		t.Fatal("unmarshal entities error", err)
+	}
+	sql, args, err := AuthorizeSQL(ps, entities, &AuthorizeSQLRequest{
+		Principal:   cedar.NewEntityUID("User", "bob"),
+		Action:      cedar.NewEntityUID("Action", "ViewDocument"),
+		FieldMapper: docMapper{},
+	})
+	if err != nil {
+		t.Fatal("authorize sql error", err)
+	}
+	want := "document.type = ? AND document.owner = ?"
+	if sql != want {
+		t.Fatalf("want %s, got %s", want, sql)
+	}
+	if !reflect.DeepEqual(args, []interface{}{"Document", "bob"}) {
+		t.Fatalf("want args [Document bob], got %v", args)
+	}
+}
+
+func TestAuthorizeSQLFlattensPolicies(t *testing.T) {
+	t.Parallel()
+	psStr := `
+	permit(principal, action == Action::"ViewDocument", resource)
+	when {resource.owner == "alice"};
+	permit(principal, action == Action::"ViewDocument", resource)
+	when {resource.owner == "bob"};
+	permit(principal, action == Action::"ViewDocument", resource)
+	when {resource.owner == "charlie"};
+	forbid(principal, action == Action::"ViewDocument", resource)
+	when {resource.team == "hr"};
+	forbid(principal, action == Action::"ViewDocument", resource)
+	when {resource.team == "legal"};
+	`
+	ps, err := cedar.NewPolicySetFromBytes("", []byte(psStr))
+	if err != nil {
+		t.Fatal("new policy set error", err)
+	}
+	sql, args, err := AuthorizeSQL(ps, types.EntityMap{}, &AuthorizeSQLRequest{
+		Principal:   cedar.NewEntityUID("User", "bob"),
+		Action:      cedar.NewEntityUID("Action", "ViewDocument"),
+		FieldMapper: docMapper{},
+	})
+	if err != nil {
+		t.Fatal("authorize sql error", err)
+	}
+	want := "(document.owner = ? OR document.owner = ? OR document.owner = ?) AND NOT (document.team = ? OR document.team = ?)"
+	if sql != want {
+		t.Fatalf("want %s, got %s", want, sql)
+	}
+	// the policies are combined in no particular order
+	owners := []interface{}{"alice", "bob", "charlie"}
+	teams := []interface{}{"hr", "legal"}
+	if len(args) != 5 || !sameElements(args[:3], owners) || !sameElements(args[3:], teams) {
+		t.Fatalf("want args %v %v, got %v", owners, teams, args)
+	}
+}
+
+func sameElements(a, b []interface{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, x := range a {
+		if !slices.Contains(b, x) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestAuthorizeSQLNoApplicablePolicy(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		policies string
+	}{
+		{name: "empty policy set"},
+		{
+			name: "policies for another action",
+			policies: `permit(principal, action == Action::"EditDocument", resource);
+			forbid(principal == User::"bob", action, resource);`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps, err := cedar.NewPolicySetFromBytes("", []byte(tt.policies))
+			if err != nil {
+				t.Fatal("new policy set error", err)
+			}
+			sql, args, err := AuthorizeSQL(ps, types.EntityMap{}, &AuthorizeSQLRequest{
+				Principal:    cedar.NewEntityUID("User", "alice"),
+				Action:       cedar.NewEntityUID("Action", "ViewDocument"),
+				FieldMapper:  docMapper{},
+				EmptyOnAllow: true,
+			})
+			if err != nil {
+				t.Fatal("authorize sql error", err)
+			}
+			if sql != "1 = 0" || args != nil {
+				t.Fatalf("want 1 = 0, got %q %v", sql, args)
+			}
+		})
+	}
+}
+
+func TestAuthorizeSQLWhenUnless(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name      string
+		policies  string
+		principal string
+		want      string
+		args      []interface{}
+	}{
+		{
+			name:      "when clauses are and'ed",
+			policies:  `permit(principal, action, resource) when {resource.owner == principal} when {resource.is_public == false};`,
+			principal: "bob",
+			want:      "document.owner = ? AND document.is_public = ?",
+			args:      []interface{}{"bob", false},
+		},
+		{
+			name: "forbid unless subtracts the rows it does not exempt",
+			policies: `permit(principal, action, resource) when {resource.owner == principal};
+			forbid(principal, action, resource) unless {resource.is_public};`,
+			principal: "bob",
+			want:      "document.owner = ? AND NOT (NOT (document.is_public))",
+			args:      []interface{}{"bob"},
+		},
+		{
+			name: "when and unless on both effects",
+			policies: `permit(principal, action, resource) when {resource.owner == principal} unless {resource.team == "x"};
+			forbid(principal, action, resource) when {resource.type == "secret"} unless {resource.is_public};`,
+			principal: "bob",
+			want:      "document.owner = ? AND NOT (document.team = ?) AND NOT (document.type = ? AND NOT (document.is_public))",
+			args:      []interface{}{"bob", "x", "secret"},
+		},
+		{
+			name: "forbid unless not met denies",
+			policies: `permit(principal, action, resource) when {resource.owner == principal};
+			forbid(principal, action, resource) unless {principal == User::"alice"};`,
+			principal: "bob",
+			want:      "1 = 0",
+		},
+		{
+			name: "forbid unless met leaves the permit",
+			policies: `permit(principal, action, resource) when {resource.owner == principal};
+			forbid(principal, action, resource) unless {principal == User::"alice"};`,
+			principal: "alice",
+			want:      "document.owner = ?",
+			args:      []interface{}{"alice"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps, err := cedar.NewPolicySetFromBytes("", []byte(tt.policies))
+			if err != nil {
+				t.Fatal("new policy set error", err)
+			}
+			sql, args, err := AuthorizeSQL(ps, types.EntityMap{}, &AuthorizeSQLRequest{
+				Principal:   cedar.NewEntityUID("User", cedar.String(tt.principal)),
+				Action:      cedar.NewEntityUID("Action", "ViewDocument"),
+				FieldMapper: docMapper{},
+			})
+			if err != nil {
+				t.Fatal("authorize sql error", err)
+			}
+			if sql != tt.want || !reflect.DeepEqual(args, tt.args) {
+				t.Fatalf("want %q %v, got %q %v", tt.want, tt.args, sql, args)
+			}
+		})
+	}
+}
+
+func TestAuthorizeSQLEmptyOnAllow(t *testing.T) {
+	t.Parallel()
+	ps, err := cedar.NewPolicySetFromBytes("", []byte(viewDocumentPolicies))
+	if err != nil {
+		t.Fatal("new policy set error", err)
+	}
+	var entities types.EntityMap
+	err = entities.UnmarshalJSON([]byte(entitiesStr))
+	if err != nil {
+		t.Fatal("unmarshal entities error", err)
+	}
+	tests := []struct {
+		principal string
+		want      string
+		args      []interface{}
+		decision  Decision
+	}{
+		{principal: "alice", want: "", decision: Allow},
+		{principal: "bob", want: "(document.owner = ? OR document.is_public = ?)", args: []interface{}{"bob", true}, decision: Conditional},
+		{principal: "charlie", want: "1 = 0", decision: Deny},
+	}
+	for _, tt := range tests {
+		t.Run(tt.principal, func(t *testing.T) {
+			req := &AuthorizeSQLRequest{
+				Principal: cedar.NewEntityUID("User", cedar.String(tt.principal)),
+				Action:    cedar.NewEntityUID("Action", "ViewDocument"),
+				Context: cedar.NewRecord(cedar.RecordMap{
+					"is_authenticated": cedar.True,
+				}),
+				FieldMapper:  docMapper{},
+				EmptyOnAllow: true,
+			}
+			sql, args, err := AuthorizeSQL(ps, entities, req)
+			if err != nil {
+				t.Fatal("authorize sql error", err)
+			}
+			if sql != tt.want || !reflect.DeepEqual(args, tt.args) {
+				t.Fatalf("want %q %v, got %q %v", tt.want, tt.args, sql, args)
+			}
+			decision, _, _, err := AuthorizeSQLDecision(ps, entities, req)
+			if err != nil {
+				t.Fatal("authorize sql decision error", err)
+			}
+			if decision != tt.decision {
+				t.Fatalf("want decision %v, got %v", tt.decision, decision)
+			}
+		})
+	}
+}
+
+func TestAuthorizeSQLConcreteResource(t *testing.T) {
+	t.Parallel()
+	ps, err := cedar.NewPolicySetFromBytes("", []byte(viewDocumentPolicies))
+	if err != nil {
+		t.Fatal("new policy set error", err)
+	}
+	var entities types.EntityMap
+	err = entities.UnmarshalJSON([]byte(entitiesStr))
+	if err != nil {
+		t.Fatal("unmarshal entities error", err)
+	}
+	for _, doc := range []types.Entity{
+		{
+			UID: cedar.NewEntityUID("Document", "bobs"),
+			Attributes: cedar.NewRecord(cedar.RecordMap{
+				"owner":     cedar.NewEntityUID("User", "bob"),
+				"is_public": cedar.False,
+			}),
+		},
+		{
+			UID: cedar.NewEntityUID("Document", "alices"),
+			Attributes: cedar.NewRecord(cedar.RecordMap{
+				"owner":     cedar.NewEntityUID("User", "alice"),
+				"is_public": cedar.False,
+			}),
+		},
+	} {
+		entities[doc.UID] = doc
+	}
+	tests := []struct {
+		resource string
+		want     string
+	}{
+		{resource: "bobs", want: "1 = 1"},
+		{resource: "alices", want: "1 = 0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.resource, func(t *testing.T) {
+			sql, args, err := AuthorizeSQL(ps, entities, &AuthorizeSQLRequest{
+				Principal: cedar.NewEntityUID("User", "bob"),
+				Action:    cedar.NewEntityUID("Action", "ViewDocument"),
+				Resource:  cedar.NewEntityUID("Document", cedar.String(tt.resource)),
+				Context: cedar.NewRecord(cedar.RecordMap{
+					"is_authenticated": cedar.True,
+				}),
+				FieldMapper: docMapper{},
+			})
+			if err != nil {
+				t.Fatal("authorize sql error", err)
+			}
+			if sql != tt.want || len(args) != 0 {
+				t.Fatalf("want %s, got %s %v", tt.want, sql, args)
+			}
+		})
+	}
+}
+
+func TestAuthorizeSQLForbidInFolder(t *testing.T) {
+	t.Parallel()
+	psStr := `
+	permit(principal, action, resource);
+	forbid(principal, action, resource in Folder::"secret");
+	`
+	ps, err := cedar.NewPolicySetFromBytes("", []byte(psStr))
+	if err != nil {
+		t.Fatal("new policy set error", err)
+	}
+	req := &AuthorizeSQLRequest{
+		Principal:   cedar.NewEntityUID("User", "bob"),
+		Action:      cedar.NewEntityUID("Action", "ViewDocument"),
+		FieldMapper: sqlizer.SafeMapper{"resource": "document.id", "resource.type": "document.type"},
+	}
+	// the documents below the folder can't be told apart without an ancestry
+	// column, the filter must not leave them in
+	if _, _, err := AuthorizeSQL(ps, types.EntityMap{}, req); !errors.Is(err, sqlizer.ErrUnsupportedIn) {
+		t.Fatalf("want %v, got %v", sqlizer.ErrUnsupportedIn, err)
+	}
+	req.FieldMapper = ancestryMapper{}
+	sql, args, err := AuthorizeSQL(ps, types.EntityMap{}, req)
+	if err != nil {
+		t.Fatal("authorize sql error", err)
+	}
+	want := "NOT (document.type = ? AND document.id IN (?) OR EXISTS (SELECT 1 FROM document_ancestors WHERE document_ancestors.document_id = document.id AND document_ancestors.ancestor IN (?)))"
+	wantArgs := []interface{}{"Folder", "secret", `Folder::"secret"`}
+	if sql != want || !reflect.DeepEqual(args, wantArgs) {
+		t.Fatalf("want %s %v, got %s %v", want, wantArgs, sql, args)
+	}
+}
+
+// ancestryMapper maps a document's id and type and its ancestors, kept in a
+// child table.
+type ancestryMapper struct{}
+
+func (m ancestryMapper) Map(name string) (string, error) {
+	column, err := m.MapColumn(name)
+	return column.Column, err
+}
+
+func (ancestryMapper) MapColumn(name string) (sqlizer.ColumnSpec, error) {
+	switch name {
+	case "resource":
+		return sqlizer.ColumnSpec{Column: "document.id"}, nil
+	case "resource.type":
+		return sqlizer.ColumnSpec{Column: "document.type"}, nil
+	case "resource." + sqlizer.AncestorsAttribute:
+		return sqlizer.ColumnSpec{Column: "document.id", Type: sqlizer.TypeTable, ElementKey: sqlizer.ElementUID, Table: &sqlizer.TableSpec{
+			Name: "document_ancestors", ForeignKey: "document_id", Element: "ancestor",
+		}}, nil
+	}
+	return sqlizer.ColumnSpec{}, fmt.Errorf("%s: %w", name, sqlizer.ErrInvalidFieldName)
+}
+
+func TestAuthorizeSQLResourceTypeScope(t *testing.T) {
+	t.Parallel()
+	psStr := `
+	permit(principal, action == Action::"ViewDocument", resource is Document)
+	when {resource.owner == principal};
+	permit(principal, action == Action::"ViewDocument", resource is Photo)
+	when {resource.album == "vacation"};
+	forbid(principal, action == Action::"ViewDocument", resource == Photo::"secret");
+	`
+	ps, err := cedar.NewPolicySetFromBytes("", []byte(psStr))
+	if err != nil {
+		t.Fatal("new policy set error", err)
+	}
+	tests := []struct {
+		resourceType cedar.EntityType
+		mapper       FieldMapper
+		want         string
+		args         []interface{}
+		columns      []string
+	}{
+		{
+			resourceType: "Document",
+			mapper:       docMapper{},
+			want:         "document.owner = ?",
+			args:         []interface{}{"bob"},
+			columns:      []string{"document.owner"},
+		},
+		{
+			resourceType: "Photo",
+			mapper:       sqlizer.SafeMapper{"resource.album": "photo.album", "resource": "photo.id"},
+			want:         "photo.album = ? AND NOT (photo.id = ?)",
+			args:         []interface{}{"vacation", "secret"},
+			columns:      []string{"photo.album", "photo.id"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.resourceType), func(t *testing.T) {
+			req := &AuthorizeSQLRequest{
+				Principal:    cedar.NewEntityUID("User", "bob"),
+				Action:       cedar.NewEntityUID("Action", "ViewDocument"),
+				ResourceType: tt.resourceType,
+				FieldMapper:  tt.mapper,
+			}
+			sql, args, err := AuthorizeSQL(ps, types.EntityMap{}, req)
+			if err != nil {
+				t.Fatal("authorize sql error", err)
+			}
+			if sql != tt.want || !reflect.DeepEqual(args, tt.args) {
+				t.Fatalf("want %s %v, got %s %v", tt.want, tt.args, sql, args)
+			}
+			columns, err := ReferencedColumns(ps, types.EntityMap{}, req)
+			if err != nil {
+				t.Fatal("referenced columns error", err)
+			}
+			want := map[cedar.EntityType][]string{tt.resourceType: tt.columns}
+			if !reflect.DeepEqual(columns, want) {
+				t.Fatalf("want columns %v, got %v", want, columns)
+			}
+		})
+	}
+}
+
+func TestAuthorizeSQLMissingContext(t *testing.T) {
+	t.Parallel()
+	psStr := `
+	permit(principal, action == Action::"ViewDocument", resource)
+	when {context.region == resource.region && context.tenant == resource.tenant};
+	`
+	ps, err := cedar.NewPolicySetFromBytes("", []byte(psStr))
+	if err != nil {
+		t.Fatal("new policy set error", err)
+	}
+	req := &AuthorizeSQLRequest{
+		Principal: cedar.NewEntityUID("User", "bob"),
+		Action:    cedar.NewEntityUID("Action", "ViewDocument"),
+		Context: cedar.NewRecord(cedar.RecordMap{
+			"region": cedar.String("eu"),
+		}),
+		FieldMapper: sqlizer.SafeMapper{
+			"resource.region": "document.region",
+			"resource.tenant": "document.tenant",
+			"context.tenant":  "session.tenant",
+		},
+	}
+	_, _, err = AuthorizeSQL(ps, types.EntityMap{}, req)
+	var perr *PolicyError
+	if !errors.As(err, &perr) {
+		t.Fatalf("want PolicyError for the missing attribute, got %v", err)
+	}
+
+	req.TreatMissingContextAsUnknown = true
+	sql, args, err := AuthorizeSQL(ps, types.EntityMap{}, req)
+	if err != nil {
+		t.Fatal("authorize sql error", err)
+	}
+	want := "? = document.region AND session.tenant = document.tenant"
+	if sql != want || !reflect.DeepEqual(args, []interface{}{"eu"}) {
+		t.Fatalf("want %s [eu], got %s %v", want, sql, args)
+	}
+}
+
+func TestAuthorizeSQLMissingContextPaths(t *testing.T) {
+	t.Parallel()
+	mapper := sqlizer.SafeMapper{
+		"resource.owner":  "document.owner",
+		"resource.tenant": "document.tenant",
+		"context.tenant":  "session.tenant",
+		"context.groups":  "session.groups",
+		"context.mfa":     "session.mfa",
+	}
+	tests := []struct {
+		name     string
+		policies string
+		opts     Options
+		want     string
+		args     []interface{}
+		residual string
+		err      error
+	}{
+		{
+			name: "forbid unless has a missing attribute checks the column",
+			policies: `permit(principal, action, resource) when { resource.owner == "x" };
+			forbid(principal, action, resource) unless { context has mfa };`,
+			want: "document.owner = ? AND NOT (NOT (session.mfa IS NOT NULL))",
+			args: []interface{}{"x"},
+		},
+		{
+			name:     "has a missing attribute checks the column",
+			policies: `permit(principal, action, resource) when { context has mfa };`,
+			want:     "session.mfa IS NOT NULL",
+			residual: "context has mfa",
+		},
+		{
+			name:     "has guards an access to a missing attribute",
+			policies: `permit(principal, action, resource) when { context has tenant && context.tenant == resource.tenant };`,
+			want:     "session.tenant IS NOT NULL AND session.tenant = document.tenant",
+			residual: "context has tenant AND context.tenant = resource.tenant",
+		},
+		{
+			name:     "access to a missing attribute maps the column",
+			policies: `permit(principal, action, resource) when { context.tenant == resource.tenant };`,
+			want:     "session.tenant = document.tenant",
+			residual: "context.tenant = resource.tenant",
+		},
+		{
+			name:     "has of an unmapped missing attribute is rejected",
+			policies: `permit(principal, action, resource) when { context has secret };`,
+			err:      sqlizer.ErrInvalidFieldName,
+		},
+		{
+			name:     "unmapped attribute in like is rejected",
+			policies: `permit(principal, action, resource) when { context.secret like "x*" };`,
+			err:      sqlizer.ErrInvalidFieldName,
+		},
+		{
+			name:     "isEmpty maps the attribute",
+			policies: `permit(principal, action, resource) when { context.groups.isEmpty() };`,
+			want:     "session.groups IS NULL",
+		},
+		{
+			name:     "id field is not appended to an attribute",
+			policies: `permit(principal, action, resource) when { context.tenant == resource.tenant };`,
+			opts:     Options{IDField: "id"},
+			want:     "session.tenant = document.tenant",
+			residual: "context.tenant = resource.tenant",
+		},
+		{
+			name: "forbid residual keeps its grouping",
+			policies: `permit(principal, action, resource);
+			forbid(principal, action, resource) when { resource.owner == "x" && resource.tenant == "y" };`,
+			want:     "NOT (document.owner = ? AND document.tenant = ?)",
+			args:     []interface{}{"x", "y"},
+			residual: `!(resource.owner = "x" AND resource.tenant = "y")`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps, err := cedar.NewPolicySetFromBytes("", []byte(tt.policies))
+			if err != nil {
+				t.Fatal("new policy set error", err)
+			}
+			sql, args, diagnostics, err := AuthorizeSQLDiagnostics(ps, types.EntityMap{}, &AuthorizeSQLRequest{
+				Principal:                    cedar.NewEntityUID("User", "bob"),
+				Action:                       cedar.NewEntityUID("Action", "ViewDocument"),
+				Context:                      cedar.NewRecord(cedar.RecordMap{"region": cedar.String("eu")}),
+				FieldMapper:                  mapper,
+				Options:                      tt.opts,
+				TreatMissingContextAsUnknown: true,
+			})
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("want error %v, got %v", tt.err, err)
+			}
+			if sql != tt.want || !reflect.DeepEqual(args, tt.args) {
+				t.Fatalf("want %q %v, got %q %v", tt.want, tt.args, sql, args)
+			}
+			if tt.residual != "" && diagnostics.Residual != tt.residual {
+				t.Fatalf("want residual %s, got %s", tt.residual, diagnostics.Residual)
+			}
+		})
+	}
+}
+
+func TestAuthorizeSQLDollar(t *testing.T) {
+	t.Parallel()
+	psStr := `
+	permit(principal, action == Action::"ViewDocument", resource)
+	when {resource.owner == principal && (resource.team == "eng" || resource.is_public == true)};
+	`
+	ps, err := cedar.NewPolicySetFromBytes("", []byte(psStr))
+	if err != nil {
+		t.Fatal("new policy set error", err)
+	}
+	sql, args, err := AuthorizeSQL(ps, types.EntityMap{}, &AuthorizeSQLRequest{
+		Principal:   cedar.NewEntityUID("User", "bob"),
+		Action:      cedar.NewEntityUID("Action", "ViewDocument"),
+		FieldMapper: docMapper{},
+		Options:     Options{Placeholder: sqlizer.Dollar},
+	})
+	if err != nil {
+		t.Fatal("authorize sql error", err)
+	}
+	want := "document.owner = $1 AND (document.team = $2 OR document.is_public = $3)"
+	if sql != want {
+		t.Fatalf("want %s, got %s", want, sql)
+	}
+	if !reflect.DeepEqual(args, []interface{}{"bob", "eng", true}) {
+		t.Fatalf("want args [bob eng true], got %v", args)
+	}
+}
+
+func TestAuthorizeSQLDecision(t *testing.T) {
+	t.Parallel()
+	ps, err := cedar.NewPolicySetFromBytes("", []byte(viewDocumentPolicies))
+	if err != nil {
+		t.Fatal("new policy set error", err)
+	}
+	var entities types.EntityMap
+	err = entities.UnmarshalJSON([]byte(entitiesStr))
+	if err != nil {
+		t.Fatal("unmarshal entities error", err)
+	}
+	tests := []struct {
+		principal string
+		want      Decision
+	}{
+		{principal: "alice", want: Allow},
+		{principal: "bob", want: Conditional},
+		{principal: "charlie", want: Deny},
+	}
+	for _, tt := range tests {
+		t.Run(tt.principal, func(t *testing.T) {
+			decision, _, _, err := AuthorizeSQLDecision(ps, entities, &AuthorizeSQLRequest{
+				Principal: cedar.NewEntityUID("User", cedar.String(tt.principal)),
+				Action:    cedar.NewEntityUID("Action", "ViewDocument"),
+				Context: cedar.NewRecord(cedar.RecordMap{
+					"is_authenticated": cedar.True,
+				}),
+				FieldMapper: docMapper{},
+			})
+			if err != nil {
+				t.Fatal("authorize sql error", err)
+			}
+			if decision != tt.want {
+				t.Fatalf("want %v, got %v", tt.want, decision)
+			}
+		})
+	}
+}
+
+func TestPolicyFilters(t *testing.T) {
+	t.Parallel()
+	psStr := `
+	permit(principal, action == Action::"ViewDocument", resource)
+	when {resource.owner == principal || resource.is_public == true};
+
+	permit(principal, action == Action::"ViewDocument", resource)
+	when {principal in Group::"admin"};
+
+	forbid(principal, action == Action::"ViewDocument", resource)
+	when {principal has block && principal.block == true};
+
+	forbid(principal, action == Action::"ViewDocument", resource)
+	when {resource.is_public == false};
+	`
+	ps, err := cedar.NewPolicySetFromBytes("", []byte(psStr))
+	if err != nil {
+		t.Fatal("new policy set error", err)
+	}
+	var entities types.EntityMap
+	err = entities.UnmarshalJSON([]byte(entitiesStr))
+	if err != nil {
+		t.Fatal("unmarshal entities error", err)
+	}
+	filters, err := PolicyFilters(ps, entities, &AuthorizeSQLRequest{
+		Principal:   cedar.NewEntityUID("User", "charlie"),
+		Action:      cedar.NewEntityUID("Action", "ViewDocument"),
+		FieldMapper: docMapper{},
+	})
+	if err != nil {
+		t.Fatal("policy filters error", err)
+	}
+	want := map[cedar.PolicyID]FilterResult{
+		"policy0": {Effect: cedar.Permit, SQL: "(document.owner = ? OR document.is_public = ?)", Args: []interface{}{"charlie", true}},
+		"policy2": {Effect: cedar.Forbid, SQL: "1 = 1"},
+		"policy3": {Effect: cedar.Forbid, SQL: "document.is_public = ?", Args: []interface{}{false}},
+	}
+	if !reflect.DeepEqual(filters, want) {
+		t.Fatalf("want %v, got %v", want, filters)
+	}
+}
+
+func TestAuthorizeSQLDiagnostics(t *testing.T) {
+	t.Parallel()
+	ps, err := cedar.NewPolicySetFromBytes("", []byte(viewDocumentPolicies))
+	if err != nil {
+		t.Fatal("new policy set error", err)
+	}
+	var entities types.EntityMap
+	err = entities.UnmarshalJSON([]byte(entitiesStr))
+	if err != nil {
+		t.Fatal("unmarshal entities error", err)
+	}
+	sql, _, diagnostics, err := AuthorizeSQLDiagnostics(ps, entities, &AuthorizeSQLRequest{
+		Principal: cedar.NewEntityUID("User", "bob"),
+		Action:    cedar.NewEntityUID("Action", "ViewDocument"),
+		Context: cedar.NewRecord(cedar.RecordMap{
+			"is_authenticated": cedar.True,
+		}),
+		FieldMapper: docMapper{},
+	})
+	if err != nil {
+		t.Fatal("authorize sql diagnostics error", err)
+	}
+	if want := "(document.owner = ? OR document.is_public = ?)"; sql != want {
+		t.Fatalf("want %s, got %s", want, sql)
+	}
+	if want := `(resource.owner = User::"bob" OR resource.is_public = true)`; diagnostics.Residual != want {
+		t.Fatalf("want residual %s, got %s", want, diagnostics.Residual)
+	}
+}
+
+func TestResidualNode(t *testing.T) {
+	t.Parallel()
+	psStr := `
+	permit(principal, action == Action::"ViewDocument", resource)
+	when {resource.owner == principal};
+	`
+	ps, err := cedar.NewPolicySetFromBytes("", []byte(psStr))
+	if err != nil {
+		t.Fatal("new policy set error", err)
+	}
+	var entities types.EntityMap
+	err = entities.UnmarshalJSON([]byte(entitiesStr))
+	if err != nil {
+		t.Fatal("unmarshal entities error", err)
+	}
+	node, env, err := ResidualNode(ps, entities, &AuthorizeSQLRequest{
+		Principal: cedar.NewEntityUID("User", "bob"),
+		Action:    cedar.NewEntityUID("Action", "ViewDocument"),
+	})
+	if err != nil {
+		t.Fatal("residual node error", err)
+	}
+	// scope the filter to a tenant at the AST level before rendering it
+	scoped := ast.NewNode(node).And(ast.Resource().Access("tenant_id").Equal(ast.String("acme")))
+	sql, args, err := sqlizer.ToSql(scoped.AsIsNode(), env, DefaultFieldMapper)
+	if err != nil {
+		t.Fatal("to sql error", err)
+	}
+	want := "resource.owner = ? AND resource.tenant_id = ?"
+	if sql != want {
+		t.Fatalf("want %s, got %s", want, sql)
+	}
+	if !reflect.DeepEqual(args, []interface{}{"bob", "acme"}) {
+		t.Fatalf("want args [bob acme], got %v", args)
+	}
+}
+
+func TestReferencedColumns(t *testing.T) {
+	t.Parallel()
+	psStr := `
+	permit(principal, action == Action::"ViewDocument", resource is Document)
+	when {resource.owner == principal || resource.is_public == true};
+
+	permit(principal, action == Action::"ViewDocument", resource)
+	when {resource.is_public == true};
+
+	permit(principal, action == Action::"ViewDocument", resource is Photo)
+	when {principal in Group::"admin"};
+	`
+	ps, err := cedar.NewPolicySetFromBytes("", []byte(psStr))
+	if err != nil {
+		t.Fatal("new policy set error", err)
+	}
+	var entities types.EntityMap
+	err = entities.UnmarshalJSON([]byte(entitiesStr))
+	if err != nil {
+		t.Fatal("unmarshal entities error", err)
+	}
+	columns, err := ReferencedColumns(ps, entities, &AuthorizeSQLRequest{
+		Principal:   cedar.NewEntityUID("User", "bob"),
+		Action:      cedar.NewEntityUID("Action", "ViewDocument"),
+		FieldMapper: docMapper{},
+	})
+	if err != nil {
+		t.Fatal("referenced columns error", err)
+	}
+	want := map[cedar.EntityType][]string{
+		"Document": {"document.is_public", "document.owner", "document.type"},
+		"":         {"document.is_public"},
+	}
+	if !reflect.DeepEqual(columns, want) {
+		t.Fatalf("want %v, got %v", want, columns)
+	}
+
+	columns, err = ReferencedColumns(ps, entities, &AuthorizeSQLRequest{
+		Principal:   cedar.NewEntityUID("User", "bob"),
+		Action:      cedar.NewEntityUID("Action", "ViewDocument"),
+		FieldMapper: docMapper{},
+		Options:     Options{IdentifierCase: sqlizer.IdentifierPreserve},
+	})
+	if err != nil {
+		t.Fatal("referenced columns error", err)
+	}
+	want = map[cedar.EntityType][]string{
+		"Document": {`"document"."is_public"`, `"document"."owner"`, `"document"."type"`},
+		"":         {`"document"."is_public"`},
+	}
+	if !reflect.DeepEqual(columns, want) {
+		t.Fatalf("want %v, got %v", want, columns)
+	}
+}
+
+func TestAuthorizeSQLPolicyError(t *testing.T) {
+	t.Parallel()
+	psStr := `
+	permit(principal, action == Action::"ViewDocument", resource)
+	when {resource.owner == principal};
+
+	forbid(principal, action == Action::"ViewDocument", resource)
+	when {resource.ip.isLoopback()};
+	`
+	ps, err := cedar.NewPolicySetFromBytes("", []byte(psStr))
+	if err != nil {
+		t.Fatal("new policy set error", err)
+	}
+	var entities types.EntityMap
+	err = entities.UnmarshalJSON([]byte(entitiesStr))
+	if err != nil {
+		t.Fatal("unmarshal entities error", err)
+	}
+	_, _, err = AuthorizeSQL(ps, entities, &AuthorizeSQLRequest{
+		Principal: cedar.NewEntityUID("User", "bob"),
+		Action:    cedar.NewEntityUID("Action", "ViewDocument"),
+	})
+	var perr *PolicyError
+	if !errors.As(err, &perr) {
+		t.Fatalf("want PolicyError, got %v", err)
+	}
+	if perr.Effect != cedar.Forbid || perr.PolicyID != "policy1" {
+		t.Fatalf("want forbid policy1, got %v %v", perr.Effect, perr.PolicyID)
+	}
+	if !strings.HasPrefix(err.Error(), "forbid policy policy1 contains unsupported construct") {
+		t.Fatalf("unexpected message %q", err.Error())
+	}
+}
+
+func TestAuthorizeSQLUnmappedField(t *testing.T) {
+	t.Parallel()
+	psStr := `
+	permit(principal, action == Action::"ViewDocument", resource)
+	when {resource.ownerr == principal};
+	`
+	ps, err := cedar.NewPolicySetFromBytes("", []byte(psStr))
+	if err != nil {
+		t.Fatal("new policy set error", err)
+	}
+	_, _, err = AuthorizeSQL(ps, types.EntityMap{}, &AuthorizeSQLRequest{
+		Principal:   cedar.NewEntityUID("User", "bob"),
+		Action:      cedar.NewEntityUID("Action", "ViewDocument"),
+		FieldMapper: docMapper{},
+	})
+	if !errors.Is(err, sqlizer.ErrInvalidFieldName) {
+		t.Fatalf("want %v, got %v", sqlizer.ErrInvalidFieldName, err)
+	}
+	var perr *PolicyError
+	if !errors.As(err, &perr) || perr.PolicyID != "policy0" {
+		t.Fatalf("want PolicyError for policy0, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "resource.ownerr") {
+		t.Fatalf("want the unmapped field in %q", err.Error())
+	}
+}
+
+func TestAuthorizeSQLContextCanceled(t *testing.T) {
+	t.Parallel()
+	ps, err := cedar.NewPolicySetFromBytes("", []byte(viewDocumentPolicies))
+	if err != nil {
+		t.Fatal("new policy set error", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err = AuthorizeSQLContext(ctx, ps, types.EntityMap{}, &AuthorizeSQLRequest{
+		Principal:   cedar.NewEntityUID("User", "bob"),
+		Action:      cedar.NewEntityUID("Action", "ViewDocument"),
+		FieldMapper: docMapper{},
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("want context.Canceled, got %v", err)
+	}
+}
+
+func TestAuthorizeSQLContributions(t *testing.T) {
+	t.Parallel()
+	ps, err := cedar.NewPolicySetFromBytes("", []byte(viewDocumentPolicies))
+	if err != nil {
+		t.Fatal("new policy set error", err)
+	}
+	var entities types.EntityMap
+	err = entities.UnmarshalJSON([]byte(entitiesStr))
+	if err != nil {
+		t.Fatal("unmarshal entities error", err)
+	}
+	tests := []struct {
+		principal string
+		want      []PolicyContribution
+	}{
+		{
+			principal: "alice",
+			want:      []PolicyContribution{{PolicyID: "policy1", Effect: cedar.Permit, SQL: "1 = 1"}},
+		},
+		{
+			principal: "bob",
+			want: []PolicyContribution{{
+				PolicyID: "policy0",
+				Effect:   cedar.Permit,
+				SQL:      "(document.owner = ? OR document.is_public = ?)",
+				Args:     []interface{}{"bob", true},
+			}},
+		},
+		{
+			principal: "charlie",
+			want:      []PolicyContribution{{PolicyID: "policy3", Effect: cedar.Forbid, SQL: "1 = 1"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.principal, func(t *testing.T) {
+			_, _, contributions, err := AuthorizeSQLContributions(ps, entities, &AuthorizeSQLRequest{
+				Principal: cedar.NewEntityUID("User", cedar.String(tt.principal)),
+				Action:    cedar.NewEntityUID("Action", "ViewDocument"),
+				Context: cedar.NewRecord(cedar.RecordMap{
+					"is_authenticated": cedar.True,
+				}),
+				FieldMapper: docMapper{},
+			})
+			if err != nil {
+				t.Fatal("authorize sql error", err)
+			}
+			if !reflect.DeepEqual(contributions, tt.want) {
+				t.Fatalf("want %v, got %v", tt.want, contributions)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package cedarsqlizer
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/cedar-policy/cedar-go"
+	"github.com/cedar-policy/cedar-go/x/exp/ast"
+)
+
+// CompiledPolicySet is a snapshot of a policy set for repeated AuthorizeSQL
+// calls. Nothing is evaluated ahead of a request: it only drops the policies
+// whose action scope is an `action ==` another action, remembering the
+// remaining list per action, so a request skips those policies. Every call
+// still partially evaluates and renders the policies that are left, as
+// AuthorizeSQL does. It is safe for concurrent use.
+type CompiledPolicySet struct {
+	policies []compiledPolicy
+
+	mu       sync.RWMutex
+	byAction map[cedar.EntityUID][]compiledPolicy
+}
+
+// maxCachedActions bounds byAction, as req.Action comes from the caller.
+// Actions seen after it is full are filtered on every call.
+const maxCachedActions = 1024
+
+type compiledPolicy struct {
+	id     cedar.PolicyID
+	policy *cedar.Policy
+}
+
+// Compile snapshots policies; later changes to the set are not seen.
+func Compile(policies cedar.PolicyIterator) *CompiledPolicySet {
+	var compiled []compiledPolicy
+	for pid, p := range policies.All() {
+		compiled = append(compiled, compiledPolicy{id: pid, policy: p})
+	}
+	slices.SortFunc(compiled, func(a, b compiledPolicy) int {
+		return strings.Compare(string(a.id), string(b.id))
+	})
+	return &CompiledPolicySet{policies: compiled, byAction: make(map[cedar.EntityUID][]compiledPolicy)}
+}
+
+// AuthorizeSQL is cedarsqlizer.AuthorizeSQL over the compiled policies.
+func (c *CompiledPolicySet) AuthorizeSQL(entities cedar.EntityGetter, req *AuthorizeSQLRequest) (string, []interface{}, error) {
+	return c.AuthorizeSQLContext(context.Background(), entities, req)
+}
+
+// AuthorizeSQLContext is cedarsqlizer.AuthorizeSQLContext over the compiled
+// policies.
+func (c *CompiledPolicySet) AuthorizeSQLContext(ctx context.Context, entities cedar.EntityGetter, req *AuthorizeSQLRequest) (string, []interface{}, error) {
+	return authorizeSQL(ctx, c.forAction(req.Action), entities, req)
+}
+
+// Result is the filter AuthorizeSQLBatch produced for one request.
+type Result struct {
+	SQL  string
+	Args []interface{}
+}
+
+// AuthorizeSQLBatch authorizes every request against the compiled policies
+// and the same entities, e.g. to precompute the filters of many users. The
+// results are in the order of reqs. It stops at the first request that
+// fails, reporting its index.
+func AuthorizeSQLBatch(compiled *CompiledPolicySet, entities cedar.EntityGetter, reqs []*AuthorizeSQLRequest) ([]Result, error) {
+	results := make([]Result, len(reqs))
+	for i, req := range reqs {
+		sql, args, err := compiled.AuthorizeSQL(entities, req)
+		if err != nil {
+			return nil, fmt.Errorf("request %d: %w", i, err)
+		}
+		results[i] = Result{SQL: sql, Args: args}
+	}
+	return results, nil
+}
+
+// forAction yields the policies that may apply to action.
+func (c *CompiledPolicySet) forAction(action cedar.EntityUID) iter.Seq2[cedar.PolicyID, *cedar.Policy] {
+	c.mu.RLock()
+	policies, ok := c.byAction[action]
+	c.mu.RUnlock()
+	if !ok {
+		for _, p := range c.policies {
+			if mayApply(p.policy.AST().Action, action) {
+				policies = append(policies, p)
+			}
+		}
+		c.mu.Lock()
+		if len(c.byAction) < maxCachedActions {
+			c.byAction[action] = policies
+		}
+		c.mu.Unlock()
+	}
+	return func(yield func(cedar.PolicyID, *cedar.Policy) bool) {
+		for _, p := range policies {
+			if !yield(p.id, p.policy) {
+				return
+			}
+		}
+	}
+}
+
+// mayApply reports whether an action scope could match action. Only an
+// equality scope can be ruled out without the entities, an `in` scope may be
+// satisfied through the action's parents.
+func mayApply(scope ast.IsActionScopeNode, action cedar.EntityUID) bool {
+	if s, ok := scope.(ast.ScopeTypeEq); ok {
+		return s.Entity == action
+	}
+	return true
+}
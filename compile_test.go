@@ -0,0 +1,141 @@
+package cedarsqlizer
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/cedar-policy/cedar-go"
+	"github.com/cedar-policy/cedar-go/types"
+	"github.com/jaredzhou/cedar-sqlizer/sqlizer"
+)
+
+func TestCompiledPolicySet(t *testing.T) {
+	t.Parallel()
+	ps, err := cedar.NewPolicySetFromBytes("", []byte(viewDocumentPolicies))
+	if err != nil {
+		t.Fatal("new policy set error", err)
+	}
+	var entities types.EntityMap
+	err = entities.UnmarshalJSON([]byte(entitiesStr))
+	if err != nil {
+		t.Fatal("unmarshal entities error", err)
+	}
+	compiled := Compile(ps)
+	tests := []struct {
+		principal     string
+		action        string
+		authenticated bool
+		want          string
+		args          []interface{}
+	}{
+		{principal: "alice", action: "ViewDocument", authenticated: true, want: "1 = 1"},
+		{principal: "bob", action: "ViewDocument", authenticated: true, want: "(document.owner = ? OR document.is_public = ?)", args: []interface{}{"bob", true}},
+		{principal: "charlie", action: "ViewDocument", authenticated: true, want: "1 = 0"},
+		{principal: "unauthenticated", action: "ViewDocument", want: "document.is_public = ?", args: []interface{}{true}},
+		{principal: "bob", action: "EditDocument", authenticated: true, want: "1 = 0"},
+	}
+	// run every request from several goroutines, sharing the action cache
+	var wg sync.WaitGroup
+	for range 4 {
+		for _, tt := range tests {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				sql, args, err := compiled.AuthorizeSQL(entities, &AuthorizeSQLRequest{
+					Principal: cedar.NewEntityUID("User", cedar.String(tt.principal)),
+					Action:    cedar.NewEntityUID("Action", cedar.String(tt.action)),
+					Context: cedar.NewRecord(cedar.RecordMap{
+						"is_authenticated": cedar.Boolean(tt.authenticated),
+					}),
+					FieldMapper: docMapper{},
+				})
+				if err != nil {
+					t.Error("authorize sql error", err)
+					return
+				}
+				if sql != tt.want {
+					t.Errorf("%s %s: want %s, got %s", tt.principal, tt.action, tt.want, sql)
+				}
+				if !reflect.DeepEqual(args, tt.args) {
+					t.Errorf("%s %s: want args %v, got %v", tt.principal, tt.action, tt.args, args)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+}
+
+func TestAuthorizeSQLBatch(t *testing.T) {
+	t.Parallel()
+	ps, err := cedar.NewPolicySetFromBytes("", []byte(viewDocumentPolicies))
+	if err != nil {
+		t.Fatal("new policy set error", err)
+	}
+	var entities types.EntityMap
+	err = entities.UnmarshalJSON([]byte(entitiesStr))
+	if err != nil {
+		t.Fatal("unmarshal entities error", err)
+	}
+	var reqs []*AuthorizeSQLRequest
+	for _, principal := range []string{"alice", "bob", "charlie"} {
+		reqs = append(reqs, &AuthorizeSQLRequest{
+			Principal: cedar.NewEntityUID("User", cedar.String(principal)),
+			Action:    cedar.NewEntityUID("Action", "ViewDocument"),
+			Context: cedar.NewRecord(cedar.RecordMap{
+				"is_authenticated": cedar.True,
+			}),
+			FieldMapper: docMapper{},
+		})
+	}
+	results, err := AuthorizeSQLBatch(Compile(ps), entities, reqs)
+	if err != nil {
+		t.Fatal("authorize sql batch error", err)
+	}
+	want := []Result{
+		{SQL: "1 = 1"},
+		{SQL: "(document.owner = ? OR document.is_public = ?)", Args: []interface{}{"bob", true}},
+		{SQL: "1 = 0"},
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Fatalf("want %v, got %v", want, results)
+	}
+
+	reqs[1].FieldMapper = sqlizer.SafeMapper{}
+	_, err = AuthorizeSQLBatch(Compile(ps), entities, reqs)
+	if !errors.Is(err, sqlizer.ErrInvalidFieldName) || !strings.HasPrefix(err.Error(), "request 1: ") {
+		t.Fatalf("want request 1 invalid field name, got %v", err)
+	}
+}
+
+func TestCompiledPolicySetActionCacheBound(t *testing.T) {
+	t.Parallel()
+	ps, err := cedar.NewPolicySetFromBytes("", []byte(viewDocumentPolicies))
+	if err != nil {
+		t.Fatal("new policy set error", err)
+	}
+	compiled := Compile(ps)
+	for i := range maxCachedActions + 10 {
+		compiled.forAction(cedar.NewEntityUID("Action", cedar.String(fmt.Sprint("Action", i))))
+	}
+	if len(compiled.byAction) != maxCachedActions {
+		t.Fatalf("want %d cached actions, got %d", maxCachedActions, len(compiled.byAction))
+	}
+	// actions past the bound are still filtered
+	count := func(action string) int {
+		n := 0
+		for range compiled.forAction(cedar.NewEntityUID("Action", cedar.String(action))) {
+			n++
+		}
+		return n
+	}
+	if n := count("ViewDocument"); n != len(compiled.policies) {
+		t.Fatalf("want %d policies for ViewDocument, got %d", len(compiled.policies), n)
+	}
+	if n := count("EditDocument"); n != 0 {
+		t.Fatalf("want no policies for EditDocument, got %d", n)
+	}
+}
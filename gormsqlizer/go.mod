@@ -0,0 +1,19 @@
+module github.com/jaredzhou/cedar-sqlizer/gormsqlizer
+
+go 1.24.3
+
+replace github.com/jaredzhou/cedar-sqlizer => ../
+
+require (
+	github.com/cedar-policy/cedar-go v1.2.6
+	github.com/jaredzhou/cedar-sqlizer v0.0.0-00010101000000-000000000000
+	gorm.io/gorm v1.31.2
+)
+
+require (
+	github.com/jinzhu/inflection v1.0.0 // indirect
+	github.com/jinzhu/now v1.1.5 // indirect
+	github.com/lib/pq v1.10.9 // indirect
+	golang.org/x/exp v0.0.0-20220921023135-46d9e7742f1e // indirect
+	golang.org/x/text v0.24.0 // indirect
+)
@@ -0,0 +1,37 @@
+// Package gormsqlizer applies cedar row filters to GORM queries. It lives in
+// its own package so only callers that use GORM depend on it.
+package gormsqlizer
+
+import (
+	"github.com/cedar-policy/cedar-go"
+	cedarsqlizer "github.com/jaredzhou/cedar-sqlizer"
+	"github.com/jaredzhou/cedar-sqlizer/sqlizer"
+	"gorm.io/gorm"
+)
+
+// Scope returns a GORM scope filtering the query down to the rows req may
+// see, e.g. `db.Scopes(Scope(ps, entities, req)).Find(&docs)`. A filter that
+// lets every row through adds nothing, one that denies every row adds
+// `1 = 0`, and an authorization error is added to the query's errors.
+//
+// GORM binds every "?" it finds, so filters using operators that contain a
+// question mark, such as the Postgres jsonb `?|`, cannot go through it.
+func Scope(policies cedar.PolicyIterator, entities cedar.EntityGetter, req *cedarsqlizer.AuthorizeSQLRequest) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		question := *req
+		question.Options.Placeholder = sqlizer.Question
+		question.Options.StartIndex = 0
+		decision, sql, args, err := cedarsqlizer.AuthorizeSQLDecision(policies, entities, &question)
+		if err != nil {
+			db.AddError(err)
+			return db
+		}
+		switch decision {
+		case cedarsqlizer.Allow:
+			return db
+		case cedarsqlizer.Deny:
+			return db.Where(sql)
+		}
+		return db.Where(sql, args...)
+	}
+}
@@ -0,0 +1,118 @@
+package gormsqlizer
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/cedar-policy/cedar-go"
+	"github.com/cedar-policy/cedar-go/types"
+	cedarsqlizer "github.com/jaredzhou/cedar-sqlizer"
+	"github.com/jaredzhou/cedar-sqlizer/sqlizer"
+	"gorm.io/gorm"
+	gormtests "gorm.io/gorm/utils/tests"
+)
+
+type document struct {
+	ID       int
+	Owner    string
+	IsPublic bool
+}
+
+func TestScope(t *testing.T) {
+	t.Parallel()
+	psStr := `
+	permit(principal, action == Action::"ViewDocument", resource)
+	when {resource.owner == principal || resource.is_public == true};
+
+	permit(principal in Group::"admin", action == Action::"ViewDocument", resource);
+
+	forbid(principal, action == Action::"ViewDocument", resource)
+	when {principal has block && principal.block == true};
+	`
+	ps, err := cedar.NewPolicySetFromBytes("", []byte(psStr))
+	if err != nil {
+		t.Fatal("new policy set error", err)
+	}
+	entities := types.EntityMap{
+		cedar.NewEntityUID("User", "alice"): {
+			UID:     cedar.NewEntityUID("User", "alice"),
+			Parents: types.NewEntityUIDSet(cedar.NewEntityUID("Group", "admin")),
+		},
+		cedar.NewEntityUID("User", "charlie"): {
+			UID:        cedar.NewEntityUID("User", "charlie"),
+			Attributes: cedar.NewRecord(cedar.RecordMap{"block": cedar.True}),
+		},
+	}
+	db, err := gorm.Open(gormtests.DummyDialector{}, &gorm.Config{DryRun: true})
+	if err != nil {
+		t.Fatal("open error", err)
+	}
+	tests := []struct {
+		principal string
+		want      string
+		vars      []interface{}
+	}{
+		{
+			principal: "alice",
+			want:      "SELECT * FROM `documents`",
+		},
+		{
+			principal: "bob",
+			want:      "SELECT * FROM `documents` WHERE (documents.owner = ? OR documents.is_public = ?)",
+			vars:      []interface{}{"bob", true},
+		},
+		{
+			principal: "charlie",
+			want:      "SELECT * FROM `documents` WHERE 1 = 0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.principal, func(t *testing.T) {
+			var docs []document
+			stmt := db.Scopes(Scope(ps, entities, &cedarsqlizer.AuthorizeSQLRequest{
+				Principal:   cedar.NewEntityUID("User", cedar.String(tt.principal)),
+				Action:      cedar.NewEntityUID("Action", "ViewDocument"),
+				FieldMapper: sqlizer.WithTableAlias("resource", "documents"),
+				Options:     cedarsqlizer.Options{Placeholder: sqlizer.Dollar},
+			})).Find(&docs).Statement
+			if stmt.Error != nil {
+				t.Fatal("find error", stmt.Error)
+			}
+			if got := stmt.SQL.String(); got != tt.want {
+				t.Fatalf("want %s, got %s", tt.want, got)
+			}
+			if len(stmt.Vars) != len(tt.vars) {
+				t.Fatalf("want vars %v, got %v", tt.vars, stmt.Vars)
+			}
+			for i, v := range tt.vars {
+				if stmt.Vars[i] != v {
+					t.Fatalf("want vars %v, got %v", tt.vars, stmt.Vars)
+				}
+			}
+		})
+	}
+}
+
+func TestScopeError(t *testing.T) {
+	t.Parallel()
+	ps, err := cedar.NewPolicySetFromBytes("", []byte(`
+	permit(principal, action == Action::"ViewDocument", resource)
+	when {resource.ip.isLoopback()};
+	`))
+	if err != nil {
+		t.Fatal("new policy set error", err)
+	}
+	db, err := gorm.Open(gormtests.DummyDialector{}, &gorm.Config{DryRun: true})
+	if err != nil {
+		t.Fatal("open error", err)
+	}
+	var docs []document
+	err = db.Scopes(Scope(ps, types.EntityMap{}, &cedarsqlizer.AuthorizeSQLRequest{
+		Principal: cedar.NewEntityUID("User", "bob"),
+		Action:    cedar.NewEntityUID("Action", "ViewDocument"),
+	})).Find(&docs).Error
+	var perr *cedarsqlizer.PolicyError
+	if !errors.As(err, &perr) {
+		t.Fatalf("want PolicyError, got %v", err)
+	}
+}
@@ -0,0 +1,20 @@
+module github.com/jaredzhou/cedar-sqlizer/pgxsqlizer
+
+go 1.24.3
+
+replace github.com/jaredzhou/cedar-sqlizer => ../
+
+require (
+	github.com/cedar-policy/cedar-go v1.2.6
+	github.com/jackc/pgx/v5 v5.7.6
+	github.com/jaredzhou/cedar-sqlizer v0.0.0-00010101000000-000000000000
+)
+
+require (
+	github.com/jackc/pgpassfile v1.0.0 // indirect
+	github.com/jackc/pgservicefile v0.0.0-20240606120523-5a60cdf6a761 // indirect
+	github.com/lib/pq v1.10.9 // indirect
+	golang.org/x/crypto v0.37.0 // indirect
+	golang.org/x/exp v0.0.0-20220921023135-46d9e7742f1e // indirect
+	golang.org/x/text v0.24.0 // indirect
+)
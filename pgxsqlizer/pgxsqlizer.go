@@ -0,0 +1,47 @@
+// Package pgxsqlizer renders cedar row filters for pgx v5, with "@name"
+// placeholders bound through pgx.NamedArgs. It lives in its own package so
+// only callers that use pgx depend on it.
+package pgxsqlizer
+
+import (
+	"strconv"
+
+	"github.com/cedar-policy/cedar-go"
+	"github.com/cedar-policy/cedar-go/x/exp/ast"
+	"github.com/cedar-policy/cedar-go/x/exp/eval"
+	"github.com/jackc/pgx/v5"
+	cedarsqlizer "github.com/jaredzhou/cedar-sqlizer"
+	"github.com/jaredzhou/cedar-sqlizer/sqlizer"
+)
+
+// AuthorizeSQL is cedarsqlizer.AuthorizeSQL rendering named placeholders.
+// req.Options.StartIndex offsets the names as it offsets numbered placeholders.
+func AuthorizeSQL(policies cedar.PolicyIterator, entities cedar.EntityGetter, req *cedarsqlizer.AuthorizeSQLRequest) (string, pgx.NamedArgs, error) {
+	named := *req
+	named.Options.Placeholder = sqlizer.Named
+	sql, args, err := cedarsqlizer.AuthorizeSQL(policies, entities, &named)
+	if err != nil {
+		return "", nil, err
+	}
+	return sql, NamedArgs(args, named.Options.StartIndex), nil
+}
+
+// ToSql is sqlizer.ToSqlWithOptions rendering named placeholders.
+func ToSql(node ast.IsNode, env eval.Env, mapper sqlizer.FieldMapper, opts sqlizer.Options) (string, pgx.NamedArgs, error) {
+	opts.Placeholder = sqlizer.Named
+	sql, args, err := sqlizer.ToSqlWithOptions(node, env, mapper, opts)
+	if err != nil {
+		return "", nil, err
+	}
+	return sql, NamedArgs(args, opts.StartIndex), nil
+}
+
+// NamedArgs names positional args the way sqlizer.Named writes their
+// placeholders.
+func NamedArgs(args []interface{}, startIndex int) pgx.NamedArgs {
+	named := make(pgx.NamedArgs, len(args))
+	for i, arg := range args {
+		named[sqlizer.NamedPrefix[1:]+strconv.Itoa(startIndex+i+1)] = arg
+	}
+	return named
+}
@@ -0,0 +1,59 @@
+package pgxsqlizer
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/cedar-policy/cedar-go"
+	"github.com/cedar-policy/cedar-go/types"
+	"github.com/jackc/pgx/v5"
+	cedarsqlizer "github.com/jaredzhou/cedar-sqlizer"
+)
+
+func TestAuthorizeSQL(t *testing.T) {
+	t.Parallel()
+	psStr := `
+	permit(principal, action == Action::"ViewDocument", resource)
+	when {resource.owner == principal || resource.is_public == true};
+	`
+	ps, err := cedar.NewPolicySetFromBytes("", []byte(psStr))
+	if err != nil {
+		t.Fatal("new policy set error", err)
+	}
+	tests := []struct {
+		name       string
+		startIndex int
+		want       string
+		args       pgx.NamedArgs
+	}{
+		{
+			name: "named",
+			want: "(resource.owner = @p1 OR resource.is_public = @p2)",
+			args: pgx.NamedArgs{"p1": "bob", "p2": true},
+		},
+		{
+			name:       "named from start index",
+			startIndex: 1,
+			want:       "(resource.owner = @p2 OR resource.is_public = @p3)",
+			args:       pgx.NamedArgs{"p2": "bob", "p3": true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sql, args, err := AuthorizeSQL(ps, types.EntityMap{}, &cedarsqlizer.AuthorizeSQLRequest{
+				Principal: cedar.NewEntityUID("User", "bob"),
+				Action:    cedar.NewEntityUID("Action", "ViewDocument"),
+				Options:   cedarsqlizer.Options{StartIndex: tt.startIndex},
+			})
+			if err != nil {
+				t.Fatal("authorize sql error", err)
+			}
+			if sql != tt.want {
+				t.Fatalf("want %s, got %s", tt.want, sql)
+			}
+			if !reflect.DeepEqual(args, tt.args) {
+				t.Fatalf("want args %v, got %v", tt.args, args)
+			}
+		})
+	}
+}
@@ -0,0 +1,250 @@
+package sqlizer
+
+import (
+	"slices"
+
+	"github.com/cedar-policy/cedar-go/x/exp/ast"
+	"github.com/cedar-policy/cedar-go/x/exp/eval"
+)
+
+// Columns returns the sorted, de-duplicated mapped column names that the SQL
+// for node could reference. It is a static walk over the residual AST: every
+// attribute path rooted at a remaining variable is resolved through mapper,
+// the same way ToSql resolves it, so it can be used to plan indexes.
+func Columns(node ast.IsNode, mapper FieldMapper) ([]string, error) {
+	return ColumnsWithOptions(node, mapper, Options{})
+}
+
+// ColumnsWithOptions is Columns resolving paths the way ToSqlWithOptions
+// does with opts, so the names carry opts.IdentifierCase unless the mapper
+// marks a column Raw. A bare remaining variable compared as an entity, e.g.
+// `resource == Photo::"a"`, is its column, the one of opts.IDField when set,
+// and `in` a concrete entity also references the ancestry and type columns
+// ToSql checks.
+func ColumnsWithOptions(node ast.IsNode, mapper FieldMapper, opts Options) ([]string, error) {
+	var columns []string
+	var err error
+	add := func(path string) ColumnSpec {
+		if path == "" || err != nil {
+			return ColumnSpec{}
+		}
+		var column ColumnSpec
+		column, err = mapColumn(mapper, path, &opts)
+		if err == nil && !slices.Contains(columns, column.Column) {
+			columns = append(columns, column.Column)
+		}
+		return column
+	}
+	entities := func(nodes ...ast.IsNode) {
+		for _, n := range nodes {
+			add(operandColumn(n, &opts))
+		}
+	}
+	// in adds the columns of `left in right` for a concrete right, the type
+	// column only when left is not known to be of one type
+	in := func(left, right ast.IsNode, typed bool) {
+		path := operandPath(left)
+		if path == "" || operandPath(right) != "" {
+			return
+		}
+		switch right.(type) {
+		case ast.NodeValue, ast.NodeTypeSet:
+		default:
+			return
+		}
+		column := add(operandColumn(left, &opts))
+		add(path + "." + AncestorsAttribute)
+		if !typed && column.ElementKey != ElementUID {
+			add(path + "." + opts.typeField())
+		}
+	}
+	ast.Inspect(ast.NewNode(node), func(n ast.IsNode) bool {
+		if err != nil {
+			return false
+		}
+		switch n := n.(type) {
+		case ast.NodeTypeAccess:
+			add(variablePath(n.Arg, string(n.Value)))
+		case ast.NodeTypeHas:
+			add(variablePath(n.Arg, string(n.Value)))
+		case ast.NodeTypeIs:
+			if path := operandPath(n.Left); path != "" {
+				add(path + "." + opts.typeField())
+			}
+		case ast.NodeTypeIsIn:
+			if path := operandPath(n.Left); path != "" {
+				add(path + "." + opts.typeField())
+			}
+			in(n.Left, n.Entity, true)
+		case ast.NodeTypeGetTag:
+			if path := operandPath(n.Left); path != "" {
+				add(path + "." + TagsAttribute)
+			}
+		case ast.NodeTypeHasTag:
+			if path := operandPath(n.Left); path != "" {
+				add(path + "." + TagsAttribute)
+			}
+		case ast.NodeTypeEquals:
+			entities(n.Left, n.Right)
+			return true
+		case ast.NodeTypeNotEquals:
+			entities(n.Left, n.Right)
+			return true
+		case ast.NodeTypeIn:
+			entities(n.Left, n.Right)
+			in(n.Left, n.Right, false)
+			return true
+		case ast.NodeTypeContains:
+			entities(n.Left, n.Right)
+			return true
+		case ast.NodeTypeContainsAll:
+			entities(n.Left, n.Right)
+			return true
+		case ast.NodeTypeContainsAny:
+			entities(n.Left, n.Right)
+			return true
+		default:
+			return true
+		}
+		// the inner accesses are a prefix of this path, not columns of their own
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(columns)
+	return columns, nil
+}
+
+// variablePath returns "<variable>.<...>.<attr>" when arg is an attribute
+// chain rooted at a remaining variable, or "" otherwise.
+func variablePath(arg ast.IsNode, attr string) string {
+	switch n := arg.(type) {
+	case ast.NodeTypeVariable:
+		return string(n.Name) + "." + attr
+	case ast.NodeValue:
+		if variable, ok := eval.ToVariable(n.Value); ok {
+			return string(variable) + "." + attr
+		}
+	case ast.NodeTypeAccess:
+		if prefix := variablePath(n.Arg, string(n.Value)); prefix != "" {
+			return prefix + "." + attr
+		}
+	}
+	return ""
+}
+
+// Selectivity is a rough hint of how many rows a predicate on a column keeps;
+// higher selectivity keeps fewer rows.
+type Selectivity int
+
+const (
+	SelectivityUnknown Selectivity = iota
+	// SelectivityLow is for predicates such as LIKE, !=, has and isEmpty.
+	SelectivityLow
+	// SelectivityMedium is for ranges and set membership.
+	SelectivityMedium
+	// SelectivityHigh is for equality.
+	SelectivityHigh
+)
+
+func (s Selectivity) String() string {
+	switch s {
+	case SelectivityLow:
+		return "low"
+	case SelectivityMedium:
+		return "medium"
+	case SelectivityHigh:
+		return "high"
+	}
+	return "unknown"
+}
+
+// SelectivityHints returns an advisory selectivity per mapped column, derived
+// from the operators node applies to it. When a column is used by several
+// predicates the most selective one wins, since that is the one an index
+// would serve.
+func SelectivityHints(node ast.IsNode, mapper FieldMapper) (map[string]Selectivity, error) {
+	return SelectivityHintsWithOptions(node, mapper, Options{})
+}
+
+// SelectivityHintsWithOptions is SelectivityHints keying the hints by the
+// column names ToSqlWithOptions renders with opts, as ColumnsWithOptions does.
+func SelectivityHintsWithOptions(node ast.IsNode, mapper FieldMapper, opts Options) (map[string]Selectivity, error) {
+	hints := make(map[string]Selectivity)
+	var err error
+	hint := func(selectivity Selectivity, nodes ...ast.IsNode) {
+		for _, n := range nodes {
+			path := operandColumn(n, &opts)
+			if path == "" || err != nil {
+				continue
+			}
+			var column ColumnSpec
+			column, err = mapColumn(mapper, path, &opts)
+			if err == nil && selectivity > hints[column.Column] {
+				hints[column.Column] = selectivity
+			}
+		}
+	}
+	ast.Inspect(ast.NewNode(node), func(n ast.IsNode) bool {
+		switch n := n.(type) {
+		case ast.NodeTypeEquals:
+			hint(SelectivityHigh, n.Left, n.Right)
+		case ast.NodeTypeGreaterThan:
+			hint(SelectivityMedium, n.Left, n.Right)
+		case ast.NodeTypeGreaterThanOrEqual:
+			hint(SelectivityMedium, n.Left, n.Right)
+		case ast.NodeTypeLessThan:
+			hint(SelectivityMedium, n.Left, n.Right)
+		case ast.NodeTypeLessThanOrEqual:
+			hint(SelectivityMedium, n.Left, n.Right)
+		case ast.NodeTypeIn:
+			hint(SelectivityMedium, n.Left, n.Right)
+		case ast.NodeTypeContains:
+			hint(SelectivityMedium, n.Left, n.Right)
+		case ast.NodeTypeContainsAll:
+			hint(SelectivityMedium, n.Left, n.Right)
+		case ast.NodeTypeContainsAny:
+			hint(SelectivityMedium, n.Left, n.Right)
+		case ast.NodeTypeNotEquals:
+			hint(SelectivityLow, n.Left, n.Right)
+		case ast.NodeTypeLike:
+			hint(SelectivityLow, n.Arg)
+		case ast.NodeTypeIsEmpty:
+			hint(SelectivityLow, n.Arg)
+		case ast.NodeTypeHas:
+			hint(SelectivityLow, ast.NodeTypeAccess{StrOpNode: n.StrOpNode})
+		}
+		return err == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return hints, nil
+}
+
+// operandPath returns the attribute path of n when it is a remaining column:
+// an attribute chain rooted at a variable, or a bare variable.
+func operandPath(n ast.IsNode) string {
+	switch n := n.(type) {
+	case ast.NodeTypeAccess:
+		return variablePath(n.Arg, string(n.Value))
+	case ast.NodeTypeVariable:
+		return string(n.Name)
+	case ast.NodeValue:
+		if variable, ok := eval.ToVariable(n.Value); ok {
+			return string(variable)
+		}
+	}
+	return ""
+}
+
+// operandColumn is operandPath with a bare variable resolved to the column
+// ToSql compares it by, the one of opts.IDField when set.
+func operandColumn(n ast.IsNode, opts *Options) string {
+	path := operandPath(n)
+	if _, ok := n.(ast.NodeTypeAccess); ok || path == "" {
+		return path
+	}
+	return opts.entityColumn(path)
+}
@@ -0,0 +1,142 @@
+package sqlizer
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/cedar-policy/cedar-go/types"
+	"github.com/cedar-policy/cedar-go/x/exp/ast"
+)
+
+func TestColumns(t *testing.T) {
+	t.Parallel()
+	node := ast.Resource().Access("owner").Equal(ast.String("bob")).
+		Or(ast.Resource().Access("is_public").Equal(ast.Boolean(true))).
+		And(ast.Resource().Has("owner"))
+	got, err := Columns(node.AsIsNode(), fileMapper{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"files.is_public", "files.owner"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Columns(%v) = %v, want %v", node, got, want)
+	}
+}
+
+func TestColumnsTypeField(t *testing.T) {
+	t.Parallel()
+	node := ast.Resource().Is("Photo").And(ast.Resource().Access("owner").Equal(ast.String("bob")))
+	got, err := ColumnsWithOptions(node.AsIsNode(), typedMapper{
+		"resource.__entity_type__": {Column: "resources.resource_type"},
+		"resource.owner":           {Column: "resources.owner"},
+	}, Options{TypeField: "__entity_type__"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"resources.owner", "resources.resource_type"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("ColumnsWithOptions(%v) = %v, want %v", node, got, want)
+	}
+}
+
+func TestColumnsIdentifierCase(t *testing.T) {
+	t.Parallel()
+	node := ast.Resource().Access("owner").Equal(ast.String("bob")).And(ast.Resource().Access("kind").Equal(ast.String("pdf")))
+	got, err := ColumnsWithOptions(node.AsIsNode(), typedMapper{
+		"resource.owner": {Column: "Files.Owner"},
+		"resource.kind":  {Column: "Files.Kind", Raw: true},
+	}, Options{IdentifierCase: IdentifierLower})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"Files.Kind", "files.owner"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("ColumnsWithOptions(%v) = %v, want %v", node, got, want)
+	}
+}
+
+func TestColumnsGetTag(t *testing.T) {
+	t.Parallel()
+	node := ast.Principal().GetTag(ast.String("dept")).Equal(ast.Resource().Access("dept"))
+	got, err := Columns(node.AsIsNode(), typedMapper{
+		"principal.__tags__": {Column: "users.tags"},
+		"resource.dept":      {Column: "document.dept"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"document.dept", "users.tags"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Columns(%v) = %v, want %v", node, got, want)
+	}
+}
+
+func TestSelectivityHints(t *testing.T) {
+	t.Parallel()
+	node := ast.Resource().Access("owner").Equal(ast.String("bob")).
+		And(ast.Resource().Access("owner").NotEqual(ast.String("alice"))).
+		And(ast.Resource().Access("size").LessThan(ast.Long(10))).
+		And(ast.Resource().Access("path").Like(types.NewPattern("a/", types.Wildcard{}))).
+		And(ast.Resource().Has("deleted_at"))
+	got, err := SelectivityHints(node.AsIsNode(), fileMapper{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]Selectivity{
+		"files.owner":      SelectivityHigh,
+		"files.size":       SelectivityMedium,
+		"files.path":       SelectivityLow,
+		"files.deleted_at": SelectivityLow,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("SelectivityHints(%v) = %v, want %v", node, got, want)
+	}
+}
+
+func TestSelectivityHintsIdentifierCase(t *testing.T) {
+	t.Parallel()
+	node := ast.Resource().Access("owner").Equal(ast.String("bob")).And(ast.Resource().Access("kind").NotEqual(ast.String("pdf")))
+	got, err := SelectivityHintsWithOptions(node.AsIsNode(), typedMapper{
+		"resource.owner": {Column: "Files.Owner"},
+		"resource.kind":  {Column: "Files.Kind", Raw: true},
+	}, Options{IdentifierCase: IdentifierPreserve})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]Selectivity{
+		`"Files"."Owner"`: SelectivityHigh,
+		"Files.Kind":      SelectivityLow,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("SelectivityHintsWithOptions(%v) = %v, want %v", node, got, want)
+	}
+}
+
+func TestColumnsEntity(t *testing.T) {
+	t.Parallel()
+	node := ast.Resource().Equal(ast.EntityUID("Photo", "a")).
+		Or(ast.Resource().In(ast.Set(ast.EntityUID("Folder", "x")))).
+		Or(ast.Principal().Access("friends").Contains(ast.Resource()))
+	mapper := typedMapper{
+		"resource.id":            {Column: "photos.id"},
+		"resource.__ancestors__": {Column: "photos.ancestors", Type: TypeArray, ElementKey: ElementUID},
+		"resource.__type__":      {Column: "photos.resource_type"},
+		"principal.friends":      {Column: "users.friends"},
+	}
+	opts := Options{IDField: "id", TypeField: "__type__"}
+	got, err := ColumnsWithOptions(node.AsIsNode(), mapper, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"photos.ancestors", "photos.id", "photos.resource_type", "users.friends"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("ColumnsWithOptions(%v) = %v, want %v", node, got, want)
+	}
+	hints, err := SelectivityHintsWithOptions(node.AsIsNode(), mapper, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hints["photos.id"] != SelectivityHigh {
+		t.Fatalf("SelectivityHintsWithOptions(%v) = %v, want photos.id high", node, hints)
+	}
+}
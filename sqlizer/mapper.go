@@ -0,0 +1,365 @@
+package sqlizer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/cedar-policy/cedar-go"
+)
+
+// ColumnType describes how a mapped column stores its value, so the sqlizer
+// can pick an operator that fits the column instead of guessing.
+type ColumnType int
+
+const (
+	// TypeUnknown is used when the mapper does not describe the column.
+	TypeUnknown ColumnType = iota
+	// TypeEntity is a column holding a single entity id, e.g. a group foreign key.
+	TypeEntity
+	// TypeString is a text column.
+	TypeString
+	// TypeArray is a native SQL array column holding a cedar set.
+	TypeArray
+	// TypeJSONB is a jsonb column holding a cedar set as a json array.
+	TypeJSONB
+	// TypeTable is a cedar set stored in a child table, one element per row,
+	// described by ColumnSpec.Table. Membership is rendered as an EXISTS
+	// subquery, so the filter never multiplies parent rows the way a join
+	// would and callers need no DISTINCT.
+	TypeTable
+	// TypeDecimal is a numeric column holding a cedar decimal.
+	TypeDecimal
+	// TypeUUID is a uuid column, e.g. the key of a UUID-keyed table; entity
+	// ids compared with it must be uuids.
+	TypeUUID
+)
+
+// ColumnSpec is the typed result of mapping a cedar attribute path.
+type ColumnSpec struct {
+	Column string
+	Type   ColumnType
+	// Table describes the child table of a TypeTable set. Column is then the
+	// parent key the child table references, e.g. "document.id".
+	Table *TableSpec
+	// Collation is appended as `COLLATE "<Collation>"` to string comparisons
+	// against a TypeString column. Leave it empty for other columns and for
+	// databases without COLLATE.
+	Collation string
+	// ElementKey is how entities are stored in the column, e.g. the elements
+	// of an acl set that `principal in resource.acl` is matched against.
+	ElementKey ElementKey
+	// Nullable marks a column that may hold NULL, for the null-safe options.
+	Nullable bool
+	// NotNull marks a column that never holds NULL, so the attribute is
+	// always present and `has` on it is true.
+	NotNull bool
+	// Raw marks Column as written as is, e.g. an already quoted identifier or
+	// an expression, which Options.IdentifierCase leaves alone.
+	Raw bool
+}
+
+// ElementKey selects how an entity is stored in a column.
+type ElementKey int
+
+const (
+	// ElementID stores the bare entity id, e.g. `alice`.
+	ElementID ElementKey = iota
+	// ElementUID stores the fully qualified entity, e.g. `User::"alice"`.
+	ElementUID
+)
+
+// entityArg returns uid as the column stores it.
+func (c ColumnSpec) entityArg(uid cedar.EntityUID) interface{} {
+	if c.ElementKey == ElementUID {
+		return uid.String()
+	}
+	return string(uid.ID)
+}
+
+// collate appends the column's collation to a string comparison.
+func (c ColumnSpec) collate(comparison Sqlizer) (Sqlizer, error) {
+	if c.Type != TypeString || c.Collation == "" {
+		return comparison, nil
+	}
+	return ExprErr(`? COLLATE "`+strings.ReplaceAll(c.Collation, `"`, `""`)+`"`, comparison)
+}
+
+// TableSpec describes a set stored in a child table.
+type TableSpec struct {
+	// Name is the child table, e.g. "document_tags".
+	Name string
+	// ForeignKey is the child column referencing the parent key, e.g. "document_id".
+	ForeignKey string
+	// Element is the child column holding one set element, e.g. "tag".
+	Element string
+}
+
+// exists renders a membership test against the child table as
+// `EXISTS (SELECT 1 FROM t WHERE t.fk = parent AND cond)`, cond being a
+// predicate on the element column.
+func (t *TableSpec) exists(parent Sqlizer, cond func(element result) (Sqlizer, error)) (Sqlizer, error) {
+	element, err := ExprErr(t.Name + "." + t.Element)
+	if err != nil {
+		return nil, err
+	}
+	predicate, err := cond(valueToResult(false, nil, element))
+	if err != nil {
+		return nil, err
+	}
+	sql := fmt.Sprintf("EXISTS (SELECT 1 FROM %[1]s WHERE %[1]s.%[2]s = ? AND ?)", t.Name, t.ForeignKey)
+	return ExprErr(sql, parent, predicate)
+}
+
+// TypedFieldMapper is an optional interface for a FieldMapper that also knows
+// the type of the columns it maps to. When the mapper passed to ToSql
+// implements it, MapColumn is used instead of Map.
+type TypedFieldMapper interface {
+	FieldMapper
+	MapColumn(name string) (ColumnSpec, error)
+}
+
+// SqlFieldMapper is an optional interface for a FieldMapper that renders some
+// attribute paths as an expression with its own args instead of a column,
+// e.g. a scalar subquery on a joined table:
+//
+//	Expr("(SELECT o.user_id FROM ownership o WHERE o.doc_id = document.id AND o.kind = ?)", "primary")
+//
+// ok is false for the paths it leaves to Map or MapColumn. The expression is
+// spliced in where the column would be, so it must be parenthesized if it
+// is not a single term.
+type SqlFieldMapper interface {
+	FieldMapper
+	MapExpr(name string) (expr Sqlizer, ok bool, err error)
+}
+
+// mapExpr asks mapper for an expression for name when it is a SqlFieldMapper.
+func mapExpr(mapper FieldMapper, name string, opts *Options) (Sqlizer, bool, error) {
+	m, ok := mapper.(SqlFieldMapper)
+	if !ok {
+		return nil, false, nil
+	}
+	if opts != nil && opts.PartialContext == PartialContextReject && (name == "context" || strings.HasPrefix(name, "context.")) {
+		return nil, false, fmt.Errorf("%w: %s", ErrPartialContext, name)
+	}
+	return m.MapExpr(name)
+}
+
+// mapColumn resolves name through mapper, preferring the typed form, and
+// normalizes the resulting identifier as opts asks.
+func mapColumn(mapper FieldMapper, name string, opts *Options) (column ColumnSpec, err error) {
+	if opts != nil && opts.PartialContext == PartialContextReject && (name == "context" || strings.HasPrefix(name, "context.")) {
+		return ColumnSpec{}, fmt.Errorf("%w: %s", ErrPartialContext, name)
+	}
+	switch m := mapper.(type) {
+	case nil:
+		column = ColumnSpec{Column: name}
+	case TypedFieldMapper:
+		column, err = m.MapColumn(name)
+	default:
+		column.Column, err = m.Map(name)
+	}
+	if err != nil {
+		return ColumnSpec{}, err
+	}
+	if opts != nil && !column.Raw {
+		column.Column = opts.IdentifierCase.apply(column.Column, opts.Dialect)
+	}
+	return column, nil
+}
+
+// IdentifierCase controls how mapped column identifiers are emitted, for
+// databases that fold unquoted identifiers to lowercase.
+type IdentifierCase int
+
+const (
+	// IdentifierAsIs emits the mapper's output unchanged.
+	IdentifierAsIs IdentifierCase = iota
+	// IdentifierLower lowercases every unquoted identifier segment, matching
+	// how the database folds them.
+	IdentifierLower
+	// IdentifierPreserve quotes every identifier segment for the dialect with
+	// QuoteIdentifier, so its case is kept as the mapper returned it and
+	// reserved words such as `order` stay valid.
+	IdentifierPreserve
+)
+
+func (c IdentifierCase) apply(column string, d Dialect) string {
+	if c == IdentifierAsIs {
+		return column
+	}
+	segments := splitIdentifier(column)
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, `"`) || strings.HasPrefix(segment, "`") {
+			// already quoted, its case is deliberate
+			continue
+		}
+		switch c {
+		case IdentifierLower:
+			segments[i] = strings.ToLower(segment)
+		case IdentifierPreserve:
+			segments[i] = QuoteIdentifier(d, segment)
+		}
+	}
+	return strings.Join(segments, ".")
+}
+
+// QuoteIdentifier quotes a single identifier for the dialect: in double
+// quotes on Postgres and SQLite, in backticks on MySQL, doubling any quote
+// inside it. A qualified name is quoted one segment at a time, e.g.
+// `"document"."order"`.
+func QuoteIdentifier(d Dialect, name string) string {
+	q := `"`
+	if d == MySQL {
+		q = "`"
+	}
+	return q + strings.ReplaceAll(name, q, q+q) + q
+}
+
+// splitIdentifier splits a qualified name on the dots outside quotes.
+func splitIdentifier(column string) []string {
+	var segments []string
+	var quote rune
+	start := 0
+	for i, r := range column {
+		switch {
+		case quote != 0:
+			// a doubled quote reads as leaving and reentering the quotes
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '`':
+			quote = r
+		case r == '.':
+			segments = append(segments, column[start:i])
+			start = i + 1
+		}
+	}
+	return append(segments, column[start:])
+}
+
+// AliasMapper is a FieldMapper that rewrites the leading segment of a path,
+// e.g. `resource.owner` to `d.owner`. Paths whose leading segment has no
+// alias pass through unchanged.
+type AliasMapper struct {
+	aliases map[string]string
+}
+
+// WithTableAlias returns an AliasMapper rewriting variable to alias.
+func WithTableAlias(variable, alias string) AliasMapper {
+	return AliasMapper{}.WithTableAlias(variable, alias)
+}
+
+// WithTableAlias returns a copy of m that also rewrites variable to alias.
+func (m AliasMapper) WithTableAlias(variable, alias string) AliasMapper {
+	aliases := make(map[string]string, len(m.aliases)+1)
+	for k, v := range m.aliases {
+		aliases[k] = v
+	}
+	aliases[variable] = alias
+	return AliasMapper{aliases: aliases}
+}
+
+func (m AliasMapper) Map(name string) (string, error) {
+	variable, rest, _ := strings.Cut(name, ".")
+	alias, ok := m.aliases[variable]
+	if !ok {
+		return name, nil
+	}
+	if rest == "" {
+		return alias, nil
+	}
+	return alias + "." + rest, nil
+}
+
+// SafeMapper maps only the attribute paths it lists, e.g.
+// `SafeMapper{"resource.owner": "document.owner"}`, and rejects every other
+// path with ErrInvalidFieldName, so a policy can never reach a column nobody
+// meant to expose. It is the recommended mapper for production;
+// DefaultFieldMapper passes every path through as a column.
+type SafeMapper map[string]string
+
+func (m SafeMapper) Map(name string) (string, error) {
+	if column, ok := m[name]; ok {
+		return column, nil
+	}
+	return "", fmt.Errorf("%s: %w", name, ErrInvalidFieldName)
+}
+
+// SchemaMapper is a TypedFieldMapper over a declared schema, e.g.
+//
+//	SchemaMapper{Columns: map[string]ColumnSpec{
+//		"resource.owner": {Column: "document.owner", Type: TypeEntity},
+//		"resource.tags":  {Column: "document.tags", Type: TypeJSONB},
+//	}}
+//
+// so the column types drive the rendering without a hand-written mapper.
+// Like SafeMapper it rejects undeclared paths with ErrInvalidFieldName,
+// unless PassThrough is set.
+type SchemaMapper struct {
+	Columns map[string]ColumnSpec
+	// PassThrough maps undeclared paths to an untyped column of the same
+	// name instead of rejecting them.
+	PassThrough bool
+}
+
+func (m SchemaMapper) Map(name string) (string, error) {
+	column, err := m.MapColumn(name)
+	return column.Column, err
+}
+
+func (m SchemaMapper) MapColumn(name string) (ColumnSpec, error) {
+	if column, ok := m.Columns[name]; ok {
+		return column, nil
+	}
+	if m.PassThrough {
+		return ColumnSpec{Column: name}, nil
+	}
+	return ColumnSpec{}, fmt.Errorf("%s: %w", name, ErrInvalidFieldName)
+}
+
+// ChainMappers returns a mapper that tries mappers in order and uses the first
+// that maps a path, e.g. one mapper for resource paths and one for principal
+// paths. A mapper refusing a path with ErrInvalidFieldName passes it on; any
+// other error stops the chain. Typed and expression mappers in the chain keep
+// their column types and expressions.
+func ChainMappers(mappers ...FieldMapper) FieldMapper {
+	return chainMapper(mappers)
+}
+
+type chainMapper []FieldMapper
+
+func (c chainMapper) Map(name string) (string, error) {
+	column, err := c.MapColumn(name)
+	return column.Column, err
+}
+
+func (c chainMapper) MapColumn(name string) (ColumnSpec, error) {
+	err := fmt.Errorf("%s: %w", name, ErrInvalidFieldName)
+	for _, m := range c {
+		var column ColumnSpec
+		column, err = mapColumn(m, name, nil)
+		if err == nil || !errors.Is(err, ErrInvalidFieldName) {
+			return column, err
+		}
+	}
+	return ColumnSpec{}, err
+}
+
+// MapExpr uses the expression of the first mapper that handles name, unless
+// an earlier mapper maps it to a column.
+func (c chainMapper) MapExpr(name string) (Sqlizer, bool, error) {
+	for _, m := range c {
+		if sm, ok := m.(SqlFieldMapper); ok {
+			expr, ok, err := sm.MapExpr(name)
+			if ok || err != nil {
+				return expr, ok, err
+			}
+		}
+		_, err := mapColumn(m, name, nil)
+		if err == nil || !errors.Is(err, ErrInvalidFieldName) {
+			return nil, false, nil
+		}
+	}
+	return nil, false, nil
+}
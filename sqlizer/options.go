@@ -0,0 +1,387 @@
+package sqlizer
+
+import (
+	"errors"
+	"math/big"
+	"slices"
+	"time"
+
+	"github.com/cedar-policy/cedar-go"
+)
+
+// DefaultMaxExpansion is the expansion cap used when Options.MaxExpansion is zero.
+const DefaultMaxExpansion = 64
+
+// DefaultTypeField is the attribute mapped for the entity type column when
+// Options.TypeField is empty.
+const DefaultTypeField = "type"
+
+var ErrMaxExpansion = errors.New("max expansion exceeded")
+
+var ErrPartialContext = errors.New("partial context referenced")
+
+// PartialContextMode selects how attributes of a context left partial are
+// rendered. A partial context rarely has columns of its own, so such paths
+// are usually a mistake in the request rather than in the policy.
+type PartialContextMode int
+
+const (
+	// PartialContextColumns maps `context.*` paths through the mapper like any
+	// other attribute path. The mapper is then responsible for them.
+	PartialContextColumns PartialContextMode = iota
+	// PartialContextReject fails with ErrPartialContext on any reference to
+	// the partial context.
+	PartialContextReject
+)
+
+// Options tunes how a residual node is rendered to SQL.
+// The zero value gives the same output as ToSql.
+type Options struct {
+	// MaxExpansion caps how many bound values a single predicate may expand
+	// into, e.g. the principal and its ancestors in `col IN (?, ...)`.
+	// Zero means DefaultMaxExpansion.
+	MaxExpansion int
+
+	// Placeholder selects how bind parameters are written. Defaults to Question.
+	Placeholder PlaceholderFormat
+	// StartIndex is the number of args already bound ahead of this clause
+	// when several filters share one statement. Numbered placeholders start
+	// at $StartIndex+1.
+	StartIndex int
+
+	// IdentifierCase normalizes mapped column identifiers. Defaults to
+	// IdentifierAsIs.
+	IdentifierCase IdentifierCase
+
+	// PartialContext selects how references to a partial context are
+	// handled. Defaults to PartialContextColumns.
+	PartialContext PartialContextMode
+
+	// Datetime selects how cedar datetimes are bound. Defaults to DatetimeUTC.
+	Datetime DatetimeMode
+	// Location is the zone used by DatetimeInLocation and DatetimeDate.
+	// Nil means UTC.
+	Location *time.Location
+	// Decimal selects how cedar decimals compared with a column are bound.
+	// Defaults to DecimalString.
+	Decimal DecimalMode
+
+	// TypeField is the attribute asked of the mapper for the entity type
+	// column of `variable is T`, e.g. "__entity_type__" to keep it apart from
+	// a real `type` attribute. Defaults to DefaultTypeField.
+	TypeField string
+	// IDField is the attribute asked of the mapper for the id column of a
+	// remaining entity compared as a whole, as in `resource == Doc::"1"` and
+	// `resource in [Doc::"1", Doc::"2"]`: "id" asks for `resource.id`. Empty
+	// asks for the bare variable, e.g. `resource`.
+	IDField string
+
+	// CaseInsensitive lists the mapped columns whose string comparisons ignore
+	// case: `==` and `!=` against them compare lower() of both sides, and
+	// `like` becomes ILIKE. Comparisons with non-string values are unchanged.
+	CaseInsensitive []string
+
+	// ArrayIn binds the list of an `IN` on Postgres as a single array,
+	// `col = ANY(?)`, so the statement has one placeholder whatever the size
+	// of the set. Other dialects keep `col IN (?, ...)`.
+	ArrayIn bool
+
+	// CollapseBetween renders an inclusive range on one column with concrete
+	// bounds, `col >= ? AND col <= ?`, as `col BETWEEN ? AND ?`.
+	CollapseBetween bool
+
+	// PrefixRange adds a range to a `like` whose pattern is a literal prefix
+	// followed by a single `*`, e.g. `path >= 'acme/' AND path < 'acme0'`
+	// next to `path LIKE 'acme/%'`, so a B-tree index can be used. The range
+	// follows code point order, so the column should use a binary collation,
+	// such as "C" on Postgres.
+	PrefixRange bool
+
+	// Tracer, when set, receives every node rendered, for debugging.
+	Tracer Tracer
+
+	// Dialect is the database the SQL is generated for. Defaults to Postgres.
+	Dialect Dialect
+	// NullSafe renders predicates on columns marked Nullable by a
+	// TypedFieldMapper so NULL behaves like an absent value: `==` becomes
+	// `IS NOT DISTINCT FROM` on Postgres and `<=>` on MySQL, `!=` becomes
+	// `IS DISTINCT FROM` and `NOT (<=>)`, so "not owned by me" keeps the
+	// unowned rows, and a negated membership, e.g. `NOT IN`, holds for a NULL
+	// column instead of making the predicate NULL and dropping the row.
+	NullSafe bool
+}
+
+// Dialect is the database the SQL is generated for, for the few constructs
+// that differ between databases. MySQL and SQLite take "?" placeholders, the
+// default Placeholder; Postgres drivers that need "$1" set it to Dollar.
+type Dialect int
+
+const (
+	Postgres Dialect = iota
+	MySQL
+	// SQLite stores sets as json arrays searched with json_each, and binds
+	// booleans as 1 and 0. Its LIKE ignores ASCII case unless
+	// `PRAGMA case_sensitive_like` is on.
+	SQLite
+)
+
+func (d Dialect) notDistinct() string {
+	switch d {
+	case MySQL:
+		return "? <=> ?"
+	case SQLite:
+		return "? IS ?"
+	}
+	return "? IS NOT DISTINCT FROM ?"
+}
+
+func (d Dialect) distinct() string {
+	switch d {
+	case MySQL:
+		return "NOT (? <=> ?)"
+	case SQLite:
+		return "? IS NOT ?"
+	}
+	return "? IS DISTINCT FROM ?"
+}
+
+// like returns the LIKE expression escaping wildcards with a backslash,
+// which is already the escape character of MySQL string literals and LIKE.
+func (d Dialect) like() string {
+	if d == MySQL {
+		return "? LIKE ?"
+	}
+	return `? LIKE ? ESCAPE '\'`
+}
+
+// offset returns the expression adding a number of milliseconds to a
+// timestamp.
+func (d Dialect) offset() string {
+	switch d {
+	case MySQL:
+		return "? + INTERVAL (? * 1000) MICROSECOND"
+	case SQLite:
+		return "strftime('%Y-%m-%d %H:%M:%f', ?, (? / 1000.0) || ' seconds')"
+	}
+	return "? + ? * INTERVAL '1 millisecond'"
+}
+
+// tag returns the expression looking up a key of a json tags column as text.
+func (d Dialect) tag() string {
+	switch d {
+	case MySQL:
+		return `JSON_UNQUOTE(JSON_EXTRACT(?, CONCAT('$."', ?, '"')))`
+	case SQLite:
+		return `json_extract(?, '$."' || ? || '"')`
+	}
+	return "? ->> ?"
+}
+
+// hasTag returns the expression testing whether a json tags column has a key.
+func (d Dialect) hasTag() string {
+	switch d {
+	case MySQL:
+		return `JSON_CONTAINS_PATH(?, 'one', CONCAT('$."', ?, '"'))`
+	case SQLite:
+		return `json_type(?, '$."' || ? || '"') IS NOT NULL`
+	}
+	return "? ?? ?"
+}
+
+// json returns the expression reading a bound json text as the dialect's
+// json type, so it compares with json columns as json.
+func (d Dialect) json() string {
+	switch d {
+	case MySQL:
+		return "CAST(? AS JSON)"
+	case SQLite:
+		return "json(?)"
+	}
+	return "?::jsonb"
+}
+
+// ilike returns the case-insensitive form of like.
+func (d Dialect) ilike() string {
+	switch d {
+	case MySQL:
+		return "lower(?) LIKE lower(?)"
+	case SQLite:
+		return `lower(?) LIKE lower(?) ESCAPE '\'`
+	}
+	return `? ILIKE ? ESCAPE '\'`
+}
+
+// setOp is a cedar set operator rendered against a json set column.
+type setOp int
+
+const (
+	setContains setOp = iota
+	setContainsAll
+	setContainsAny
+)
+
+// jsonSet returns the expression for op between the json set column and arg.
+// On MySQL arg is a json document: a single element for contains, an array
+// for containsAll and containsAny. On SQLite it is an element for contains
+// and a json array otherwise. On Postgres it is text for contains and a
+// text[] otherwise.
+func (d Dialect) jsonSet(op setOp, column, arg interface{}) Sqlizer {
+	switch d {
+	case MySQL:
+		if op == setContainsAny {
+			return Expr("JSON_OVERLAPS(?, ?)", column, arg)
+		}
+		// a json array candidate is contained when all its elements are
+		return Expr("JSON_CONTAINS(?, ?)", column, arg)
+	case SQLite:
+		switch op {
+		case setContainsAll:
+			return Expr("NOT EXISTS (SELECT 1 FROM json_each(?) AS r WHERE r.value NOT IN (SELECT value FROM json_each(?)))", arg, column)
+		case setContainsAny:
+			return Expr("EXISTS (SELECT 1 FROM json_each(?) AS l JOIN json_each(?) AS r ON l.value = r.value)", column, arg)
+		}
+		return Expr("EXISTS (SELECT 1 FROM json_each(?) WHERE value = ?)", column, arg)
+	}
+	switch op {
+	case setContainsAll:
+		return Expr("? ??& ?", column, arg)
+	case setContainsAny:
+		return Expr("? ??| ?", column, arg)
+	}
+	return Expr("? ?? ?", column, arg)
+}
+
+func (o *Options) dialect() Dialect {
+	if o == nil {
+		return Postgres
+	}
+	return o.Dialect
+}
+
+// DatetimeMode selects how a cedar datetime is bound as a time.Time. A cedar
+// datetime is an instant: the offset written in its literal is not kept, so
+// the offset of the bound value comes from Options.Location.
+type DatetimeMode int
+
+const (
+	// DatetimeUTC binds the instant in UTC.
+	DatetimeUTC DatetimeMode = iota
+	// DatetimeInLocation binds the instant in Options.Location, keeping that
+	// zone's offset for timestamptz columns.
+	DatetimeInLocation
+	// DatetimeDate binds midnight UTC of the instant's date in
+	// Options.Location, for date columns.
+	DatetimeDate
+)
+
+// DecimalMode selects how a cedar decimal compared with a column is bound.
+// The SQL casts it to numeric in every mode.
+type DecimalMode int
+
+const (
+	// DecimalString binds the decimal's text, e.g. "12.5".
+	DecimalString DecimalMode = iota
+	// DecimalFloat64 binds a float64. Decimals with more than 15 significant
+	// digits lose precision.
+	DecimalFloat64
+	// DecimalRat binds an exact *big.Rat, for drivers that accept one.
+	DecimalRat
+)
+
+// decimalArg is a decimal bound against a column, converted by bindArgs
+// according to Options.Decimal.
+type decimalArg cedar.Decimal
+
+func (o *Options) decimal(d cedar.Decimal) interface{} {
+	if o == nil {
+		return d.String()
+	}
+	switch o.Decimal {
+	case DecimalFloat64:
+		return d.Float()
+	case DecimalRat:
+		r, _ := new(big.Rat).SetString(d.String())
+		return r
+	}
+	return d.String()
+}
+
+// bindArgs applies the decimal and datetime modes and the dialect's boolean
+// style to a copy of the rendered args, which may be shared with the Sqlizer
+// they were rendered from.
+func (o *Options) bindArgs(args []interface{}) []interface{} {
+	args = slices.Clone(args)
+	for i, arg := range args {
+		if d, ok := arg.(decimalArg); ok {
+			args[i] = o.decimal(cedar.Decimal(d))
+		}
+	}
+	if o.dialect() == SQLite {
+		for i, arg := range args {
+			if b, ok := arg.(bool); ok {
+				args[i] = 0
+				if b {
+					args[i] = 1
+				}
+			}
+		}
+	}
+	if o == nil || o.Datetime == DatetimeUTC {
+		return args
+	}
+	location := o.Location
+	if location == nil {
+		location = time.UTC
+	}
+	for i, arg := range args {
+		t, ok := arg.(time.Time)
+		if !ok {
+			continue
+		}
+		t = t.In(location)
+		if o.Datetime == DatetimeDate {
+			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		}
+		args[i] = t
+	}
+	return args
+}
+
+// entityColumn returns the path mapped for the id column of variable.
+func (o *Options) entityColumn(variable string) string {
+	if o == nil || o.IDField == "" {
+		return variable
+	}
+	return variable + "." + o.IDField
+}
+
+func (o *Options) typeField() string {
+	if o == nil || o.TypeField == "" {
+		return DefaultTypeField
+	}
+	return o.TypeField
+}
+
+// caseInsensitive reports whether r is a column listed in CaseInsensitive.
+func (o *Options) caseInsensitive(r result) bool {
+	return o != nil && !r.isValue && r.column.Column != "" && slices.Contains(o.CaseInsensitive, r.column.Column)
+}
+
+// tracer returns the tracer for a call, falling back to printing to stdout
+// while the deprecated Debug is on.
+func (o *Options) tracer() Tracer {
+	if o != nil && o.Tracer != nil {
+		return o.Tracer
+	}
+	if Debug {
+		return stdoutTracer
+	}
+	return nil
+}
+
+func (o *Options) maxExpansion() int {
+	if o == nil || o.MaxExpansion <= 0 {
+		return DefaultMaxExpansion
+	}
+	return o.MaxExpansion
+}
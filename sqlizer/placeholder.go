@@ -0,0 +1,83 @@
+package sqlizer
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// PlaceholderFormat selects how bind parameters are written in generated SQL.
+type PlaceholderFormat int
+
+const (
+	// Question writes "?" placeholders, as used by lib/pq's text protocol and MySQL.
+	Question PlaceholderFormat = iota
+	// Dollar writes numbered "$1".."$N" placeholders, as expected by pgx.
+	Dollar
+	// Named writes numbered "@p1".."@pN" named placeholders, as expected by
+	// pgx.NamedArgs. The names only depend on position, so the same filter
+	// always renders to the same statement.
+	Named
+	// Escaped writes "?" placeholders and keeps literal question marks, e.g.
+	// of the jsonb `?|` operator, doubled as "??", for query builders such as
+	// squirrel that rewrite the placeholders themselves.
+	Escaped
+	// Colon writes numbered ":p1"..":pN" named placeholders, as expected by
+	// sqlx named queries.
+	Colon
+)
+
+// NamedPrefix is the prefix of the placeholders written by Named; the arg at
+// position i (from 1) is named NamedPrefix[1:] followed by i, e.g. "p1".
+const NamedPrefix = "@p"
+
+// ColonPrefix is the prefix of the placeholders written by Colon.
+const ColonPrefix = ":p"
+
+// Render renders s with the given placeholder format. For numbered formats
+// startIndex is the number of args already bound ahead of this SQL, so the
+// first placeholder becomes $startIndex+1; this lets several filters be
+// stitched into one statement sharing a single arg slice.
+func Render(s Sqlizer, format PlaceholderFormat, startIndex int) (string, []interface{}, error) {
+	sql, args, err := toEscapedSql(s)
+	if err != nil {
+		return "", nil, err
+	}
+	switch format {
+	case Dollar:
+		return numbered(sql, "$", startIndex), args, nil
+	case Named:
+		return numbered(sql, NamedPrefix, startIndex), args, nil
+	case Colon:
+		return numbered(sql, ColonPrefix, startIndex), args, nil
+	case Escaped:
+		return sql, args, nil
+	}
+	return strings.ReplaceAll(sql, "??", "?"), args, nil
+}
+
+// numbered replaces every "?" placeholder in an escaped sql with prefix
+// followed by its position, counting from startIndex+1, and turns every
+// escaped "??" into a literal "?".
+func numbered(sql string, prefix string, startIndex int) string {
+	buf := &bytes.Buffer{}
+	n := startIndex
+	for {
+		i := strings.Index(sql, "?")
+		if i < 0 {
+			break
+		}
+		buf.WriteString(sql[:i])
+		if len(sql) > i+1 && sql[i+1] == '?' {
+			buf.WriteString("?")
+			sql = sql[i+2:]
+			continue
+		}
+		n++
+		buf.WriteString(prefix)
+		buf.WriteString(strconv.Itoa(n))
+		sql = sql[i+1:]
+	}
+	buf.WriteString(sql)
+	return buf.String()
+}
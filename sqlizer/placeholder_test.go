@@ -0,0 +1,115 @@
+package sqlizer
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/cedar-policy/cedar-go/types"
+	"github.com/cedar-policy/cedar-go/x/exp/ast"
+	"github.com/cedar-policy/cedar-go/x/exp/eval"
+)
+
+func TestRender(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name       string
+		expr       Sqlizer
+		format     PlaceholderFormat
+		startIndex int
+		want       string
+	}{
+		{
+			name:   "question",
+			expr:   AndExpr(Expr("a = ?", 1), Expr("b ?? ?", "x")),
+			format: Question,
+			want:   "a = ? AND b ? ?",
+		},
+		{
+			name:   "dollar",
+			expr:   AndExpr(Expr("a = ?", 1), Expr("b ?? ?", "x")),
+			format: Dollar,
+			want:   "a = $1 AND b ? $2",
+		},
+		{
+			name:       "dollar from start index",
+			expr:       OrExpr(Expr("a = ?", 1), Expr("? ??| ?", Expr("b"), "x")),
+			format:     Dollar,
+			startIndex: 2,
+			want:       "(a = $3 OR b ?| $4)",
+		},
+		{
+			name:   "named",
+			expr:   AndExpr(Expr("a = ?", 1), Expr("b ?? ?", "x")),
+			format: Named,
+			want:   "a = @p1 AND b ? @p2",
+		},
+		{
+			name:   "colon",
+			expr:   AndExpr(Expr("a = ?", 1), Expr("b ?? ?", "x")),
+			format: Colon,
+			want:   "a = :p1 AND b ? :p2",
+		},
+		{
+			name:   "escaped",
+			expr:   AndExpr(Expr("a = ?", 1), Expr("b ?? ?", "x")),
+			format: Escaped,
+			want:   "a = ? AND b ?? ?",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, _, err := Render(test.expr, test.format, test.startIndex)
+			if err != nil {
+				t.Fatalf("Render(%v) err: %v", test.expr, err)
+			}
+			if got != test.want {
+				t.Fatalf("Render(%v) = %v, want %v", test.expr, got, test.want)
+			}
+		})
+	}
+}
+
+func TestToSqlStartIndex(t *testing.T) {
+	t.Parallel()
+	env := eval.Env{
+		Resource:  eval.Variable("resource"),
+		Principal: types.NewEntityUID("User", "bob"),
+	}
+	node := ast.Resource().Access("owner").Equal(ast.Principal()).Or(ast.Resource().Access("is_public").Equal(ast.Boolean(true)))
+
+	first, firstArgs, err := ToSqlWithOptions(node.AsIsNode(), env, fileMapper{}, Options{Placeholder: Dollar})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _, err := ToSqlWithOptions(node.AsIsNode(), env, fileMapper{}, Options{Placeholder: Dollar, StartIndex: len(firstArgs)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "(files.owner = $1 OR files.is_public = $2)"; first != want {
+		t.Fatalf("first = %v, want %v", first, want)
+	}
+	if want := "(files.owner = $3 OR files.is_public = $4)"; second != want {
+		t.Fatalf("second = %v, want %v", second, want)
+	}
+}
+
+func TestToNamedSql(t *testing.T) {
+	t.Parallel()
+	env := eval.Env{
+		Resource:  eval.Variable("resource"),
+		Principal: types.NewEntityUID("User", "bob"),
+	}
+	node := ast.Resource().Access("owner").Equal(ast.Principal()).
+		Or(ast.Resource().Access("is_public").Equal(ast.Boolean(true)).And(ast.Resource().Access("name").Like(types.NewPattern(types.Wildcard{}, "x"))))
+
+	sql, args, err := ToNamedSql(node.AsIsNode(), env, fileMapper{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `(files.owner = :p1 OR files.is_public = :p2 AND files.name LIKE :p3 ESCAPE '\')`; sql != want {
+		t.Fatalf("sql = %v, want %v", sql, want)
+	}
+	if want := map[string]interface{}{"p1": "bob", "p2": true, "p3": "%x"}; !reflect.DeepEqual(args, want) {
+		t.Fatalf("args = %v, want %v", args, want)
+	}
+}
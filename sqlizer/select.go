@@ -0,0 +1,108 @@
+package sqlizer
+
+import (
+	"strings"
+
+	"github.com/cedar-policy/cedar-go"
+	"github.com/cedar-policy/cedar-go/x/exp/ast"
+	"github.com/cedar-policy/cedar-go/x/exp/eval"
+	"github.com/jaredzhou/cedar-sqlizer/utils"
+)
+
+// SelectBuilder builds a SELECT statement filtered by a residual node, so the
+// filter, limit and offset share one placeholder sequence.
+//
+//	sql, args, err := Select("files").Columns("id", "name").
+//		Where(node, env, mapper).Limit(10).Offset(20).ToSql()
+type SelectBuilder struct {
+	table   string
+	columns []string
+	node    ast.IsNode
+	env     eval.Env
+	mapper  FieldMapper
+	limit   *uint64
+	offset  *uint64
+	opts    Options
+}
+
+// Select starts a SELECT from table, of all columns unless Columns is called.
+func Select(table string) SelectBuilder {
+	return SelectBuilder{table: table}
+}
+
+// Columns sets the selected columns.
+func (b SelectBuilder) Columns(columns ...string) SelectBuilder {
+	b.columns = columns
+	return b
+}
+
+// Where filters the rows by node, rendered as ToSql would.
+func (b SelectBuilder) Where(node ast.IsNode, env eval.Env, mapper FieldMapper) SelectBuilder {
+	b.node, b.env, b.mapper = node, env, mapper
+	return b
+}
+
+// Options sets the rendering options, including the placeholder format.
+func (b SelectBuilder) Options(opts Options) SelectBuilder {
+	b.opts = opts
+	return b
+}
+
+// Limit binds a LIMIT.
+func (b SelectBuilder) Limit(n uint64) SelectBuilder {
+	b.limit = &n
+	return b
+}
+
+// Offset binds an OFFSET.
+func (b SelectBuilder) Offset(n uint64) SelectBuilder {
+	b.offset = &n
+	return b
+}
+
+// ToSql renders the statement. A filter that always holds leaves out the
+// WHERE clause; one that never holds is written as `WHERE 1 = 0`.
+func (b SelectBuilder) ToSql() (string, []interface{}, error) {
+	columns := "*"
+	if len(b.columns) > 0 {
+		columns = strings.Join(b.columns, ", ")
+	}
+	var args []interface{}
+	sql := "SELECT " + columns + " FROM " + b.table
+	if b.node != nil {
+		where, err := toSqlOrValue(b.node, b.env, b.mapper, &b.opts)
+		if err != nil {
+			return "", nil, err
+		}
+		if where.isValue {
+			val, err := utils.ValueToType[cedar.Boolean](where.value)
+			if err != nil {
+				return "", nil, err
+			}
+			if !val {
+				sql += " WHERE " + sqlFalse
+			}
+		} else {
+			sql += " WHERE ?"
+			args = append(args, where.sqlizer)
+		}
+	}
+	if b.limit != nil {
+		sql += " LIMIT ?"
+		args = append(args, *b.limit)
+	}
+	if b.offset != nil {
+		sql += " OFFSET ?"
+		args = append(args, *b.offset)
+	}
+	// table and column names are not escaped, a "?" in them is a mismatch
+	stmt, err := ExprErr(sql, args...)
+	if err != nil {
+		return "", nil, err
+	}
+	sql, args, err = Render(stmt, b.opts.Placeholder, b.opts.StartIndex)
+	if err != nil {
+		return "", nil, err
+	}
+	return sql, b.opts.bindArgs(args), nil
+}
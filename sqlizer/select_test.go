@@ -0,0 +1,65 @@
+package sqlizer
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/cedar-policy/cedar-go/types"
+	"github.com/cedar-policy/cedar-go/x/exp/ast"
+	"github.com/cedar-policy/cedar-go/x/exp/eval"
+)
+
+func TestSelect(t *testing.T) {
+	t.Parallel()
+	env := eval.Env{
+		Resource:  eval.Variable("resource"),
+		Principal: types.NewEntityUID("User", "bob"),
+	}
+	owned := ast.Resource().Access("owner").Equal(ast.Principal()).Or(ast.Resource().Access("is_public").Equal(ast.Boolean(true)))
+	tests := []struct {
+		name    string
+		builder SelectBuilder
+		want    string
+		args    []interface{}
+	}{
+		{
+			name:    "filter with limit and offset",
+			builder: Select("files").Columns("id", "name").Where(owned.AsIsNode(), env, fileMapper{}).Limit(10).Offset(20),
+			want:    "SELECT id, name FROM files WHERE (files.owner = ? OR files.is_public = ?) LIMIT ? OFFSET ?",
+			args:    []interface{}{"bob", true, uint64(10), uint64(20)},
+		},
+		{
+			name: "numbered placeholders",
+			builder: Select("files").Where(owned.AsIsNode(), env, fileMapper{}).Limit(10).Offset(20).
+				Options(Options{Placeholder: Dollar}),
+			want: "SELECT * FROM files WHERE (files.owner = $1 OR files.is_public = $2) LIMIT $3 OFFSET $4",
+			args: []interface{}{"bob", true, uint64(10), uint64(20)},
+		},
+		{
+			name:    "always true leaves out where",
+			builder: Select("files").Where(ast.True().AsIsNode(), env, fileMapper{}).Limit(10),
+			want:    "SELECT * FROM files LIMIT ?",
+			args:    []interface{}{uint64(10)},
+		},
+		{
+			name:    "never true",
+			builder: Select("files").Where(ast.False().AsIsNode(), env, fileMapper{}),
+			want:    "SELECT * FROM files WHERE 1 = 0",
+			args:    nil,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, args, err := test.builder.ToSql()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Fatalf("ToSql() = %v, want %v", got, test.want)
+			}
+			if !reflect.DeepEqual(args, test.args) {
+				t.Fatalf("ToSql() args = %v, want %v", args, test.args)
+			}
+		})
+	}
+}
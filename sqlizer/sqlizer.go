@@ -2,11 +2,16 @@ package sqlizer
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"slices"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/cedar-policy/cedar-go"
+	"github.com/cedar-policy/cedar-go/types"
 	"github.com/cedar-policy/cedar-go/x/exp/ast"
 	"github.com/cedar-policy/cedar-go/x/exp/eval"
 	"github.com/jaredzhou/cedar-sqlizer/utils"
@@ -19,11 +24,25 @@ var (
 )
 
 var (
+	// Debug prints the trace of every ToSql call to stdout when no
+	// Options.Tracer is set.
+	//
+	// Deprecated: set Options.Tracer instead.
 	Debug = false
 )
 
 var ErrInvalidFieldName = errors.New("invalid field name")
 
+// RegisterValueConverter adds a converter consulted before the built-in
+// conversion of cedar values to bind args, e.g. for extension types.
+func RegisterValueConverter(c utils.ValueConverter) {
+	utils.RegisterValueConverter(c)
+}
+
+// FieldMapper maps an attribute path, e.g. "resource.owner", to the column it
+// is stored in. A mapper refuses a path by returning an error, conventionally
+// wrapping ErrInvalidFieldName; the error is returned by ToSql for every use
+// of the path, `has` included, and a refused path is never written as is.
 type FieldMapper interface {
 	Map(name string) (string, error)
 }
@@ -94,14 +113,31 @@ type expr struct {
 //   - If the number of arguments doesn't match the number of placeholders in the SQL template
 //   - This validation helps catch common errors early and provides clear error messages
 func Expr(sql string, args ...interface{}) Sqlizer {
+	e, err := ExprErr(sql, args...)
+	if err != nil {
+		panic(fmt.Sprintf("Expr: %v", err))
+	}
+	return e
+}
+
+var ErrPlaceholderMismatch = errors.New("placeholder count mismatch")
+
+// ExprErr is Expr returning ErrPlaceholderMismatch instead of panicking when
+// the number of args does not match the placeholders in sql. Use it for
+// templates built from names that are not constants, such as mapped columns.
+func ExprErr(sql string, args ...interface{}) (Sqlizer, error) {
 	expectedCount := countPlaceholders(sql)
 	if len(args) != expectedCount {
-		panic(fmt.Sprintf("Expr: expected %d arguments, got %d for SQL template: %s", expectedCount, len(args), sql))
+		return nil, fmt.Errorf("%w: expected %d arguments, got %d for SQL template: %s", ErrPlaceholderMismatch, expectedCount, len(args), sql)
 	}
-	return expr{sql: sql, args: args}
+	return expr{sql: sql, args: args}, nil
+}
+
+func (e expr) ToSql() (string, []interface{}, error) {
+	return unescaped(e)
 }
 
-func (e expr) ToSql() (sql string, args []interface{}, err error) {
+func (e expr) toEscapedSql() (sql string, args []interface{}, err error) {
 	simple := true
 	for _, arg := range e.args {
 		if _, ok := arg.(Sqlizer); ok {
@@ -109,8 +145,7 @@ func (e expr) ToSql() (sql string, args []interface{}, err error) {
 		}
 	}
 	if simple {
-		// Even for simple cases, we need to handle escaped question marks
-		return e.processEscapedSQL(e.sql), e.args, nil
+		return e.sql, e.args, nil
 	}
 
 	buf := &bytes.Buffer{}
@@ -127,16 +162,23 @@ func (e expr) ToSql() (sql string, args []interface{}, err error) {
 			break
 		}
 		if len(sp) > i+1 && sp[i+1:i+2] == "?" {
-			// escaped "??"; append single "?" and step past both
-			buf.WriteString(sp[:i])
-			buf.WriteString("?")
+			// escaped "??"; keep it escaped and step past both
+			buf.WriteString(sp[:i+2])
 			sp = sp[i+2:]
 			continue
 		}
 
 		if as, ok := ap[0].(Sqlizer); ok {
 			// sqlizer argument; expand it and append the result
-			isql, iargs, err = as.ToSql()
+			isql, iargs, err = toEscapedSql(as)
+			if c, ok := as.(conj); ok && c.sep == AndSep && !grouped(sp, i) {
+				// OR conjunctions group themselves, AND ones only need it
+				// once they are an operand of something else
+				isql = "(" + isql + ")"
+			} else if ok && c.sep == OrSep && len(c.parts) > 0 && isql != "" && grouped(sp, i) {
+				// already grouped, as in "NOT (?)", drop the OR's own group
+				isql = isql[1 : len(isql)-1]
+			}
 			buf.WriteString(sp[:i])
 			buf.WriteString(isql)
 			args = append(args, iargs...)
@@ -156,9 +198,37 @@ func (e expr) ToSql() (sql string, args []interface{}, err error) {
 	return buf.String(), append(args, ap...), err
 }
 
-// processEscapedSQL handles escaped question marks in simple cases
-func (e expr) processEscapedSQL(sql string) string {
-	return strings.ReplaceAll(sql, "??", "?")
+// grouped reports whether the placeholder at i in sql is already
+// parenthesized on its own, as in "NOT (?)".
+func grouped(sql string, i int) bool {
+	return strings.HasSuffix(sql[:i], "(") && strings.HasPrefix(sql[i+1:], ")")
+}
+
+// escapedSqlizer is implemented by the builders in this package. It renders
+// like ToSql but keeps literal question marks escaped as "??", so placeholders
+// can still be told apart from operators such as jsonb `?|` once expressions
+// are nested. The escapes are resolved once, when the outermost expression is
+// rendered.
+type escapedSqlizer interface {
+	toEscapedSql() (string, []interface{}, error)
+}
+
+// toEscapedSql renders s keeping literal question marks escaped. Sqlizers
+// from outside this package are assumed to only use "?" for placeholders.
+func toEscapedSql(s Sqlizer) (string, []interface{}, error) {
+	if e, ok := s.(escapedSqlizer); ok {
+		return e.toEscapedSql()
+	}
+	return s.ToSql()
+}
+
+// unescaped renders s and turns every escaped "??" into a literal "?".
+func unescaped(s Sqlizer) (string, []interface{}, error) {
+	sql, args, err := toEscapedSql(s)
+	if err != nil {
+		return "", nil, err
+	}
+	return strings.ReplaceAll(sql, "??", "?"), args, nil
 }
 
 // countPlaceholders counts the number of parameter placeholders in a SQL template
@@ -187,12 +257,16 @@ func newPart(pred interface{}, args ...interface{}) part {
 	return part{pred: pred, args: args}
 }
 
-func (p part) ToSql() (sql string, args []interface{}, err error) {
+func (p part) ToSql() (string, []interface{}, error) {
+	return unescaped(p)
+}
+
+func (p part) toEscapedSql() (sql string, args []interface{}, err error) {
 	switch pred := p.pred.(type) {
 	case nil:
 		// no-op
 	case Sqlizer:
-		sql, args, err = Sqlizer(pred).ToSql()
+		sql, args, err = toEscapedSql(pred)
 	case string:
 		sql = pred
 		args = p.args
@@ -204,7 +278,11 @@ func (p part) ToSql() (sql string, args []interface{}, err error) {
 
 type concatExpr []interface{}
 
-func (ce concatExpr) ToSql() (sql string, args []interface{}, err error) {
+func (ce concatExpr) ToSql() (string, []interface{}, error) {
+	return unescaped(ce)
+}
+
+func (ce concatExpr) toEscapedSql() (sql string, args []interface{}, err error) {
 	for _, part := range ce {
 		switch p := part.(type) {
 		case string:
@@ -212,7 +290,7 @@ func (ce concatExpr) ToSql() (sql string, args []interface{}, err error) {
 		case cedar.String:
 			sql += string(p)
 		case Sqlizer:
-			pSql, pArgs, err := p.ToSql()
+			pSql, pArgs, err := toEscapedSql(p)
 			if err != nil {
 				return "", nil, err
 			}
@@ -234,13 +312,17 @@ type conj struct {
 	defaultExpr string
 }
 
-func (c conj) ToSql() (sql string, args []interface{}, err error) {
+func (c conj) ToSql() (string, []interface{}, error) {
+	return unescaped(c)
+}
+
+func (c conj) toEscapedSql() (sql string, args []interface{}, err error) {
 	if len(c.parts) == 0 {
 		return c.defaultExpr, []interface{}{}, nil
 	}
 	var sqlParts []string
 	for _, sqlizer := range c.parts {
-		partSQL, partArgs, err := sqlizer.ToSql()
+		partSQL, partArgs, err := toEscapedSql(sqlizer)
 		if err != nil {
 			return "", nil, err
 		}
@@ -262,17 +344,55 @@ func (c conj) ToSql() (sql string, args []interface{}, err error) {
 const AndSep = " AND "
 
 func AndExpr(parts ...Sqlizer) Sqlizer {
-	return conj{parts: parts, sep: AndSep, defaultExpr: sqlTrue}
+	return conj{parts: flatten(AndSep, parts), sep: AndSep, defaultExpr: sqlTrue}
 }
 
 const OrSep = " OR "
 
 func OrExpr(parts ...Sqlizer) Sqlizer {
-	return conj{parts: parts, sep: OrSep, defaultExpr: sqlFalse}
+	return conj{parts: flatten(OrSep, parts), sep: OrSep, defaultExpr: sqlFalse}
+}
+
+// flatten splices the parts of nested conjunctions joined by the same sep,
+// so `(a OR b) OR c`, as built one disjunct at a time, renders `(a OR b OR c)`.
+func flatten(sep string, parts []Sqlizer) []Sqlizer {
+	flat := make([]Sqlizer, 0, len(parts))
+	for _, part := range parts {
+		if c, ok := part.(conj); ok && c.sep == sep {
+			flat = append(flat, c.parts...)
+			continue
+		}
+		flat = append(flat, part)
+	}
+	return flat
+}
+
+// InExpr renders `column IN (?, ...)` with one placeholder per value. An
+// empty list matches nothing and renders "1 = 0". column is written as is,
+// like an Expr template, so it must not hold a question mark.
+func InExpr(column string, values []interface{}) Sqlizer {
+	if len(values) == 0 {
+		return Expr(sqlFalse)
+	}
+	return Expr(column+" IN ("+strings.Repeat(", ?", len(values))[2:]+")", values...)
+}
+
+// NotInExpr is the negation of InExpr. An empty list excludes nothing and
+// renders "1 = 1".
+func NotInExpr(column string, values []interface{}) Sqlizer {
+	if len(values) == 0 {
+		return Expr(sqlTrue)
+	}
+	return Expr(column+" NOT IN ("+strings.Repeat(", ?", len(values))[2:]+")", values...)
 }
 
 func ToSql(node ast.IsNode, env eval.Env, mapper FieldMapper) (sql string, args []interface{}, err error) {
-	result, err := toSqlOrValue(node, env, mapper)
+	return ToSqlWithOptions(node, env, mapper, Options{})
+}
+
+// ToSqlWithOptions is ToSql with explicit rendering options.
+func ToSqlWithOptions(node ast.IsNode, env eval.Env, mapper FieldMapper, opts Options) (sql string, args []interface{}, err error) {
+	result, err := toSqlOrValue(node, env, mapper, &opts)
 	if err != nil {
 		return "", nil, err
 	}
@@ -286,15 +406,97 @@ func ToSql(node ast.IsNode, env eval.Env, mapper FieldMapper) (sql string, args
 		}
 		return sqlFalse, nil, nil
 	}
-	return result.ToSql()
+	sql, args, err = Render(result, opts.Placeholder, opts.StartIndex)
+	if err != nil {
+		return "", nil, err
+	}
+	return sql, opts.bindArgs(args), nil
+}
+
+// ToNamedSql is ToSql writing ":p1"..":pN" placeholders, with the args keyed
+// by their names, e.g. for sqlx NamedQuery. Names only depend on position, so
+// the same filter always renders to the same statement.
+func ToNamedSql(node ast.IsNode, env eval.Env, mapper FieldMapper) (string, map[string]interface{}, error) {
+	sql, args, err := ToSqlWithOptions(node, env, mapper, Options{Placeholder: Colon})
+	if err != nil {
+		return "", nil, err
+	}
+	named := make(map[string]interface{}, len(args))
+	for i, arg := range args {
+		named[ColonPrefix[1:]+strconv.Itoa(i+1)] = arg
+	}
+	return sql, named, nil
+}
+
+// Compile renders node like ToSql but returns the predicate as a Sqlizer, so
+// it can be combined with other Sqlizers, e.g. AndExpr(tenant, pred), before
+// a single Render. When node evaluates to a concrete value instead, the
+// Sqlizer is nil, concrete is true and value holds it.
+func Compile(node ast.IsNode, env eval.Env, mapper FieldMapper) (pred Sqlizer, concrete bool, value cedar.Value, err error) {
+	return CompileWithOptions(node, env, mapper, Options{})
+}
+
+// CompileWithOptions is Compile with explicit rendering options. The
+// placeholder format and StartIndex are left to whatever renders the
+// predicate; the other options apply.
+func CompileWithOptions(node ast.IsNode, env eval.Env, mapper FieldMapper, opts Options) (pred Sqlizer, concrete bool, value cedar.Value, err error) {
+	result, err := toSqlOrValue(node, env, mapper, &opts)
+	if err != nil {
+		return nil, false, nil, err
+	}
+	if result.isValue {
+		return nil, true, result.value, nil
+	}
+	return boundArgs{Sqlizer: result.sqlizer, opts: opts}, false, nil, nil
+}
+
+// boundArgs applies the options' arg binding to a compiled predicate.
+type boundArgs struct {
+	Sqlizer
+	opts Options
+}
+
+func (b boundArgs) ToSql() (string, []interface{}, error) {
+	return unescaped(b)
+}
+
+func (b boundArgs) toEscapedSql() (string, []interface{}, error) {
+	sql, args, err := toEscapedSql(b.Sqlizer)
+	if err != nil {
+		return "", nil, err
+	}
+	return sql, b.opts.bindArgs(args), nil
 }
 
 type result struct {
 	isValue bool
 	value   cedar.Value
 	sqlizer Sqlizer
+	// column is set when the result is a mapped column
+	column ColumnSpec
+	// bound is set when the result compares a mapped column against a value
+	bound *bound
+	// nullable is set when the result tests membership of or in a nullable
+	// column, to that column
+	nullable Sqlizer
+	// negated is set when the result has a dedicated negated form, e.g.
+	// `NOT IN` for `IN`
+	negated Sqlizer
+	// arithmetic is set when the result is an arithmetic expression, so it
+	// is parenthesized as an operand of another one
+	arithmetic bool
+}
+
+// bound is one side of a range, normalized to `column op arg`.
+type bound struct {
+	column string
+	op     string
+	arg    interface{}
 }
 
+// flipped maps a comparison to the one that holds with its operands swapped.
+var flipped = map[string]string{">": "<", ">=": "<=", "<": ">", "<=": ">="}
+
 func valueToResult(isValue bool, value cedar.Value, sqlizer Sqlizer) result {
 	// this value could be a EntityUID, which can be a variable
 	if variable, ok := eval.ToVariable(value); ok {
@@ -316,13 +518,26 @@ func (r result) String() string {
 }
 
 func (r result) ToSql() (string, []interface{}, error) {
-	return r.sqlizer.ToSql()
+	return unescaped(r)
+}
+
+func (r result) toEscapedSql() (string, []interface{}, error) {
+	return toEscapedSql(r.sqlizer)
 }
 
 func (left result) Arg() (interface{}, error) {
 	return utils.ValueToGoValue(left.value)
 }
 
+// ElementArg is Arg for a value compared with the elements of column, so an
+// entity is bound the way the column stores it.
+func (left result) ElementArg(column ColumnSpec) (interface{}, error) {
+	if uid, ok := left.value.(cedar.EntityUID); ok {
+		return column.entityArg(uid), nil
+	}
+	return left.Arg()
+}
+
 func (left result) PgArrayArg() (interface{}, error) {
 	arg, err := left.Arg()
 	if err != nil {
@@ -331,10 +546,31 @@ func (left result) PgArrayArg() (interface{}, error) {
 	return pq.Array(arg), nil
 }
 
+// TextArrayArg binds a concrete set as a text[] of its elements, sorted so
+// the arg is stable, with entities bound the way column stores them.
+func (left result) TextArrayArg(column ColumnSpec) (interface{}, error) {
+	set, ok := left.value.(cedar.Set)
+	if !ok {
+		return nil, fmt.Errorf("%w: expected set, got %v", eval.ErrType, eval.TypeName(left.value))
+	}
+	elements := make([]string, 0, set.Len())
+	for item := range set.All() {
+		arg, err := valueToResult(true, item, nil).ElementArg(column)
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, fmt.Sprint(arg))
+	}
+	slices.Sort(elements)
+	return pq.Array(elements), nil
+}
+
 func (left result) Json() (string, error) {
 	return utils.ValueToJSON(left.value)
 }
 
+// And folds a concrete operand away: true leaves the other side,
+// false makes the whole conjunction false.
 func (left result) And(right result) (result, error) {
 	if left.isValue {
 		if val, err := valueIsTrue(left.value); err != nil {
@@ -342,19 +578,38 @@ func (left result) And(right result) (result, error) {
 		} else if val {
 			return right, nil
 		}
+		return left, nil
 	}
 	if right.isValue {
 		if val, err := valueIsTrue(right.value); err != nil {
 			return valueToResult(false, nil, nil), err
 		} else if val {
 			return left, nil
-		} else {
-			return valueToResult(false, nil, AndExpr(left.sqlizer, right.sqlizer)), nil
 		}
+		return right, nil
 	}
 	return valueToResult(false, nil, AndExpr(left.sqlizer, right.sqlizer)), nil
 }
 
+// Between collapses `col >= ? AND col <= ?` into `col BETWEEN ? AND ?`.
+// BETWEEN is inclusive on both ends, so any strict bound leaves the
+// conjunction as it is.
+func (left result) Between(right result) (ret result, ok bool) {
+	if left.bound == nil || right.bound == nil || left.bound.column != right.bound.column {
+		return ret, false
+	}
+	lower, upper := left.bound, right.bound
+	if lower.op == "<=" {
+		lower, upper = upper, lower
+	}
+	if lower.op != ">=" || upper.op != "<=" {
+		return ret, false
+	}
+	return valueToResult(false, nil, Expr("? BETWEEN ? AND ?", newPart(lower.column), lower.arg, upper.arg)), true
+}
+
+// Or folds a concrete operand away: false leaves the other side,
+// true makes the whole disjunction true.
 func (left result) Or(right result) (result, error) {
 	if left.isValue {
 		if val, err := valueIsFalse(left.value); err != nil {
@@ -362,6 +617,7 @@ func (left result) Or(right result) (result, error) {
 		} else if val {
 			return right, nil
 		}
+		return left, nil
 	}
 	if right.isValue {
 		if val, err := valueIsFalse(right.value); err != nil {
@@ -369,20 +625,132 @@ func (left result) Or(right result) (result, error) {
 		} else if val {
 			return left, nil
 		}
+		return right, nil
 	}
 	return valueToResult(false, nil, OrExpr(left.sqlizer, right.sqlizer)), nil
 }
 
+// Range renders `left op right` for an ordering operator and records the
+// bound when a mapped column is compared against a value, so And can
+// collapse a pair of bounds.
+func (left result) Range(right result, op string) (result, error) {
+	ret, err := left.Compare(right, "? "+op+" ?")
+	if err != nil {
+		return ret, err
+	}
+	column, value := left, right
+	if left.isValue {
+		column, value, op = right, left, flipped[op]
+	}
+	if column.column.Column == "" || !value.isValue {
+		return ret, nil
+	}
+	sql, args, err := toEscapedSql(column.sqlizer)
+	if err != nil || len(args) > 0 {
+		return ret, err
+	}
+	arg, err := value.columnArg(column)
+	if err != nil {
+		return ret, err
+	}
+	ret.bound = &bound{column: sql, op: op, arg: arg}
+	return ret, nil
+}
+
+// Equal renders an equality comparison, collated when either side is a
+// column with a collation. With Options.NullSafe, `==` and `!=` against a
+// nullable column are rendered null-safe for the dialect.
+func (left result) Equal(right result, exprStr string, opts *Options) (result, error) {
+	if opts != nil && opts.NullSafe && (left.column.Nullable || right.column.Nullable) {
+		switch exprStr {
+		case "? = ?":
+			exprStr = opts.Dialect.notDistinct()
+		case "? != ?":
+			exprStr = opts.Dialect.distinct()
+		}
+	}
+	if (opts.caseInsensitive(left) || opts.caseInsensitive(right)) && left.isText() && right.isText() {
+		var err error
+		if left, err = left.lowered(); err != nil {
+			return valueToResult(false, nil, nil), err
+		}
+		if right, err = right.lowered(); err != nil {
+			return valueToResult(false, nil, nil), err
+		}
+	}
+	if left.isRecord() || right.isRecord() {
+		return left.jsonEqual(right, exprStr, opts)
+	}
+	ret, err := left.Compare(right, exprStr)
+	if err != nil {
+		return ret, err
+	}
+	column := left.column
+	if column.Collation == "" {
+		column = right.column
+	}
+	collated, err := column.collate(ret.sqlizer)
+	if err != nil {
+		return valueToResult(false, nil, nil), err
+	}
+	return valueToResult(false, nil, collated), nil
+}
+
+// isRecord reports whether r is a record literal.
+func (r result) isRecord() bool {
+	_, ok := r.value.(cedar.Record)
+	return r.isValue && ok
+}
+
+// jsonEqual compares a json column with a record literal, bound as json so
+// the comparison ignores the attributes' order and spacing.
+func (left result) jsonEqual(right result, exprStr string, opts *Options) (result, error) {
+	args := make([]interface{}, 2)
+	for i, r := range []result{left, right} {
+		if !r.isValue {
+			args[i] = r.sqlizer
+			continue
+		}
+		arg, err := r.Arg()
+		if err != nil {
+			return valueToResult(false, nil, nil), err
+		}
+		args[i] = Expr(opts.dialect().json(), arg)
+	}
+	return valueToResult(false, nil, Expr(exprStr, args...)), nil
+}
+
+// isText reports whether r may be compared as text: a column, or a string.
+func (r result) isText() bool {
+	if !r.isValue {
+		return true
+	}
+	_, ok := r.value.(cedar.String)
+	return ok
+}
+
+// lowered wraps r in lower() for a case-insensitive comparison.
+func (r result) lowered() (result, error) {
+	if !r.isValue {
+		return valueToResult(false, nil, Expr("lower(?)", r.sqlizer)), nil
+	}
+	arg, err := r.Arg()
+	if err != nil {
+		return valueToResult(false, nil, nil), err
+	}
+	return valueToResult(false, nil, Expr("lower(?)", arg)), nil
+}
+
 func (left result) Compare(right result, exprStr string) (result, error) {
 	if left.isValue {
-		arg, err := left.Arg()
+		arg, err := left.columnArg(right)
 		if err != nil {
 			return valueToResult(false, nil, nil), err
 		}
 		return valueToResult(false, nil, Expr(exprStr, arg, right.sqlizer)), nil
 	}
 	if right.isValue {
-		arg, err := right.Arg()
+		arg, err := right.columnArg(left)
 		if err != nil {
 			return valueToResult(false, nil, nil), err
 		}
@@ -391,68 +759,291 @@ func (left result) Compare(right result, exprStr string) (result, error) {
 	return valueToResult(false, nil, Expr(exprStr, left.sqlizer, right.sqlizer)), nil
 }
 
+// Arithmetic renders `left op right`. The result is typed TypeDecimal when
+// either operand is a decimal, so the values it is later compared with are
+// cast to match. An operand that is itself arithmetic is parenthesized, so
+// `(a + b) * c` keeps its grouping.
+func (left result) Arithmetic(right result, exprStr string) (result, error) {
+	ret, err := left.grouped().Compare(right.grouped(), exprStr)
+	if err != nil {
+		return ret, err
+	}
+	if left.isDecimal() || right.isDecimal() {
+		ret.column.Type = TypeDecimal
+	}
+	ret.arithmetic = true
+	return ret, nil
+}
+
+// grouped parenthesizes an arithmetic result.
+func (r result) grouped() result {
+	if r.arithmetic {
+		r.sqlizer = Expr("(?)", r.sqlizer)
+	}
+	return r
+}
+
+func (r result) isDecimal() bool {
+	_, ok := r.value.(cedar.Decimal)
+	return ok || r.column.Type == TypeDecimal
+}
+
+// columnArg is Arg for a value compared or combined with other. Decimals are
+// bound as Options.Decimal selects and cast to numeric, and so are longs that
+// meet a decimal column, which keeps `numeric * integer` from failing to type
+// check.
+// Values compared with a uuid column must be well-formed uuids and are cast
+// to uuid so the column's index can be used. IP addresses are bound as text
+// and cast to inet.
+func (value result) columnArg(other result) (interface{}, error) {
+	arg, err := value.Arg()
+	if err != nil {
+		return nil, err
+	}
+	if other.column.Type == TypeUUID {
+		if s, ok := arg.(string); !ok || !isUUID(s) {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidUUID, arg)
+		}
+		return Expr("?::uuid", arg), nil
+	}
+	if _, ok := value.value.(cedar.IPAddr); ok {
+		return Expr("?::inet", arg), nil
+	}
+	if value.isDecimal() || (other.isDecimal() && isLong(value.value)) {
+		// a decimal a registered converter bound otherwise is left as is
+		if d, ok := value.value.(cedar.Decimal); ok && arg == d.String() {
+			arg = decimalArg(d)
+		}
+		return Expr("CAST(? AS numeric)", arg), nil
+	}
+	return arg, nil
+}
+
+var ErrInvalidUUID = errors.New("invalid uuid")
+
+// isUUID reports whether s is a uuid in its canonical hyphenated form.
+func isUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		switch i {
+		case 8, 13, 18, 23:
+			if s[i] != '-' {
+				return false
+			}
+		default:
+			if !strings.ContainsRune("0123456789abcdefABCDEF", rune(s[i])) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func isLong(value cedar.Value) bool {
+	_, ok := value.(cedar.Long)
+	return ok
+}
+
 // in postgres, contains, containsAny, containsAll are all jsonb operators
-// left is jsonb, right is text or text[]
-// users.block.contains(User::"alice") => users.block ? 'User::"alice"'
-// users.block.containsAny(User::"alice") => users.block ?! array['User::"alice"']
-// users.block.containsAll(User::"alice") => users.block ?! array['User::"alice"']
-func (left result) JsonCompareText(right result, exprStr string) (result, error) {
+// left is jsonb, right is text for `?` and text[] for `?|` and `?&`
+// users.block.contains("alice") => users.block ? 'alice'
+// users.block.containsAny(["alice"]) => users.block ?| '{alice}'::text[]
+// users.block.containsAll(["alice"]) => users.block ?& '{alice}'::text[]
+// a jsonb column on the right is expanded to its text elements.
+// in mysql they are JSON_CONTAINS and JSON_OVERLAPS, with the right side bound
+// as a json document, in sqlite they search the set with json_each.
+func (left result) JsonCompareText(right result, op setOp, opts *Options) (result, error) {
 	if left.isValue {
 		return valueToResult(false, nil, nil), fmt.Errorf("cotains containsAny containsAll left side must be a sql column")
 	}
+	dialect := opts.dialect()
+	if dialect == Postgres && op == setContains && right.isRecord() {
+		// jsonb has no element test for objects, contain a one element array;
+		// like MySQL's JSON_CONTAINS, an element with more attributes matches
+		element, err := utils.ValueToJSON(cedar.NewSet(right.value))
+		if err != nil {
+			return valueToResult(false, nil, nil), err
+		}
+		return valueToResult(false, nil, Expr("? @> ?::jsonb", left.sqlizer, element)), nil
+	}
 	if right.isValue {
-		arg, err := right.Arg()
+		var arg interface{}
+		var err error
+		switch {
+		case dialect == MySQL || dialect == SQLite && op != setContains:
+			arg, err = right.Json()
+		case op != setContains:
+			arg, err = right.TextArrayArg(left.column)
+			arg = Expr("?::text[]", arg)
+		default:
+			arg, err = right.ElementArg(left.column)
+		}
 		if err != nil {
 			return valueToResult(false, nil, nil), err
 		}
-		return valueToResult(false, nil, Expr(exprStr, left.sqlizer, arg)), nil
+		return valueToResult(false, nil, dialect.jsonSet(op, left.sqlizer, arg)), nil
 	}
-	return valueToResult(false, nil, Expr(exprStr, left.sqlizer, right.sqlizer)), nil
+	if dialect == MySQL && op == setContains {
+		return valueToResult(false, nil, dialect.jsonSet(op, left.sqlizer, Expr("JSON_ARRAY(?)", right.sqlizer))), nil
+	}
+	if dialect == Postgres && op != setContains && right.column.Type == TypeJSONB {
+		return valueToResult(false, nil, dialect.jsonSet(op, left.sqlizer, Expr("ARRAY(SELECT jsonb_array_elements_text(?))", right.sqlizer))), nil
+	}
+	return valueToResult(false, nil, dialect.jsonSet(op, left.sqlizer, right.sqlizer)), nil
+}
+
+// ArraySet renders contains, containsAll and containsAny on a native array
+// column as `? = ANY(col)`, `col @> ?` and `col && ?`, a concrete set bound
+// as an array. ok is false when left is not an array column.
+func (left result) ArraySet(right result, op setOp) (ret result, ok bool, err error) {
+	if left.isValue || left.column.Type != TypeArray {
+		return ret, false, nil
+	}
+	var arg interface{} = right.sqlizer
+	switch {
+	case right.isValue && op == setContains:
+		arg, err = right.ElementArg(left.column)
+	case right.isValue:
+		arg, err = right.TextArrayArg(left.column)
+	}
+	if err != nil {
+		return valueToResult(false, nil, nil), true, err
+	}
+	switch op {
+	case setContainsAll:
+		return valueToResult(false, nil, Expr("? @> ?", left.sqlizer, arg)), true, nil
+	case setContainsAny:
+		return valueToResult(false, nil, Expr("? && ?", left.sqlizer, arg)), true, nil
+	}
+	return valueToResult(false, nil, Expr("? = ANY(?)", arg, left.sqlizer)), true, nil
+}
+
+// TableSet renders containsAll and containsAny of a concrete set on a child
+// table column: one EXISTS per element, and'ed, for containsAll, and a single
+// EXISTS over `element IN (?, ...)` for containsAny. ok is false when left is
+// not a table column.
+func (left result) TableSet(right result, op setOp, opts *Options) (ret result, ok bool, err error) {
+	if left.isValue || left.column.Type != TypeTable {
+		return ret, false, nil
+	}
+	set, isSet := right.value.(cedar.Set)
+	if !right.isValue || !isSet {
+		return valueToResult(false, nil, nil), true, fmt.Errorf("containsAll containsAny on a table column need a concrete set")
+	}
+	args, err := elementArgs(set, left.column, opts)
+	if err != nil {
+		return valueToResult(false, nil, nil), true, err
+	}
+	table := left.column.Table
+	if op == setContainsAny {
+		if len(args) == 0 {
+			return valueToResult(true, cedar.False, nil), true, nil
+		}
+		exists, err := table.exists(left.sqlizer, func(element result) (Sqlizer, error) {
+			in, err := listIn(element, args, opts)
+			return in.sqlizer, err
+		})
+		return valueToResult(false, nil, exists), true, err
+	}
+	if len(args) == 0 {
+		return valueToResult(true, cedar.True, nil), true, nil
+	}
+	all := make([]Sqlizer, len(args))
+	for i, arg := range args {
+		all[i], err = table.exists(left.sqlizer, func(element result) (Sqlizer, error) {
+			return Expr("? = ?", element.sqlizer, arg), nil
+		})
+		if err != nil {
+			return valueToResult(false, nil, nil), true, err
+		}
+	}
+	return valueToResult(false, nil, AndExpr(all...)), true, nil
+}
+
+// Offset renders `left.offset(d)` for a datetime column. A concrete duration
+// is bound in milliseconds; a duration column is taken to be an interval.
+func (left result) Offset(d result, opts *Options) (result, error) {
+	if !d.isValue {
+		return valueToResult(false, nil, Expr("? + ?", left.sqlizer, d.sqlizer)), nil
+	}
+	duration, err := utils.ValueToType[cedar.Duration](d.value)
+	if err != nil {
+		return valueToResult(false, nil, nil), err
+	}
+	return valueToResult(false, nil, Expr(opts.dialect().offset(), left.sqlizer, duration.ToMilliseconds())), nil
+}
+
+// Overlap renders containsAny between two set columns of the same type:
+// native arrays use the `&&` overlap operator, jsonb arrays test whether any
+// element of the right array exists in the left one.
+// ok is false when the operands are not two typed set columns.
+func (left result) Overlap(right result, opts *Options) (ret result, ok bool) {
+	if left.isValue || right.isValue || left.column.Type != right.column.Type {
+		return ret, false
+	}
+	switch left.column.Type {
+	case TypeArray:
+		return valueToResult(false, nil, Expr("? && ?", left.sqlizer, right.sqlizer)), true
+	case TypeJSONB:
+		if dialect := opts.dialect(); dialect != Postgres {
+			return valueToResult(false, nil, dialect.jsonSet(setContainsAny, left.sqlizer, right.sqlizer)), true
+		}
+		return valueToResult(false, nil, Expr("? ??| ARRAY(SELECT jsonb_array_elements_text(?))", left.sqlizer, right.sqlizer)), true
+	}
+	return ret, false
 }
 
-func toSqlOrValue(node ast.IsNode, env eval.Env, mapper FieldMapper) (ret result, err error) {
-	if Debug {
-		fmt.Println(utils.NString(node), "=>")
+func toSqlOrValue(node ast.IsNode, env eval.Env, mapper FieldMapper, opts *Options) (ret result, err error) {
+	if tracer := opts.tracer(); tracer != nil {
 		defer func() {
-			if err == nil {
-				fmt.Println("result", ret.String())
+			if err != nil {
+				tracer.Trace(node, "", err)
 			} else {
-				fmt.Println("error", err)
+				tracer.Trace(node, ret.String(), nil)
 			}
 		}()
 	}
 	switch n := node.(type) {
 	case ast.NodeTypeAccess:
-		ret, err = toAccess(n, env, mapper)
+		ret, err = toAccess(n, env, mapper, opts)
 	case ast.NodeValue:
 		ret = valueToResult(true, n.Value, nil)
 	case ast.NodeTypeNot:
-		ret, err = toSqlNot(n, env, mapper)
+		ret, err = toSqlNot(n, env, mapper, opts)
 	case ast.NodeTypeVariable:
-		ret, err = toSqlVariable(n, env, mapper)
+		ret, err = toSqlVariable(n, env, mapper, opts)
 	case ast.NodeTypeIn:
-		ret, err = toSqlIn(n, env, mapper)
+		ret, err = toSqlIn(n, env, mapper, opts)
 	case ast.NodeTypeAnd, ast.NodeTypeOr, ast.NodeTypeEquals, ast.NodeTypeNotEquals, ast.NodeTypeGreaterThan, ast.NodeTypeGreaterThanOrEqual, ast.NodeTypeLessThan, ast.NodeTypeLessThanOrEqual:
-		ret, err = toSqlBinary(n, env, mapper)
+		ret, err = toSqlBinary(n, env, mapper, opts)
 	case ast.NodeTypeSub, ast.NodeTypeAdd, ast.NodeTypeMult:
-		ret, err = toSqlBinary(n, env, mapper)
+		ret, err = toSqlBinary(n, env, mapper, opts)
 	case ast.NodeTypeContains, ast.NodeTypeContainsAll, ast.NodeTypeContainsAny:
-		ret, err = toSqlBinary(n, env, mapper)
+		ret, err = toSqlBinary(n, env, mapper, opts)
 	case ast.NodeTypeIsEmpty:
-		ret, err = toSqlEmpty(n, env, mapper)
+		ret, err = toSqlEmpty(n, env, mapper, opts)
 	case ast.NodeTypeExtensionCall:
-		if terr, ok := eval.ToPartialError(n); ok {
-			ret = valueToResult(false, nil, nil)
-			err = terr
-		} else {
-			value, terr := nodeToValue(n, env)
-			ret = valueToResult(true, value, nil)
-			err = terr
-		}
+		ret, err = toSqlExtensionCall(n, env, mapper, opts)
 	// node that can only be evaluated to a value or error
 	case ast.NodeTypeHas:
-		ret, err = toSqlHas(n, env, mapper)
-	case ast.NodeTypeGetTag, ast.NodeTypeLike, ast.NodeTypeIfThenElse, ast.NodeTypeIs, ast.NodeTypeIsIn, ast.NodeTypeNegate, ast.NodeTypeRecord, ast.NodeTypeSet:
+		ret, err = toSqlHas(n, env, mapper, opts)
+	case ast.NodeTypeIs:
+		ret, err = toSqlIs(n, env, mapper, opts)
+	case ast.NodeTypeIsIn:
+		ret, err = toSqlIsIn(n, env, mapper, opts)
+	case ast.NodeTypeLike:
+		ret, err = toSqlLike(n, env, mapper, opts)
+	case ast.NodeTypeIfThenElse:
+		ret, err = toSqlIfThenElse(n, env, mapper, opts)
+	case ast.NodeTypeGetTag:
+		ret, err = toSqlTag(n.BinaryNode, false, env, mapper, opts)
+	case ast.NodeTypeHasTag:
+		ret, err = toSqlTag(n.BinaryNode, true, env, mapper, opts)
+	case ast.NodeTypeNegate:
+		ret, err = toSqlNegate(n, env, mapper, opts)
+	case ast.NodeTypeRecord, ast.NodeTypeSet:
 		value, terr := nodeToValue(n, env)
 		ret = valueToResult(true, value, nil)
 		err = terr
@@ -499,6 +1090,42 @@ func getBinaryFields(node ast.IsNode) (op string, left, right ast.IsNode) {
 	}
 }
 
+// withValues returns the binary node with its operands replaced by values.
+func withValues(node ast.IsNode, left, right cedar.Value) ast.IsNode {
+	b := ast.BinaryNode{Left: ast.NodeValue{Value: left}, Right: ast.NodeValue{Value: right}}
+	switch node.(type) {
+	case ast.NodeTypeAnd:
+		return ast.NodeTypeAnd{BinaryNode: b}
+	case ast.NodeTypeOr:
+		return ast.NodeTypeOr{BinaryNode: b}
+	case ast.NodeTypeEquals:
+		return ast.NodeTypeEquals{BinaryNode: b}
+	case ast.NodeTypeNotEquals:
+		return ast.NodeTypeNotEquals{BinaryNode: b}
+	case ast.NodeTypeGreaterThan:
+		return ast.NodeTypeGreaterThan{BinaryNode: b}
+	case ast.NodeTypeGreaterThanOrEqual:
+		return ast.NodeTypeGreaterThanOrEqual{BinaryNode: b}
+	case ast.NodeTypeLessThan:
+		return ast.NodeTypeLessThan{BinaryNode: b}
+	case ast.NodeTypeLessThanOrEqual:
+		return ast.NodeTypeLessThanOrEqual{BinaryNode: b}
+	case ast.NodeTypeAdd:
+		return ast.NodeTypeAdd{BinaryNode: b}
+	case ast.NodeTypeSub:
+		return ast.NodeTypeSub{BinaryNode: b}
+	case ast.NodeTypeMult:
+		return ast.NodeTypeMult{BinaryNode: b}
+	case ast.NodeTypeContains:
+		return ast.NodeTypeContains{BinaryNode: b}
+	case ast.NodeTypeContainsAll:
+		return ast.NodeTypeContainsAll{BinaryNode: b}
+	case ast.NodeTypeContainsAny:
+		return ast.NodeTypeContainsAny{BinaryNode: b}
+	}
+	return node
+}
+
 func valueIsTrue(value cedar.Value) (bool, error) {
 	val, err := utils.ValueToType[cedar.Boolean](value)
 	if err != nil {
@@ -515,18 +1142,29 @@ func valueIsFalse(value cedar.Value) (bool, error) {
 	return val == cedar.False, nil
 }
 
-func toSqlBinary(node ast.IsNode, env eval.Env, mapper FieldMapper) (result, error) {
+func toSqlBinary(node ast.IsNode, env eval.Env, mapper FieldMapper, opts *Options) (result, error) {
+	if ret, ok, err := toDurationSince(node, env, mapper, opts); ok || err != nil {
+		return ret, err
+	}
 	_, left, right := getBinaryFields(node)
-	leftResult, err := toSqlOrValue(left, env, mapper)
+	leftResult, err := toSqlOrValue(left, env, mapper, opts)
 	if err != nil {
 		return valueToResult(false, nil, nil), err
 	}
-	rightResult, err := toSqlOrValue(right, env, mapper)
+	rightResult, err := toSqlOrValue(right, env, mapper, opts)
 	if err != nil {
 		return valueToResult(false, nil, nil), err
 	}
+	if leftResult, err = operand(leftResult, mapper, opts); err != nil {
+		return valueToResult(false, nil, nil), err
+	}
+	if rightResult, err = operand(rightResult, mapper, opts); err != nil {
+		return valueToResult(false, nil, nil), err
+	}
 	if leftResult.isValue && rightResult.isValue {
-		val, err := nodeToValue(node, env)
+		// the operands may have folded from residuals, e.g. `x || true`, so
+		// evaluate the operator on the folded values rather than on node
+		val, err := nodeToValue(withValues(node, leftResult.value, rightResult.value), env)
 		if err != nil {
 			return valueToResult(false, nil, nil), err
 		}
@@ -534,33 +1172,70 @@ func toSqlBinary(node ast.IsNode, env eval.Env, mapper FieldMapper) (result, err
 	}
 	switch node.(type) {
 	case ast.NodeTypeAnd:
+		if between, ok := leftResult.Between(rightResult); ok && opts != nil && opts.CollapseBetween {
+			return between, nil
+		}
 		return leftResult.And(rightResult)
 	case ast.NodeTypeOr:
 		return leftResult.Or(rightResult)
 	case ast.NodeTypeEquals:
-		return leftResult.Compare(rightResult, "? = ?")
+		return leftResult.Equal(rightResult, "? = ?", opts)
 	case ast.NodeTypeNotEquals:
-		return leftResult.Compare(rightResult, "? != ?")
+		return leftResult.Equal(rightResult, "? != ?", opts)
 	case ast.NodeTypeGreaterThan:
-		return leftResult.Compare(rightResult, "? > ?")
+		return leftResult.Range(rightResult, ">")
 	case ast.NodeTypeGreaterThanOrEqual:
-		return leftResult.Compare(rightResult, "? >= ?")
+		return leftResult.Range(rightResult, ">=")
 	case ast.NodeTypeLessThan:
-		return leftResult.Compare(rightResult, "? < ?")
+		return leftResult.Range(rightResult, "<")
 	case ast.NodeTypeLessThanOrEqual:
-		return leftResult.Compare(rightResult, "? <= ?")
+		return leftResult.Range(rightResult, "<=")
 	case ast.NodeTypeAdd:
-		return leftResult.Compare(rightResult, "? + ?")
+		return leftResult.Arithmetic(rightResult, "? + ?")
 	case ast.NodeTypeSub:
-		return leftResult.Compare(rightResult, "? - ?")
+		return leftResult.Arithmetic(rightResult, "? - ?")
 	case ast.NodeTypeMult:
-		return leftResult.Compare(rightResult, "? * ?")
+		return leftResult.Arithmetic(rightResult, "? * ?")
 	case ast.NodeTypeContains:
-		return leftResult.JsonCompareText(rightResult, "? ?? ?")
+		if set, ok := leftResult.value.(cedar.Set); ok && leftResult.isValue && !rightResult.isValue {
+			return setContainsColumn(set, rightResult, opts)
+		}
+		if leftResult.column.Type == TypeTable && rightResult.isValue {
+			arg, err := rightResult.ElementArg(leftResult.column)
+			if err != nil {
+				return valueToResult(false, nil, nil), err
+			}
+			exists, err := leftResult.column.Table.exists(leftResult.sqlizer, func(element result) (Sqlizer, error) {
+				return Expr("? = ?", element.sqlizer, arg), nil
+			})
+			if err != nil {
+				return valueToResult(false, nil, nil), err
+			}
+			return valueToResult(false, nil, exists), nil
+		}
+		if ret, ok, err := leftResult.ArraySet(rightResult, setContains); ok {
+			return ret, err
+		}
+		return leftResult.JsonCompareText(rightResult, setContains, opts)
 	case ast.NodeTypeContainsAll:
-		return leftResult.JsonCompareText(rightResult, "? ??| ?")
+		if ret, ok, err := leftResult.ArraySet(rightResult, setContainsAll); ok {
+			return ret, err
+		}
+		if ret, ok, err := leftResult.TableSet(rightResult, setContainsAll, opts); ok {
+			return ret, err
+		}
+		return leftResult.JsonCompareText(rightResult, setContainsAll, opts)
 	case ast.NodeTypeContainsAny:
-		return leftResult.JsonCompareText(rightResult, "? ??& ?")
+		if overlap, ok := leftResult.Overlap(rightResult, opts); ok {
+			return overlap, nil
+		}
+		if ret, ok, err := leftResult.ArraySet(rightResult, setContainsAny); ok {
+			return ret, err
+		}
+		if ret, ok, err := leftResult.TableSet(rightResult, setContainsAny, opts); ok {
+			return ret, err
+		}
+		return leftResult.JsonCompareText(rightResult, setContainsAny, opts)
 
 	default:
 		return valueToResult(false, nil, nil), fmt.Errorf("unsupported node type: %T", node)
@@ -568,31 +1243,194 @@ func toSqlBinary(node ast.IsNode, env eval.Env, mapper FieldMapper) (result, err
 
 }
 
-func toAccess(n ast.NodeTypeAccess, env eval.Env, mapper FieldMapper) (result, error) {
-	argResult, err := toSqlOrValue(n.Arg, env, mapper)
-	if err != nil {
-		return valueToResult(false, nil, nil), err
+// toDurationSince folds `a.durationSince(b) op d`, with d a concrete duration
+// and one of a or b a concrete datetime, into a bound on the other, a column.
+// a.durationSince(b) is a - b, so:
+//
+//	col.durationSince(now) < d  =>  col < now + d
+//	now.durationSince(col) < d  =>  col > now - d
+//
+// ok is false when node is not of that shape.
+func toDurationSince(node ast.IsNode, env eval.Env, mapper FieldMapper, opts *Options) (ret result, ok bool, err error) {
+	op, left, right := getBinaryFields(node)
+	if _, ordering := flipped[op]; !ordering {
+		return ret, false, nil
 	}
-	if argResult.isValue {
-		//this could eval to a varaible as EntityUID
-		val, err := eval.Eval(ast.Value(argResult.value).Access(n.Value).AsIsNode(), env)
-		if err != nil {
-			return valueToResult(false, nil, nil), err
-		}
-		return valueToResult(true, val, nil), nil
+	call, ok := durationSinceCall(left)
+	duration := right
+	if !ok {
+		call, ok = durationSinceCall(right)
+		duration, op = left, flipped[op]
 	}
-	sql, args, err := ConcatExpr(argResult.sqlizer, ".", n.Value).ToSql()
+	if !ok {
+		return ret, false, nil
+	}
+	durationResult, err := toSqlOrValue(duration, env, mapper, opts)
+	if err != nil || !durationResult.isValue {
+		return ret, false, nil
+	}
+	d, err := utils.ValueToType[cedar.Duration](durationResult.value)
 	if err != nil {
-		return valueToResult(false, nil, nil), err
+		return ret, true, err
 	}
-	if mapper != nil {
-		field, err := mapper.Map(sql)
+	a, err := toSqlOrValue(call.Args[0], env, mapper, opts)
+	if err != nil {
+		return ret, true, err
+	}
+	b, err := toSqlOrValue(call.Args[1], env, mapper, opts)
+	if err != nil {
+		return ret, true, err
+	}
+	switch {
+	case !a.isValue && b.isValue:
+		now, err := utils.ValueToType[cedar.Datetime](b.value)
+		if err != nil {
+			return ret, true, err
+		}
+		bound := cedar.NewDatetimeFromMillis(now.Milliseconds() + d.ToMilliseconds())
+		ret, err = a.Range(valueToResult(true, bound, nil), op)
+		return ret, true, err
+	case a.isValue && !b.isValue:
+		now, err := utils.ValueToType[cedar.Datetime](a.value)
+		if err != nil {
+			return ret, true, err
+		}
+		bound := cedar.NewDatetimeFromMillis(now.Milliseconds() - d.ToMilliseconds())
+		ret, err = b.Range(valueToResult(true, bound, nil), flipped[op])
+		return ret, true, err
+	}
+	return ret, false, nil
+}
+
+// durationSinceCall reports whether n is a `a.durationSince(b)` call, as
+// opposed to any other extension call such as `duration("1h")`.
+func durationSinceCall(n ast.IsNode) (ast.NodeTypeExtensionCall, bool) {
+	call, ok := n.(ast.NodeTypeExtensionCall)
+	return call, ok && call.Name == "durationSince" && len(call.Args) == 2
+}
+
+// decimalComparisons maps the decimal comparison methods to their operator.
+var decimalComparisons = map[types.Path]string{
+	"lessThan":           "<",
+	"lessThanOrEqual":    "<=",
+	"greaterThan":        ">",
+	"greaterThanOrEqual": ">=",
+}
+
+// toSqlExtensionCall evaluates an extension call on values, and renders the
+// methods that have a SQL operator when an operand is a remaining column.
+//
+//	col.isInRange(ip("10.0.0.0/8"))        =>  col <<= ?::inet
+//	ip("10.0.0.1").isInRange(col)          =>  col >>= ?::inet
+//	col.lessThan(decimal("19.99"))         =>  col < CAST(? AS numeric)
+//	decimal("19.99").lessThanOrEqual(col)  =>  col >= CAST(? AS numeric)
+//	col.offset(duration("1h"))             =>  col + ? * INTERVAL '1 millisecond'
+//
+// `durationSince` against a concrete duration is folded by toDurationSince.
+func toSqlExtensionCall(n ast.NodeTypeExtensionCall, env eval.Env, mapper FieldMapper, opts *Options) (result, error) {
+	if err, ok := eval.ToPartialError(n); ok {
+		return valueToResult(false, nil, nil), err
+	}
+	args := make([]result, len(n.Args))
+	concrete := true
+	for i, arg := range n.Args {
+		argResult, err := toSqlOrValue(arg, env, mapper, opts)
+		if err != nil {
+			return valueToResult(false, nil, nil), err
+		}
+		args[i] = argResult
+		concrete = concrete && argResult.isValue
+	}
+	if concrete {
+		value, err := nodeToValue(n, env)
 		if err != nil {
 			return valueToResult(false, nil, nil), err
 		}
-		sql = field
+		return valueToResult(true, value, nil), nil
 	}
-	return valueToResult(false, nil, newPart(sql, args...)), nil
+	if op, ok := decimalComparisons[n.Name]; ok && len(args) == 2 {
+		// the methods only take decimals, datetimes compare with operators
+		for _, arg := range args {
+			if _, err := utils.ValueToType[cedar.Decimal](arg.value); arg.isValue && err != nil {
+				return valueToResult(false, nil, nil), err
+			}
+		}
+		if args[0].isValue {
+			return args[1].Range(args[0], flipped[op])
+		}
+		return args[0].Range(args[1], op)
+	}
+	switch {
+	case n.Name == "isInRange" && len(args) == 2:
+		if args[0].isValue {
+			return args[1].Compare(args[0], "? >>= ?")
+		}
+		return args[0].Compare(args[1], "? <<= ?")
+	case n.Name == "offset" && len(args) == 2 && !args[0].isValue:
+		return args[0].Offset(args[1], opts)
+	}
+	return valueToResult(false, nil, nil), fmt.Errorf("unsupported extension call on a column: %s", n.Name)
+}
+
+func toAccess(n ast.NodeTypeAccess, env eval.Env, mapper FieldMapper, opts *Options) (result, error) {
+	argResult, err := toSqlOrValue(n.Arg, env, mapper, opts)
+	if err != nil {
+		return valueToResult(false, nil, nil), err
+	}
+	if argResult.isValue {
+		//this could eval to a varaible as EntityUID
+		val, err := eval.Eval(ast.Value(argResult.value).Access(n.Value).AsIsNode(), env)
+		if err != nil {
+			return valueToResult(false, nil, nil), err
+		}
+		if variable, ok := eval.ToVariable(val); ok && strings.Contains(string(variable), ".") {
+			// an attribute left unknown in a concrete record, e.g. a context
+			// attribute the request lacks, is named by its path
+			return mapPath(string(variable), nil, mapper, opts)
+		}
+		return valueToResult(true, val, nil), nil
+	}
+	sql, args, err := ConcatExpr(argResult.sqlizer, ".", n.Value).ToSql()
+	if err != nil {
+		return valueToResult(false, nil, nil), err
+	}
+	return mapPath(sql, args, mapper, opts)
+}
+
+// mapPath maps an attribute path through the mapper, to an expression or a
+// column.
+func mapPath(sql string, args []interface{}, mapper FieldMapper, opts *Options) (result, error) {
+	if e, ok, err := mapExpr(mapper, sql, opts); err != nil || ok {
+		if err != nil {
+			return valueToResult(false, nil, nil), err
+		}
+		return valueToResult(false, nil, e), nil
+	}
+	column, err := mapColumn(mapper, sql, opts)
+	if err != nil {
+		return valueToResult(false, nil, nil), err
+	}
+	ret := valueToResult(false, nil, newPart(column.Column, args...))
+	ret.column = column
+	return ret, nil
+}
+
+// operand maps a bare remaining variable, e.g. a principal left partial as
+// `acting_user`, through the mapper so it can be compared as a column, the
+// one of Options.IDField when set. Attribute paths rooted at a variable are
+// mapped by toAccess instead.
+func operand(r result, mapper FieldMapper, opts *Options) (result, error) {
+	variable, ok := eval.ToVariable(r.value)
+	if !ok {
+		return r, nil
+	}
+	column, err := mapColumn(mapper, opts.entityColumn(string(variable)), opts)
+	if err != nil {
+		return valueToResult(false, nil, nil), err
+	}
+	ret := valueToResult(false, nil, newPart(column.Column))
+	ret.column = column
+	return ret, nil
 }
 
 func nodeToValue(n ast.IsNode, env eval.Env) (value cedar.Value, err error) {
@@ -603,8 +1441,26 @@ func nodeToValue(n ast.IsNode, env eval.Env) (value cedar.Value, err error) {
 	return val, nil
 }
 
-func toSqlNot(n ast.NodeTypeNot, env eval.Env, mapper FieldMapper) (result, error) {
-	argResult, err := toSqlOrValue(n.Arg, env, mapper)
+// toSqlNegate renders `-x` on a remaining column as `-(x)`.
+func toSqlNegate(n ast.NodeTypeNegate, env eval.Env, mapper FieldMapper, opts *Options) (result, error) {
+	argResult, err := toSqlOrValue(n.Arg, env, mapper, opts)
+	if err != nil {
+		return valueToResult(false, nil, nil), err
+	}
+	if argResult.isValue {
+		val, err := eval.Eval(ast.Negate(ast.Value(argResult.value)).AsIsNode(), env)
+		if err != nil {
+			return valueToResult(false, nil, nil), err
+		}
+		return valueToResult(true, val, nil), nil
+	}
+	ret := valueToResult(false, nil, Expr("-(?)", argResult.sqlizer))
+	ret.column.Type = argResult.column.Type
+	return ret, nil
+}
+
+func toSqlNot(n ast.NodeTypeNot, env eval.Env, mapper FieldMapper, opts *Options) (result, error) {
+	argResult, err := toSqlOrValue(n.Arg, env, mapper, opts)
 	if err != nil {
 		return valueToResult(false, nil, nil), err
 	}
@@ -615,11 +1471,191 @@ func toSqlNot(n ast.NodeTypeNot, env eval.Env, mapper FieldMapper) (result, erro
 		}
 		return valueToResult(true, val, nil), nil
 	}
-	return valueToResult(false, nil, Expr("NOT (?)", argResult.sqlizer)), nil
+	negated := Expr("NOT (?)", argResult.sqlizer)
+	if argResult.negated != nil {
+		negated = argResult.negated
+	}
+	if argResult.nullable != nil && opts != nil && opts.NullSafe {
+		// NULL is taken as absent, which passes the negated membership;
+		// plain SQL would make the whole predicate NULL and drop the row
+		return valueToResult(false, nil, Expr("(? IS NULL OR ?)", argResult.nullable, negated)), nil
+	}
+	return valueToResult(false, nil, negated), nil
+}
+
+// toSqlIfThenElse picks the branch when the condition is concrete, and
+// renders a CASE expression otherwise.
+func toSqlIfThenElse(n ast.NodeTypeIfThenElse, env eval.Env, mapper FieldMapper, opts *Options) (result, error) {
+	condResult, err := toSqlOrValue(n.If, env, mapper, opts)
+	if err != nil {
+		return valueToResult(false, nil, nil), err
+	}
+	if condResult.isValue {
+		val, err := valueIsTrue(condResult.value)
+		if err != nil {
+			return valueToResult(false, nil, nil), err
+		}
+		if val {
+			return toSqlOrValue(n.Then, env, mapper, opts)
+		}
+		return toSqlOrValue(n.Else, env, mapper, opts)
+	}
+	thenResult, err := toSqlOrValue(n.Then, env, mapper, opts)
+	if err != nil {
+		return valueToResult(false, nil, nil), err
+	}
+	elseResult, err := toSqlOrValue(n.Else, env, mapper, opts)
+	if err != nil {
+		return valueToResult(false, nil, nil), err
+	}
+	thenExpr, err := thenResult.caseBranch()
+	if err != nil {
+		return valueToResult(false, nil, nil), err
+	}
+	elseExpr, err := elseResult.caseBranch()
+	if err != nil {
+		return valueToResult(false, nil, nil), err
+	}
+	return valueToResult(false, nil, Expr("CASE WHEN ? THEN ? ELSE ? END", condResult.sqlizer, thenExpr, elseExpr)), nil
+}
+
+// caseBranch is the SQL for a CASE branch: booleans are written as
+// predicates like the top level filter, other values are bound.
+func (r result) caseBranch() (interface{}, error) {
+	if !r.isValue {
+		return r.sqlizer, nil
+	}
+	if b, ok := r.value.(cedar.Boolean); ok {
+		if b {
+			return Expr(sqlTrue), nil
+		}
+		return Expr(sqlFalse), nil
+	}
+	return r.Arg()
+}
+
+// toSqlLike renders `like` against a column as SQL LIKE, with the pattern
+// bound as an arg.
+func toSqlLike(n ast.NodeTypeLike, env eval.Env, mapper FieldMapper, opts *Options) (result, error) {
+	argResult, err := toSqlOrValue(n.Arg, env, mapper, opts)
+	if err != nil {
+		return valueToResult(false, nil, nil), err
+	}
+	if argResult.isValue {
+		val, err := eval.Eval(ast.Value(argResult.value).Like(n.Value).AsIsNode(), env)
+		if err != nil {
+			return valueToResult(false, nil, nil), err
+		}
+		return valueToResult(true, val, nil), nil
+	}
+	pattern, err := likePattern(n.Value)
+	if err != nil {
+		return valueToResult(false, nil, nil), err
+	}
+	like := opts.dialect().like()
+	if opts.caseInsensitive(argResult) {
+		like = opts.dialect().ilike()
+	} else if opts != nil && opts.PrefixRange {
+		if prefix, ok := likePrefix(n.Value); ok {
+			parts := []Sqlizer{Expr(like, argResult.sqlizer, pattern), Expr("? >= ?", argResult.sqlizer, prefix)}
+			if upper, ok := prefixUpperBound(prefix); ok {
+				parts = append(parts, Expr("? < ?", argResult.sqlizer, upper))
+			}
+			return valueToResult(false, nil, AndExpr(parts...)), nil
+		}
+	}
+	return valueToResult(false, nil, Expr(like, argResult.sqlizer, pattern)), nil
 }
 
-func toSqlEmpty(n ast.NodeTypeIsEmpty, env eval.Env, mapper FieldMapper) (result, error) {
-	argResult, err := toSqlOrValue(n.Arg, env, mapper)
+// likePrefix returns the literal of a pattern made of a non empty literal
+// followed by a single wildcard, as in "acme/*".
+func likePrefix(p cedar.Pattern) (string, bool) {
+	b, err := p.MarshalJSON()
+	if err != nil {
+		return "", false
+	}
+	var components []json.RawMessage
+	if err := json.Unmarshal(b, &components); err != nil || len(components) != 2 {
+		return "", false
+	}
+	var literal struct{ Literal string }
+	if err := json.Unmarshal(components[0], &literal); err != nil || literal.Literal == "" {
+		return "", false
+	}
+	var wildcard string
+	if err := json.Unmarshal(components[1], &wildcard); err != nil || wildcard != "Wildcard" {
+		return "", false
+	}
+	return literal.Literal, true
+}
+
+// prefixUpperBound returns the least string greater than every string
+// starting with prefix, in code point order: prefix with its last rune
+// incremented. ok is false when every rune is already the largest.
+func prefixUpperBound(prefix string) (string, bool) {
+	runes := []rune(prefix)
+	for i := len(runes) - 1; i >= 0; i-- {
+		if runes[i] == utf8.MaxRune {
+			continue
+		}
+		next := runes[i] + 1
+		if next == 0xD800 {
+			// skip the surrogates, which are not valid in utf-8
+			next = 0xE000
+		}
+		return string(append(runes[:i], next)), true
+	}
+	return "", false
+}
+
+// likePattern converts a cedar pattern to a LIKE pattern: the `*` wildcard
+// becomes `%`, and `%`, `_` and `\` in the literal text are escaped with `\`.
+func likePattern(p cedar.Pattern) (string, error) {
+	// the pattern is only exposed through its cedar literal, in which an
+	// unescaped `*` is a wildcard and `\*` is a literal star
+	quoted := string(p.MarshalCedar())
+	quoted = quoted[1 : len(quoted)-1]
+	buf := &strings.Builder{}
+	segment := &strings.Builder{}
+	flush := func() error {
+		literal, err := strconv.Unquote(`"` + segment.String() + `"`)
+		if err != nil {
+			return fmt.Errorf("invalid like pattern %s: %w", p.MarshalCedar(), err)
+		}
+		for _, r := range literal {
+			if r == '%' || r == '_' || r == '\\' {
+				buf.WriteRune('\\')
+			}
+			buf.WriteRune(r)
+		}
+		segment.Reset()
+		return nil
+	}
+	for i := 0; i < len(quoted); i++ {
+		switch {
+		case quoted[i] == '*':
+			if err := flush(); err != nil {
+				return "", err
+			}
+			buf.WriteByte('%')
+		case quoted[i] == '\\' && i+1 < len(quoted) && quoted[i+1] == '*':
+			segment.WriteByte('*')
+			i++
+		case quoted[i] == '\\' && i+1 < len(quoted):
+			segment.WriteString(quoted[i : i+2])
+			i++
+		default:
+			segment.WriteByte(quoted[i])
+		}
+	}
+	if err := flush(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func toSqlEmpty(n ast.NodeTypeIsEmpty, env eval.Env, mapper FieldMapper, opts *Options) (result, error) {
+	argResult, err := toSqlOrValue(n.Arg, env, mapper, opts)
 	if err != nil {
 		return valueToResult(false, nil, nil), err
 	}
@@ -630,10 +1666,18 @@ func toSqlEmpty(n ast.NodeTypeIsEmpty, env eval.Env, mapper FieldMapper) (result
 		}
 		return valueToResult(true, val, nil), nil
 	}
+	switch argResult.column.Type {
+	case TypeString:
+		return valueToResult(false, nil, Expr("? = ''", argResult.sqlizer)), nil
+	case TypeArray:
+		return valueToResult(false, nil, Expr("cardinality(?) = 0", argResult.sqlizer)), nil
+	case TypeJSONB:
+		return valueToResult(false, nil, Expr("jsonb_array_length(?) = 0", argResult.sqlizer)), nil
+	}
 	return valueToResult(false, nil, Expr("? IS NULL", argResult.sqlizer)), nil
 }
 
-func toSqlVariable(n ast.NodeTypeVariable, env eval.Env, mapper FieldMapper) (result, error) {
+func toSqlVariable(n ast.NodeTypeVariable, env eval.Env, mapper FieldMapper, opts *Options) (result, error) {
 	val, err := eval.Eval(n, env)
 	if err != nil {
 		return valueToResult(false, nil, nil), err
@@ -642,15 +1686,27 @@ func toSqlVariable(n ast.NodeTypeVariable, env eval.Env, mapper FieldMapper) (re
 	return valueToResult(true, val, nil), nil
 }
 
-func toSqlIn(n ast.NodeTypeIn, env eval.Env, mapper FieldMapper) (result, error) {
-	leftResult, err := toSqlOrValue(n.Left, env, mapper)
+func toSqlIn(n ast.NodeTypeIn, env eval.Env, mapper FieldMapper, opts *Options) (result, error) {
+	return toSqlInType(n, "", env, mapper, opts)
+}
+
+// toSqlInType is toSqlIn for a left operand known to be of type isType, as
+// in `e is T in E`, or of any type when isType is empty.
+func toSqlInType(n ast.NodeTypeIn, isType cedar.EntityType, env eval.Env, mapper FieldMapper, opts *Options) (result, error) {
+	leftResult, err := toSqlOrValue(n.Left, env, mapper, opts)
 	if err != nil {
 		return valueToResult(false, nil, nil), err
 	}
-	rightResult, err := toSqlOrValue(n.Right, env, mapper)
+	rightResult, err := toSqlOrValue(n.Right, env, mapper, opts)
 	if err != nil {
 		return valueToResult(false, nil, nil), err
 	}
+	if leftResult, err = operand(leftResult, mapper, opts); err != nil {
+		return valueToResult(false, nil, nil), err
+	}
+	if rightResult, err = operand(rightResult, mapper, opts); err != nil {
+		return valueToResult(false, nil, nil), err
+	}
 
 	if leftResult.isValue && rightResult.isValue {
 		val, err := eval.Eval(ast.Value(leftResult.value).In(ast.Value(rightResult.value)).AsIsNode(), env)
@@ -660,38 +1716,583 @@ func toSqlIn(n ast.NodeTypeIn, env eval.Env, mapper FieldMapper) (result, error)
 		return valueToResult(true, val, nil), nil
 	}
 
-	// principal in viewACL, "User::alice" is in viewACL
-	// left must be EntityUID type, right side of in must be a set,
-	// so in postgres it is "right ? left::jsonb"
-	if leftResult.isValue {
-		leftArg, err := leftResult.Arg()
+	if isType != "" && !leftResult.isValue && chooseIn(leftResult, rightResult) != inList {
+		// only a concrete right side is checked against the ancestry
+		return valueToResult(false, nil, nil), fmt.Errorf("%w: is in on a %s", ErrUnsupportedIn, operandKind(rightResult))
+	}
+	ret, err := renderIn(leftResult, rightResult, operandPath(n.Left), isType, env, mapper, opts)
+	if err == nil && rightResult.column.Nullable {
+		switch rightResult.column.Type {
+		case TypeArray, TypeJSONB, TypeUnknown:
+			ret.nullable = rightResult.sqlizer
+		}
+	}
+	return ret, err
+}
+
+// renderIn renders `left in right` with the strategy chooseIn picks; leftPath
+// is the entity path of a remaining left operand, "" for any other operand,
+// and isType its type when an `is` already checks it.
+func renderIn(leftResult, rightResult result, leftPath string, isType cedar.EntityType, env eval.Env, mapper FieldMapper, opts *Options) (result, error) {
+	switch chooseIn(leftResult, rightResult) {
+	case inEntityColumn:
+		return entityInColumn(leftResult.value, rightResult, env, opts)
+	case inTable:
+		return entityInTable(leftResult.value, rightResult, env, opts)
+	case inArray:
+		if !leftResult.isValue {
+			return valueToResult(false, nil, Expr("? = ANY(?)", leftResult.sqlizer, rightResult.sqlizer)), nil
+		}
+		leftArg, err := leftResult.ElementArg(rightResult.column)
+		if err != nil {
+			return valueToResult(false, nil, nil), err
+		}
+		return valueToResult(false, nil, Expr("? = ANY(?)", leftArg, rightResult.sqlizer)), nil
+	case inJSONB:
+		if !leftResult.isValue {
+			return valueToResult(false, nil, Expr("? @> jsonb_build_array(?)", rightResult.sqlizer, leftResult.sqlizer)), nil
+		}
+		if ret, ok, err := entityInJSONB(leftResult, rightResult, env, opts); ok || err != nil {
+			return ret, err
+		}
+		leftArg, err := leftResult.ElementArg(rightResult.column)
+		if err != nil {
+			return valueToResult(false, nil, nil), err
+		}
+		element, err := json.Marshal([]interface{}{leftArg})
+		if err != nil {
+			return valueToResult(false, nil, nil), err
+		}
+		return valueToResult(false, nil, Expr("? @> ?::jsonb", rightResult.sqlizer, string(element))), nil
+	case inList:
+		return columnInList(leftPath, isType, leftResult, rightResult.value, mapper, opts)
+	case inJSONBKey:
+		// principal in viewACL, "User::alice" is in viewACL
+		// left must be EntityUID type, right side of in must be a set,
+		// so in postgres it is "right ? left::jsonb"
+		if !leftResult.isValue {
+			return valueToResult(false, nil, Expr("? ?? ?", rightResult.sqlizer, leftResult.sqlizer)), nil
+		}
+		if ret, ok, err := entityInJSONB(leftResult, rightResult, env, opts); ok || err != nil {
+			return ret, err
+		}
+		leftArg, err := leftResult.ElementArg(rightResult.column)
 		if err != nil {
 			return valueToResult(false, nil, nil), err
 		}
-
 		return valueToResult(false, nil, Expr("? ?? ?", rightResult.sqlizer, leftArg)), nil
 	}
-	if rightResult.isValue {
-		// if _, err := utils.ValueToType[cedar.Set](rightResult.value); err != nil {
-		// 	return newResult(false, nil, nil), err
-		// }
-		// rightArg, err := rightResult.Arg()
-		// if err != nil {
-		// 	return newResult(false, nil, nil), err
-		// }
+	return valueToResult(false, nil, nil), fmt.Errorf("%w: %s in %s", ErrUnsupportedIn, operandKind(leftResult), operandKind(rightResult))
+}
+
+var ErrUnsupportedIn = errors.New("unsupported in")
+
+// inStrategy is how an `in` with at least one remaining operand is rendered.
+type inStrategy int
+
+const (
+	inUnsupported inStrategy = iota
+	// inEntityColumn: entity in a column holding one entity id, `col IN (?, ...)`
+	// over the entity and its ancestors.
+	inEntityColumn
+	// inTable: entity in a set stored in a child table, an EXISTS subquery.
+	inTable
+	// inArray: value or column in a native array column, `? = ANY(col)`.
+	inArray
+	// inJSONB: value or column in a jsonb array column, `col @> ?`, or
+	// `col ?| ?` over an entity and its ancestors.
+	inJSONB
+	// inList: remaining entity in a concrete entity or set of entities, the
+	// entity being one of them or below one of them in its ancestry column.
+	inList
+	// inJSONBKey: value or column in an untyped column, taken to be a jsonb
+	// array and tested with the `?` key existence operator, `?|` over an
+	// entity and its ancestors.
+	inJSONBKey
+)
+
+// chooseIn picks the strategy for `left in right`. The type of a remaining
+// right column decides first; a concrete right side is matched as a list; a
+// right column the mapper does not type falls back to jsonb key existence.
+func chooseIn(left, right result) inStrategy {
+	if !right.isValue {
+		switch right.column.Type {
+		case TypeEntity:
+			if left.isValue {
+				return inEntityColumn
+			}
+		case TypeTable:
+			if left.isValue {
+				return inTable
+			}
+		case TypeArray:
+			return inArray
+		case TypeJSONB:
+			return inJSONB
+		case TypeUnknown:
+			return inJSONBKey
+		}
+		return inUnsupported
+	}
+	if !left.isValue {
+		return inList
+	}
+	return inUnsupported
+}
+
+// operandKind describes an `in` operand for errors.
+func operandKind(r result) string {
+	if r.isValue {
+		return "value"
+	}
+	switch r.column.Type {
+	case TypeEntity:
+		return "entity column"
+	case TypeString:
+		return "string column"
+	case TypeArray:
+		return "array column"
+	case TypeJSONB:
+		return "jsonb column"
+	case TypeTable:
+		return "table column"
+	case TypeDecimal:
+		return "decimal column"
+	case TypeUUID:
+		return "uuid column"
+	}
+	return "column"
+}
+
+// setContainsColumn renders `[...].contains(col)` for a concrete set as
+// `col IN (?, ...)`, one arg per element.
+func setContainsColumn(set cedar.Set, column result, opts *Options) (result, error) {
+	if set.Len() == 0 {
+		return valueToResult(true, cedar.False, nil), nil
+	}
+	args, err := elementArgs(set, column.column, opts)
+	if err != nil {
+		return valueToResult(false, nil, nil), err
+	}
+	return listIn(column, args, opts)
+}
+
+// elementArgs binds the elements of set the way column stores them, sorted
+// so the args are stable, as sets are unordered.
+func elementArgs(set cedar.Set, column ColumnSpec, opts *Options) ([]interface{}, error) {
+	if set.Len() > opts.maxExpansion() {
+		return nil, fmt.Errorf("%w: set has more than %d elements", ErrMaxExpansion, opts.maxExpansion())
+	}
+	items := slices.SortedFunc(set.All(), func(a, b cedar.Value) int {
+		return strings.Compare(a.String(), b.String())
+	})
+	args := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		arg, err := valueToResult(true, item, nil).ElementArg(column)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+// listIn renders `column IN (?, ...)` over args, keeping the `NOT IN` form
+// for toSqlNot. With Options.ArrayIn on Postgres the args are bound as one
+// array instead, `column = ANY(?)` and `column <> ALL(?)`. The args for a
+// uuid column must be well-formed uuids and are cast to uuid, as columnArg
+// does for a single comparison.
+func listIn(column result, args []interface{}, opts *Options) (result, error) {
+	uuid := column.column.Type == TypeUUID
+	if uuid {
+		for _, arg := range args {
+			if s, ok := arg.(string); !ok || !isUUID(s) {
+				return valueToResult(false, nil, nil), fmt.Errorf("%w: %v", ErrInvalidUUID, arg)
+			}
+		}
+	}
+	var ret result
+	if opts != nil && opts.ArrayIn && opts.Dialect == Postgres {
+		array := interface{}(pq.Array(args))
+		if uuid {
+			array = Expr("?::uuid[]", array)
+		}
+		ret = valueToResult(false, nil, Expr("? = ANY(?)", column.sqlizer, array))
+		ret.negated = Expr("? <> ALL(?)", column.sqlizer, array)
+	} else {
+		placeholders := strings.Repeat(", ?", len(args))[2:]
+		values := make([]interface{}, 0, len(args)+1)
+		values = append(values, column.sqlizer)
+		for _, arg := range args {
+			if uuid {
+				arg = Expr("?::uuid", arg)
+			}
+			values = append(values, arg)
+		}
+		ret = valueToResult(false, nil, Expr("? IN ("+placeholders+")", values...))
+		ret.negated = Expr("? NOT IN ("+placeholders+")", values...)
+	}
+	if column.column.Nullable {
+		ret.nullable = column.sqlizer
+	}
+	return ret, nil
+}
+
+// AncestorsAttribute is the attribute asked of the mapper for the column
+// holding an entity's transitive ancestors, e.g. "resource.__ancestors__".
+// It must be a TypeTable, TypeArray or TypeJSONB column storing fully
+// qualified entities, ElementUID, so an ancestor's type is kept.
+const AncestorsAttribute = "__ancestors__"
+
+// columnInList renders `e in entities`, e being the remaining entity at path,
+// for a concrete entity or set of entities. As in cedar, e is in an entity
+// when it is that entity, type and id alike, or has it among its ancestors,
+// read from the ancestry column mapped for path; without one the hierarchy
+// cannot be checked and the `in` is unsupported. isType is the type e is
+// known to have, if any.
+func columnInList(path string, isType cedar.EntityType, column result, value cedar.Value, mapper FieldMapper, opts *Options) (result, error) {
+	var uids []cedar.EntityUID
+	switch v := value.(type) {
+	case cedar.EntityUID:
+		uids = append(uids, v)
+	case cedar.Set:
+		for item := range v.All() {
+			uid, err := utils.ValueToType[cedar.EntityUID](item)
+			if err != nil {
+				return valueToResult(false, nil, nil), err
+			}
+			uids = append(uids, uid)
+		}
+	default:
+		return valueToResult(false, nil, nil), fmt.Errorf("%w: expected entity or set, got %v", eval.ErrType, eval.TypeName(value))
+	}
+	if len(uids) == 0 {
+		return valueToResult(true, cedar.False, nil), nil
+	}
+	if len(uids) > opts.maxExpansion() {
+		return valueToResult(false, nil, nil), fmt.Errorf("%w: set has more than %d entities", ErrMaxExpansion, opts.maxExpansion())
+	}
+	// sets are unordered, sort the entities so the args are stable
+	slices.SortFunc(uids, func(a, b cedar.EntityUID) int {
+		return strings.Compare(a.String(), b.String())
+	})
+	ancestors, err := ancestryColumn(path, mapper, opts)
+	if err != nil {
+		return valueToResult(false, nil, nil), err
+	}
+	self, err := entityIs(path, isType, column, uids, mapper, opts)
+	if err != nil {
+		return valueToResult(false, nil, nil), err
+	}
+	// the ancestry column stores qualified entities, whichever way a dialect
+	// binds the set
+	set := make([]cedar.Value, len(uids))
+	for i, uid := range uids {
+		set[i] = cedar.String(uid.String())
+	}
+	below, err := ancestors.containsAny(valueToResult(true, cedar.NewSet(set...), nil), opts)
+	if err != nil {
+		return valueToResult(false, nil, nil), err
+	}
+	return self.Or(below)
+}
+
+// ancestryColumn maps the ancestry column of the entity at path, failing
+// with ErrUnsupportedIn when the mapper does not give one that keeps types.
+func ancestryColumn(path string, mapper FieldMapper, opts *Options) (result, error) {
+	if path == "" {
+		return valueToResult(false, nil, nil), fmt.Errorf("%w: in on an expression that is not an entity path", ErrUnsupportedIn)
+	}
+	ancestors, err := mapPath(path+"."+AncestorsAttribute, nil, mapper, opts)
+	if errors.Is(err, ErrInvalidFieldName) {
+		return valueToResult(false, nil, nil), fmt.Errorf("%w: %s has no ancestry column: %w", ErrUnsupportedIn, path, err)
+	}
+	if err != nil {
+		return valueToResult(false, nil, nil), err
+	}
+	switch ancestors.column.Type {
+	case TypeTable, TypeArray, TypeJSONB:
+		if ancestors.column.ElementKey == ElementUID {
+			return ancestors, nil
+		}
+	}
+	return valueToResult(false, nil, nil), fmt.Errorf("%w: %s.%s must be a table, array or jsonb column of qualified entities", ErrUnsupportedIn, path, AncestorsAttribute)
+}
+
+// entityIs renders the remaining entity at path being one of uids, comparing
+// the type as well as the id. A column storing qualified entities carries the
+// type; otherwise the entity's type column, mapped like `is` maps it, is
+// compared per type. When the entity is known to be of isType, only the
+// uids of that type can match, by id.
+func entityIs(path string, isType cedar.EntityType, column result, uids []cedar.EntityUID, mapper FieldMapper, opts *Options) (result, error) {
+	if isType != "" {
+		uids = slices.DeleteFunc(slices.Clone(uids), func(uid cedar.EntityUID) bool {
+			return uid.Type != isType
+		})
+		if len(uids) == 0 {
+			return valueToResult(true, cedar.False, nil), nil
+		}
+	}
+	if column.column.ElementKey == ElementUID {
+		args := make([]interface{}, len(uids))
+		for i, uid := range uids {
+			args[i] = uid.String()
+		}
+		return listIn(column, args, opts)
+	}
+	if isType != "" {
+		args := make([]interface{}, len(uids))
+		for i, uid := range uids {
+			args[i] = string(uid.ID)
+		}
+		return listIn(column, args, opts)
+	}
+	typeColumn, err := mapPath(path+"."+opts.typeField(), nil, mapper, opts)
+	if err != nil {
+		return valueToResult(false, nil, nil), err
+	}
+	ret := valueToResult(true, cedar.False, nil)
+	// uids are sorted, so the ones of a type are adjacent
+	for len(uids) > 0 {
+		n := 1
+		for n < len(uids) && uids[n].Type == uids[0].Type {
+			n++
+		}
+		args := make([]interface{}, n)
+		for i, uid := range uids[:n] {
+			args[i] = string(uid.ID)
+		}
+		ids, err := listIn(column, args, opts)
+		if err != nil {
+			return valueToResult(false, nil, nil), err
+		}
+		typed, err := valueToResult(false, nil, Expr("? = ?", typeColumn.sqlizer, string(uids[0].Type))).And(ids)
+		if err != nil {
+			return valueToResult(false, nil, nil), err
+		}
+		if ret, err = ret.Or(typed); err != nil {
+			return valueToResult(false, nil, nil), err
+		}
+		uids = uids[n:]
+	}
+	return ret, nil
+}
+
+// containsAny renders the set column holding any element of set, whichever
+// of a table, native array or json array it is.
+func (left result) containsAny(set result, opts *Options) (result, error) {
+	if ret, ok, err := left.ArraySet(set, setContainsAny); ok {
+		return ret, err
+	}
+	if ret, ok, err := left.TableSet(set, setContainsAny, opts); ok {
+		return ret, err
+	}
+	return left.JsonCompareText(set, setContainsAny, opts)
+}
+
+// entityInColumn renders `entity in column` for a column holding a single
+// entity id: the entity is in the column's entity when that entity is the
+// entity itself or one of its ancestors, so it becomes `column IN (?, ...)`.
+func entityInColumn(value cedar.Value, column result, env eval.Env, opts *Options) (result, error) {
+	uid, err := utils.ValueToType[cedar.EntityUID](value)
+	if err != nil {
+		return valueToResult(false, nil, nil), err
+	}
+	uids, err := entityAndAncestors(env.Entities, uid, opts.maxExpansion())
+	if err != nil {
+		return valueToResult(false, nil, nil), err
+	}
+	args := make([]interface{}, len(uids))
+	for i, uid := range uids {
+		args[i] = column.column.entityArg(uid)
+	}
+	return listIn(column, args, opts)
+}
 
-		return valueToResult(false, nil, nil), fmt.Errorf("right side of in must be a variable as sql column")
+// entityInTable renders `entity in set` for a set stored in a child table:
+// some row of the set must hold the entity or one of its ancestors.
+func entityInTable(value cedar.Value, column result, env eval.Env, opts *Options) (result, error) {
+	uid, err := utils.ValueToType[cedar.EntityUID](value)
+	if err != nil {
+		return valueToResult(false, nil, nil), err
+	}
+	uids, err := entityAndAncestors(env.Entities, uid, opts.maxExpansion())
+	if err != nil {
+		return valueToResult(false, nil, nil), err
 	}
+	args := make([]interface{}, len(uids))
+	for i, uid := range uids {
+		args[i] = column.column.entityArg(uid)
+	}
+	exists, err := column.column.Table.exists(column.sqlizer, func(element result) (Sqlizer, error) {
+		in, err := listIn(element, args, opts)
+		return in.sqlizer, err
+	})
+	if err != nil {
+		return valueToResult(false, nil, nil), err
+	}
+	return valueToResult(false, nil, exists), nil
+}
 
-	return valueToResult(false, nil, Expr("? ?? ?", rightResult.sqlizer, leftResult.sqlizer)), nil
+// entityInJSONB renders `uid in col` for an entity with ancestors and a jsonb
+// array column as `col ?| ?::text[]`, so the row matches when it lists the
+// entity or any of its ancestors. ok is false when left is not an entity with
+// ancestors, which is left to a single element test.
+func entityInJSONB(left, column result, env eval.Env, opts *Options) (ret result, ok bool, err error) {
+	uid, isUID := left.value.(cedar.EntityUID)
+	if !isUID {
+		return ret, false, nil
+	}
+	uids, err := entityAndAncestors(env.Entities, uid, opts.maxExpansion())
+	if err != nil || len(uids) == 1 {
+		return ret, false, err
+	}
+	ids := make([]string, len(uids))
+	for i, uid := range uids {
+		ids[i] = fmt.Sprint(column.column.entityArg(uid))
+	}
+	return valueToResult(false, nil, Expr("? ??| ?::text[]", column.sqlizer, pq.Array(ids))), true, nil
 }
 
-func toSqlHas(n ast.NodeTypeHas, env eval.Env, mapper FieldMapper) (result, error) {
-	argResult, err := toSqlOrValue(n.Arg, env, mapper)
+// entityAndAncestors returns uid followed by all of its transitive parents,
+// sorted so the generated SQL is stable. It fails once more than limit
+// entities would be returned.
+func entityAndAncestors(entities cedar.EntityGetter, uid cedar.EntityUID, limit int) ([]cedar.EntityUID, error) {
+	seen := map[cedar.EntityUID]bool{uid: true}
+	queue := []cedar.EntityUID{uid}
+	var ancestors []cedar.EntityUID
+	for len(queue) > 0 && entities != nil {
+		entity, ok := entities.Get(queue[0])
+		queue = queue[1:]
+		if !ok {
+			continue
+		}
+		for parent := range entity.Parents.All() {
+			if seen[parent] {
+				continue
+			}
+			seen[parent] = true
+			ancestors = append(ancestors, parent)
+			queue = append(queue, parent)
+		}
+		if len(ancestors)+1 > limit {
+			return nil, fmt.Errorf("%w: %s has more than %d ancestors", ErrMaxExpansion, uid, limit-1)
+		}
+	}
+	slices.SortFunc(ancestors, func(a, b cedar.EntityUID) int {
+		return strings.Compare(a.String(), b.String())
+	})
+	return append([]cedar.EntityUID{uid}, ancestors...), nil
+}
+
+// toSqlIs renders `variable is T`, e.g. a `resource is Document` scope, as
+// `variable.type = ?` so polymorphic tables keep the type filter. The type
+// column is mapped like any attribute path, named by Options.TypeField, so a
+// SqlFieldMapper may render it as an expression.
+func toSqlIs(n ast.NodeTypeIs, env eval.Env, mapper FieldMapper, opts *Options) (result, error) {
+	argResult, err := toSqlOrValue(n.Left, env, mapper, opts)
+	if err != nil {
+		return valueToResult(false, nil, nil), err
+	}
+	variable, ok := eval.ToVariable(argResult.value)
+	if !ok {
+		val, err := nodeToValue(n, env)
+		if err != nil {
+			return valueToResult(false, nil, nil), err
+		}
+		return valueToResult(true, val, nil), nil
+	}
+	column, err := mapPath(string(variable)+"."+opts.typeField(), nil, mapper, opts)
+	if err != nil {
+		return valueToResult(false, nil, nil), err
+	}
+	return valueToResult(false, nil, Expr("? = ?", column.sqlizer, string(n.EntityType))), nil
+}
+
+func toSqlIsIn(n ast.NodeTypeIsIn, env eval.Env, mapper FieldMapper, opts *Options) (result, error) {
+	isResult, err := toSqlIs(n.NodeTypeIs, env, mapper, opts)
+	if err != nil {
+		return valueToResult(false, nil, nil), err
+	}
+	if isResult.isValue {
+		if val, err := valueIsFalse(isResult.value); err != nil || val {
+			return isResult, err
+		}
+	}
+	inResult, err := toSqlInType(ast.NodeTypeIn{BinaryNode: ast.BinaryNode{Left: n.Left, Right: n.Entity}}, n.EntityType, env, mapper, opts)
+	if err != nil {
+		return valueToResult(false, nil, nil), err
+	}
+	return isResult.And(inResult)
+}
+
+// TagsAttribute is the attribute asked of the mapper for the json column
+// holding an entity's tags, e.g. "principal.__tags__".
+const TagsAttribute = "__tags__"
+
+// toSqlTag renders `e.getTag(k)`, or `e.hasTag(k)` when has is set, on a
+// remaining entity against the entity's json tags column: a text lookup,
+// `tags ->> ?` on Postgres, or a key existence test, `tags ? ?`.
+func toSqlTag(n ast.BinaryNode, has bool, env eval.Env, mapper FieldMapper, opts *Options) (result, error) {
+	leftResult, err := toSqlOrValue(n.Left, env, mapper, opts)
+	if err != nil {
+		return valueToResult(false, nil, nil), err
+	}
+	rightResult, err := toSqlOrValue(n.Right, env, mapper, opts)
+	if err != nil {
+		return valueToResult(false, nil, nil), err
+	}
+	if leftResult.isValue && rightResult.isValue {
+		node := ast.Value(leftResult.value).GetTag(ast.Value(rightResult.value))
+		if has {
+			node = ast.Value(leftResult.value).HasTag(ast.Value(rightResult.value))
+		}
+		val, err := eval.Eval(node.AsIsNode(), env)
+		if err != nil {
+			return valueToResult(false, nil, nil), err
+		}
+		return valueToResult(true, val, nil), nil
+	}
+	if leftResult.isValue {
+		return valueToResult(false, nil, nil), fmt.Errorf("tags of a concrete entity need a concrete tag")
+	}
+	sql, args, err := ConcatExpr(leftResult.sqlizer, ".", TagsAttribute).ToSql()
+	if err != nil {
+		return valueToResult(false, nil, nil), err
+	}
+	column, err := mapPath(sql, args, mapper, opts)
+	if err != nil {
+		return valueToResult(false, nil, nil), err
+	}
+	var key interface{} = rightResult.sqlizer
+	if rightResult.isValue {
+		if key, err = rightResult.Arg(); err != nil {
+			return valueToResult(false, nil, nil), err
+		}
+	}
+	tags := column.sqlizer
+	if has {
+		return valueToResult(false, nil, Expr(opts.dialect().hasTag(), tags, key)), nil
+	}
+	ret := valueToResult(false, nil, Expr(opts.dialect().tag(), tags, key))
+	ret.column = ColumnSpec{Type: TypeString}
+	return ret, nil
+}
+
+func toSqlHas(n ast.NodeTypeHas, env eval.Env, mapper FieldMapper, opts *Options) (result, error) {
+	argResult, err := toSqlOrValue(n.Arg, env, mapper, opts)
 	if err != nil {
 		return valueToResult(false, nil, nil), err
 	}
 	if argResult.isValue {
+		if r, ok := argResult.value.(cedar.Record); ok {
+			val, _ := r.Get(n.Value)
+			if variable, ok := eval.ToVariable(val); ok && strings.Contains(string(variable), ".") {
+				// an attribute left unknown in a concrete record may be
+				// missing too, so its mapped path is checked, as in toAccess
+				return hasPath(string(variable), nil, mapper, opts)
+			}
+		}
 		val, err := eval.Eval(ast.Value(argResult.value).Has(n.Value).AsIsNode(), env)
 		if err != nil {
 			return valueToResult(false, nil, nil), err
@@ -703,13 +2304,25 @@ func toSqlHas(n ast.NodeTypeHas, env eval.Env, mapper FieldMapper) (result, erro
 	if err != nil {
 		return valueToResult(false, nil, nil), err
 	}
-	if mapper != nil {
-		field, err := mapper.Map(sql)
+	return hasPath(sql, args, mapper, opts)
+}
+
+// hasPath renders `has` of an attribute path as a null check on the mapped
+// path, or true for a NOT NULL column.
+func hasPath(sql string, args []interface{}, mapper FieldMapper, opts *Options) (result, error) {
+	if e, ok, err := mapExpr(mapper, sql, opts); err != nil || ok {
 		if err != nil {
 			return valueToResult(false, nil, nil), err
 		}
-		sql = field
+		return valueToResult(false, nil, Expr("? IS NOT NULL", e)), nil
+	}
+	column, err := mapColumn(mapper, sql, opts)
+	if err != nil {
+		return valueToResult(false, nil, nil), err
+	}
+	if column.NotNull {
+		return valueToResult(true, cedar.True, nil), nil
 	}
 
-	return valueToResult(false, nil, Expr("? IS NOT NULL", Expr(sql, args...))), nil
+	return valueToResult(false, nil, Expr("? IS NOT NULL", newPart(column.Column, args...))), nil
 }
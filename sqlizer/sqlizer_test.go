@@ -2,14 +2,19 @@ package sqlizer
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math/big"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/cedar-policy/cedar-go"
 	"github.com/cedar-policy/cedar-go/types"
 	"github.com/cedar-policy/cedar-go/x/exp/ast"
 	"github.com/cedar-policy/cedar-go/x/exp/eval"
+	"github.com/lib/pq"
 )
 
 func TestToSql(t *testing.T) {
@@ -19,6 +24,7 @@ func TestToSql(t *testing.T) {
 		node   ast.Node
 		env    eval.Env
 		mapper FieldMapper
+		opts   Options
 		want   string
 		args   []interface{}
 	}{
@@ -32,6 +38,26 @@ func TestToSql(t *testing.T) {
 			want:   "context.foo IS NULL",
 			args:   nil,
 		},
+		{
+			name: "empty string",
+			node: ast.Resource().Access("name").IsEmpty(),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.name": {Column: "document.name", Type: TypeString}},
+			want:   "document.name = ''",
+			args:   nil,
+		},
+		{
+			name: "empty set",
+			node: ast.Resource().Access("tags").IsEmpty(),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.tags": {Column: "document.tags", Type: TypeArray}},
+			want:   "cardinality(document.tags) = 0",
+			args:   nil,
+		},
 		{
 			name: "equal",
 			node: ast.Context().Access("foo").Equal(ast.String("bar")),
@@ -43,79 +69,2205 @@ func TestToSql(t *testing.T) {
 			args:   []interface{}{"bar"},
 		},
 		{
-			name: "not equal",
-			node: ast.Context().Access("foo").NotEqual(ast.String("bar")),
-			env: eval.Env{
-				Context: eval.Variable("context"),
-			},
-			mapper: defaultFieldMapper{},
-			want:   "context.foo != ?",
-			args:   []interface{}{"bar"},
+			name: "not equal",
+			node: ast.Context().Access("foo").NotEqual(ast.String("bar")),
+			env: eval.Env{
+				Context: eval.Variable("context"),
+			},
+			mapper: defaultFieldMapper{},
+			want:   "context.foo != ?",
+			args:   []interface{}{"bar"},
+		},
+		// and
+		{
+			name: "and",
+			node: ast.Context().Access("foo").Equal(ast.String("bar")).And(ast.Context().Access("baz").Equal(ast.Long(50))),
+			env: eval.Env{
+				Context: eval.Variable("context"),
+			},
+			mapper: defaultFieldMapper{},
+			want:   "context.foo = ? AND context.baz = ?",
+			args:   []interface{}{"bar", int64(50)},
+		},
+		// or
+		{
+			name: "or",
+			node: ast.Context().Access("foo").Equal(ast.String("bar")).Or(ast.Context().Access("baz").Equal(ast.Long(50))),
+			env: eval.Env{
+				Context: eval.Variable("context"),
+			},
+			mapper: defaultFieldMapper{},
+			want:   "(context.foo = ? OR context.baz = ?)",
+			args:   []interface{}{"bar", int64(50)},
+		},
+		{
+			name: "concrete context role disjunction folds to true",
+			node: ast.Context().Access("role").Equal(ast.String("admin")).Or(ast.Context().Access("role").Equal(ast.String("editor"))),
+			env: eval.Env{
+				Context: types.NewRecord(types.RecordMap{"role": types.String("editor")}),
+			},
+			mapper: defaultFieldMapper{},
+			want:   "1 = 1",
+			args:   nil,
+		},
+		{
+			name: "concrete context role disjunction allows all rows",
+			node: ast.Context().Access("role").Equal(ast.String("admin")).Or(ast.Resource().Access("is_public").Equal(ast.Boolean(true))),
+			env: eval.Env{
+				Context:  types.NewRecord(types.RecordMap{"role": types.String("admin")}),
+				Resource: eval.Variable("resource"),
+			},
+			mapper: defaultFieldMapper{},
+			want:   "1 = 1",
+			args:   nil,
+		},
+		{
+			name: "concrete context role disjunction drops the false branch",
+			node: ast.Context().Access("role").Equal(ast.String("admin")).Or(ast.Context().Access("role").Equal(ast.String("editor"))).Or(ast.Resource().Access("is_public").Equal(ast.Boolean(true))),
+			env: eval.Env{
+				Context:  types.NewRecord(types.RecordMap{"role": types.String("viewer")}),
+				Resource: eval.Variable("resource"),
+			},
+			mapper: defaultFieldMapper{},
+			want:   "resource.is_public = ?",
+			args:   []interface{}{true},
+		},
+		{
+			name: "false conjunct denies all rows",
+			node: ast.Context().Access("role").Equal(ast.String("admin")).And(ast.Resource().Access("is_public").Equal(ast.Boolean(true))),
+			env: eval.Env{
+				Context:  types.NewRecord(types.RecordMap{"role": types.String("viewer")}),
+				Resource: eval.Variable("resource"),
+			},
+			mapper: defaultFieldMapper{},
+			want:   "1 = 0",
+			args:   nil,
+		},
+		{
+			name: "equal entity",
+			node: ast.Resource().Equal(ast.EntityUID("doc", "123")),
+			env: eval.Env{
+				Context:  eval.Variable("context"),
+				Resource: eval.Variable("resource"),
+			},
+			mapper: defaultFieldMapper{},
+			want:   "resource = ?",
+			args:   []interface{}{"123"},
+		},
+		{
+			name: "partial principal compares column to column",
+			node: ast.Resource().Access("owner").Equal(ast.Principal()),
+			env: eval.Env{
+				Resource:  eval.Variable("resource"),
+				Principal: eval.Variable("acting_user"),
+			},
+			mapper: typedMapper{
+				"resource.owner": {Column: "document.owner"},
+				"acting_user":    {Column: "session.user_id"},
+			},
+			want: "document.owner = session.user_id",
+			args: nil,
+		},
+		{
+			name: "containsAny between two array columns",
+			node: ast.Resource().Access("tags").ContainsAny(ast.Resource().Access("required_tags")),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{
+				"resource.tags":          {Column: "document.tags", Type: TypeArray},
+				"resource.required_tags": {Column: "document.required_tags", Type: TypeArray},
+			},
+			want: "document.tags && document.required_tags",
+			args: nil,
+		},
+		{
+			name: "jsonb contains an entity stored as id",
+			node: ast.Resource().Access("acl").Contains(ast.Principal()),
+			env: eval.Env{
+				Principal: types.NewEntityUID("User", "alice"),
+				Resource:  eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.acl": {Column: "document.acl", Type: TypeJSONB}},
+			want:   "document.acl ? ?",
+			args:   []interface{}{"alice"},
+		},
+		{
+			name: "jsonb contains an entity stored as uid",
+			node: ast.Resource().Access("acl").Contains(ast.Principal()),
+			env: eval.Env{
+				Principal: types.NewEntityUID("User", "alice"),
+				Resource:  eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.acl": {Column: "document.acl", Type: TypeJSONB, ElementKey: ElementUID}},
+			want:   "document.acl ? ?",
+			args:   []interface{}{`User::"alice"`},
+		},
+		{
+			name: "array contains",
+			node: ast.Resource().Access("tags").Contains(ast.String("finance")),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.tags": {Column: "document.tags", Type: TypeArray}},
+			want:   "? = ANY(document.tags)",
+			args:   []interface{}{"finance"},
+		},
+		{
+			name: "array containsAll",
+			node: ast.Resource().Access("tags").ContainsAll(ast.Set(ast.String("hr"), ast.String("finance"))),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.tags": {Column: "document.tags", Type: TypeArray}},
+			want:   "document.tags @> ?",
+			args:   []interface{}{pq.Array([]string{"finance", "hr"})},
+		},
+		{
+			name: "array containsAny",
+			node: ast.Resource().Access("tags").ContainsAny(ast.Set(ast.String("hr"), ast.String("finance"))),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.tags": {Column: "document.tags", Type: TypeArray}},
+			want:   "document.tags && ?",
+			args:   []interface{}{pq.Array([]string{"finance", "hr"})},
+		},
+		{
+			name: "containsAny between two jsonb columns",
+			node: ast.Resource().Access("tags").ContainsAny(ast.Resource().Access("required_tags")),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{
+				"resource.tags":          {Column: "document.tags", Type: TypeJSONB},
+				"resource.required_tags": {Column: "document.required_tags", Type: TypeJSONB},
+			},
+			want: "document.tags ?| ARRAY(SELECT jsonb_array_elements_text(document.required_tags))",
+			args: nil,
+		},
+		{
+			name: "jsonb contains",
+			node: ast.Resource().Access("tags").Contains(ast.String("finance")),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.tags": {Column: "document.tags", Type: TypeJSONB}},
+			want:   "document.tags ? ?",
+			args:   []interface{}{"finance"},
+		},
+		{
+			name: "jsonb containsAll",
+			node: ast.Resource().Access("tags").ContainsAll(ast.Set(ast.String("hr"), ast.String("finance"))),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.tags": {Column: "document.tags", Type: TypeJSONB}},
+			want:   "document.tags ?& ?::text[]",
+			args:   []interface{}{pq.Array([]string{"finance", "hr"})},
+		},
+		{
+			name: "jsonb containsAny",
+			node: ast.Resource().Access("tags").ContainsAny(ast.Set(ast.String("hr"), ast.String("finance"))),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.tags": {Column: "document.tags", Type: TypeJSONB}},
+			want:   "document.tags ?| ?::text[]",
+			args:   []interface{}{pq.Array([]string{"finance", "hr"})},
+		},
+		{
+			name: "record equals jsonb",
+			node: ast.Resource().Access("meta").Equal(ast.Record(ast.Pairs{{Key: "k", Value: ast.String("v")}, {Key: "n", Value: ast.Long(1)}})),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.meta": {Column: "document.meta", Type: TypeJSONB}},
+			want:   "document.meta = ?::jsonb",
+			args:   []interface{}{`{"k":"v","n":1}`},
+		},
+		{
+			name: "record not equals mysql json",
+			node: ast.Record(ast.Pairs{{Key: "k", Value: ast.String("v")}, {Key: "n", Value: ast.Long(1)}}).NotEqual(ast.Resource().Access("meta")),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.meta": {Column: "document.meta", Type: TypeJSONB}},
+			opts:   Options{Dialect: MySQL},
+			want:   "CAST(? AS JSON) != document.meta",
+			args:   []interface{}{`{"k":"v","n":1}`},
+		},
+		{
+			name: "record equals sqlite json",
+			node: ast.Resource().Access("meta").Equal(ast.Record(ast.Pairs{{Key: "k", Value: ast.String("v")}, {Key: "n", Value: ast.Long(1)}})),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.meta": {Column: "document.meta"}},
+			opts:   Options{Dialect: SQLite},
+			want:   "document.meta = json(?)",
+			args:   []interface{}{`{"k":"v","n":1}`},
+		},
+		{
+			name: "jsonb contains record",
+			node: ast.Resource().Access("metas").Contains(ast.Record(ast.Pairs{{Key: "k", Value: ast.String("v")}, {Key: "n", Value: ast.Long(1)}})),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.metas": {Column: "document.metas", Type: TypeJSONB}},
+			want:   "document.metas @> ?::jsonb",
+			args:   []interface{}{`[{"k":"v","n":1}]`},
+		},
+		{
+			name: "mysql json contains record",
+			node: ast.Resource().Access("metas").Contains(ast.Record(ast.Pairs{{Key: "k", Value: ast.String("v")}, {Key: "n", Value: ast.Long(1)}})),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.metas": {Column: "document.metas", Type: TypeJSONB}},
+			opts:   Options{Dialect: MySQL},
+			want:   "JSON_CONTAINS(document.metas, ?)",
+			args:   []interface{}{`{"k":"v","n":1}`},
+		},
+		{
+			name: "jsonb containsAll of a jsonb column",
+			node: ast.Resource().Access("tags").ContainsAll(ast.Resource().Access("required_tags")),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{
+				"resource.tags":          {Column: "document.tags", Type: TypeJSONB},
+				"resource.required_tags": {Column: "document.required_tags", Type: TypeJSONB},
+			},
+			want: "document.tags ?& ARRAY(SELECT jsonb_array_elements_text(document.required_tags))",
+			args: nil,
+		},
+		{
+			name: "mysql contains",
+			node: ast.Resource().Access("tags").Contains(ast.String("finance")),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.tags": {Column: "document.tags", Type: TypeJSONB}},
+			opts:   Options{Dialect: MySQL},
+			want:   "JSON_CONTAINS(document.tags, ?)",
+			args:   []interface{}{`"finance"`},
+		},
+		{
+			name: "mysql contains a column",
+			node: ast.Resource().Access("tags").Contains(ast.Resource().Access("team")),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{
+				"resource.tags": {Column: "document.tags", Type: TypeJSONB},
+				"resource.team": {Column: "document.team", Type: TypeString},
+			},
+			opts: Options{Dialect: MySQL},
+			want: "JSON_CONTAINS(document.tags, JSON_ARRAY(document.team))",
+			args: nil,
+		},
+		{
+			name: "mysql containsAll",
+			node: ast.Resource().Access("tags").ContainsAll(ast.Set(ast.String("finance"))),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.tags": {Column: "document.tags", Type: TypeJSONB}},
+			opts:   Options{Dialect: MySQL},
+			want:   "JSON_CONTAINS(document.tags, ?)",
+			args:   []interface{}{`["finance"]`},
+		},
+		{
+			name: "mysql containsAny",
+			node: ast.Resource().Access("tags").ContainsAny(ast.Set(ast.String("finance"))),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.tags": {Column: "document.tags", Type: TypeJSONB}},
+			opts:   Options{Dialect: MySQL},
+			want:   "JSON_OVERLAPS(document.tags, ?)",
+			args:   []interface{}{`["finance"]`},
+		},
+		{
+			name: "mysql containsAny between two json columns",
+			node: ast.Resource().Access("tags").ContainsAny(ast.Resource().Access("required_tags")),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{
+				"resource.tags":          {Column: "document.tags", Type: TypeJSONB},
+				"resource.required_tags": {Column: "document.required_tags", Type: TypeJSONB},
+			},
+			opts: Options{Dialect: MySQL},
+			want: "JSON_OVERLAPS(document.tags, document.required_tags)",
+			args: nil,
+		},
+		{
+			name: "sqlite contains",
+			node: ast.Resource().Access("tags").Contains(ast.String("finance")),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.tags": {Column: "document.tags", Type: TypeJSONB}},
+			opts:   Options{Dialect: SQLite},
+			want:   "EXISTS (SELECT 1 FROM json_each(document.tags) WHERE value = ?)",
+			args:   []interface{}{"finance"},
+		},
+		{
+			name: "sqlite containsAll",
+			node: ast.Resource().Access("tags").ContainsAll(ast.Set(ast.String("finance"))),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.tags": {Column: "document.tags", Type: TypeJSONB}},
+			opts:   Options{Dialect: SQLite},
+			want:   "NOT EXISTS (SELECT 1 FROM json_each(?) AS r WHERE r.value NOT IN (SELECT value FROM json_each(document.tags)))",
+			args:   []interface{}{`["finance"]`},
+		},
+		{
+			name: "sqlite containsAny between two json columns",
+			node: ast.Resource().Access("tags").ContainsAny(ast.Resource().Access("required_tags")),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{
+				"resource.tags":          {Column: "document.tags", Type: TypeJSONB},
+				"resource.required_tags": {Column: "document.required_tags", Type: TypeJSONB},
+			},
+			opts: Options{Dialect: SQLite},
+			want: "EXISTS (SELECT 1 FROM json_each(document.tags) AS l JOIN json_each(document.required_tags) AS r ON l.value = r.value)",
+			args: nil,
+		},
+		{
+			name: "sqlite binds booleans as integers",
+			node: ast.Resource().Access("is_public").Equal(ast.Boolean(true)),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.is_public": {Column: "document.is_public"}},
+			opts:   Options{Dialect: SQLite},
+			want:   "document.is_public = ?",
+			args:   []interface{}{1},
+		},
+		{
+			name: "getTag on a remaining principal",
+			node: ast.Principal().GetTag(ast.String("dept")).Equal(ast.Resource().Access("dept")),
+			env: eval.Env{
+				Principal: eval.Variable("principal"),
+				Resource:  eval.Variable("resource"),
+			},
+			mapper: typedMapper{
+				"principal.__tags__": {Column: "users.tags", Type: TypeJSONB},
+				"resource.dept":      {Column: "document.dept", Type: TypeString},
+			},
+			want: "users.tags ->> ? = document.dept",
+			args: []interface{}{"dept"},
+		},
+		{
+			name: "hasTag and getTag on a remaining principal",
+			node: ast.Principal().HasTag(ast.String("dept")).And(ast.Principal().GetTag(ast.String("dept")).Equal(ast.Resource().Access("dept"))),
+			env: eval.Env{
+				Principal: eval.Variable("principal"),
+				Resource:  eval.Variable("resource"),
+			},
+			mapper: typedMapper{
+				"principal.__tags__": {Column: "users.tags", Type: TypeJSONB},
+				"resource.dept":      {Column: "document.dept", Type: TypeString},
+			},
+			want: "users.tags ? ? AND users.tags ->> ? = document.dept",
+			args: []interface{}{"dept", "dept"},
+		},
+		{
+			name: "hasTag and getTag on a concrete principal",
+			node: ast.Principal().HasTag(ast.String("dept")).And(ast.Principal().GetTag(ast.String("dept")).Equal(ast.Resource().Access("dept"))),
+			env: eval.Env{
+				Principal: types.NewEntityUID("User", "bob"),
+				Resource:  eval.Variable("resource"),
+				Entities: types.EntityMap{
+					types.NewEntityUID("User", "bob"): {
+						UID:  types.NewEntityUID("User", "bob"),
+						Tags: types.NewRecord(types.RecordMap{"dept": types.String("eng")}),
+					},
+				},
+			},
+			mapper: typedMapper{"resource.dept": {Column: "document.dept", Type: TypeString}},
+			want:   "? = document.dept",
+			args:   []interface{}{"eng"},
+		},
+		{
+			name: "hasTag on a concrete principal without the tag",
+			node: ast.Principal().HasTag(ast.String("dept")),
+			env: eval.Env{
+				Principal: types.NewEntityUID("User", "bob"),
+				Entities: types.EntityMap{
+					types.NewEntityUID("User", "bob"): {UID: types.NewEntityUID("User", "bob")},
+				},
+			},
+			mapper: typedMapper{},
+			want:   "1 = 0",
+			args:   nil,
+		},
+		{
+			name: "mysql getTag",
+			node: ast.Principal().GetTag(ast.String("dept")).Equal(ast.String("eng")),
+			env: eval.Env{
+				Principal: eval.Variable("principal"),
+			},
+			mapper: typedMapper{"principal.__tags__": {Column: "users.tags", Type: TypeJSONB}},
+			opts:   Options{Dialect: MySQL},
+			want:   `JSON_UNQUOTE(JSON_EXTRACT(users.tags, CONCAT('$."', ?, '"'))) = ?`,
+			args:   []interface{}{"dept", "eng"},
+		},
+		{
+			name: "like with leading and trailing wildcards",
+			node: ast.Resource().Access("name").Like(types.NewPattern(types.Wildcard{}, "report", types.Wildcard{})),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.name": {Column: "document.name", Type: TypeString}},
+			want:   `document.name LIKE ? ESCAPE '\'`,
+			args:   []interface{}{"%report%"},
+		},
+		{
+			name: "like prefix with a range",
+			node: ast.Resource().Access("path").Like(types.NewPattern("acme/", types.Wildcard{})),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.path": {Column: "document.path", Type: TypeString}},
+			opts:   Options{PrefixRange: true},
+			want:   `document.path LIKE ? ESCAPE '\' AND document.path >= ? AND document.path < ?`,
+			args:   []interface{}{"acme/%", "acme/", "acme0"},
+		},
+		{
+			name: "like prefix range inside or",
+			node: ast.Resource().Access("path").Like(types.NewPattern("a_b", types.Wildcard{})).Or(ast.Resource().Access("is_public").Equal(ast.True())),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.path": {Column: "document.path", Type: TypeString}},
+			opts:   Options{PrefixRange: true},
+			want:   `(document.path LIKE ? ESCAPE '\' AND document.path >= ? AND document.path < ? OR resource.is_public = ?)`,
+			args:   []interface{}{`a\_b%`, "a_b", "a_c", true},
+		},
+		{
+			name: "like without a prefix keeps like alone",
+			node: ast.Resource().Access("path").Like(types.NewPattern("acme/", types.Wildcard{}, "/draft")),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.path": {Column: "document.path", Type: TypeString}},
+			opts:   Options{PrefixRange: true},
+			want:   `document.path LIKE ? ESCAPE '\'`,
+			args:   []interface{}{"acme/%/draft"},
+		},
+		{
+			name: "like with an embedded wildcard",
+			node: ast.Resource().Access("name").Like(types.NewPattern("2024/", types.Wildcard{}, "/draft")),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.name": {Column: "document.name", Type: TypeString}},
+			want:   `document.name LIKE ? ESCAPE '\'`,
+			args:   []interface{}{"2024/%/draft"},
+		},
+		{
+			name: "like escapes sql wildcards and backslash",
+			node: ast.Resource().Access("name").Like(types.NewPattern("100%_", types.Wildcard{}, `a\b*`)),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.name": {Column: "document.name", Type: TypeString}},
+			want:   `document.name LIKE ? ESCAPE '\'`,
+			args:   []interface{}{`100\%\_%a\\b*`},
+		},
+		{
+			name: "mysql like",
+			node: ast.Resource().Access("name").Like(types.NewPattern("a_", types.Wildcard{})),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.name": {Column: "document.name", Type: TypeString}},
+			opts:   Options{Dialect: MySQL},
+			want:   `document.name LIKE ?`,
+			args:   []interface{}{`a\_%`},
+		},
+		{
+			name: "like on a value is evaluated",
+			node: ast.Principal().Access("name").Like(types.NewPattern("al", types.Wildcard{})),
+			env: eval.Env{
+				Principal: types.NewEntityUID("User", "alice"),
+				Entities: types.EntityMap{
+					types.NewEntityUID("User", "alice"): types.Entity{
+						Attributes: types.NewRecord(types.RecordMap{"name": types.String("alice")}),
+					},
+				},
+			},
+			mapper: typedMapper{},
+			want:   "1 = 1",
+			args:   nil,
+		},
+		{
+			name: "if then else on a column renders case",
+			node: ast.IfThenElse(
+				ast.Resource().Access("premium"),
+				ast.Resource().Access("tier").LessThanOrEqual(ast.Long(3)),
+				ast.Resource().Access("tier").LessThanOrEqual(ast.Long(1)),
+			),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{},
+			want:   "CASE WHEN resource.premium THEN resource.tier <= ? ELSE resource.tier <= ? END",
+			args:   []interface{}{int64(3), int64(1)},
+		},
+		{
+			name: "if then else with a concrete condition picks the branch",
+			node: ast.IfThenElse(
+				ast.Principal().Access("premium"),
+				ast.Resource().Access("tier").LessThanOrEqual(ast.Long(3)),
+				ast.Resource().Access("tier").LessThanOrEqual(ast.Long(1)),
+			),
+			env: eval.Env{
+				Principal: types.NewEntityUID("User", "alice"),
+				Resource:  eval.Variable("resource"),
+				Entities: types.EntityMap{
+					types.NewEntityUID("User", "alice"): types.Entity{
+						Attributes: types.NewRecord(types.RecordMap{"premium": types.False}),
+					},
+				},
+			},
+			mapper: typedMapper{},
+			want:   "resource.tier <= ?",
+			args:   []interface{}{int64(1)},
+		},
+		{
+			name: "if then else with a concrete branch",
+			node: ast.IfThenElse(
+				ast.Resource().Access("is_public"),
+				ast.True(),
+				ast.Resource().Access("owner").Equal(ast.String("alice")),
+			),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{},
+			want:   "CASE WHEN resource.is_public THEN 1 = 1 ELSE resource.owner = ? END",
+			args:   []interface{}{"alice"},
+		},
+		{
+			name: "literal set contains a column",
+			node: ast.Set(ast.String("pending"), ast.String("open")).Contains(ast.Resource().Access("status")),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{},
+			want:   "resource.status IN (?, ?)",
+			args:   []interface{}{"open", "pending"},
+		},
+		{
+			name: "empty set contains a column",
+			node: ast.Set().Contains(ast.Resource().Access("status")),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{},
+			want:   "1 = 0",
+			args:   nil,
+		},
+		{
+			name: "has on a not null column always holds",
+			node: ast.Resource().Has("block").And(ast.Resource().Access("block").Equal(ast.True())),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.block": {Column: "document.block", NotNull: true}},
+			want:   "document.block = ?",
+			args:   []interface{}{true},
+		},
+		{
+			name: "has on a column that may be null",
+			node: ast.Resource().Has("block").And(ast.Resource().Access("block").Equal(ast.True())),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.block": {Column: "document.block"}},
+			want:   "document.block IS NOT NULL AND document.block = ?",
+			args:   []interface{}{true},
+		},
+		{
+			name: "column in an ip range",
+			node: ast.Resource().Access("source_ip").IsInRange(ast.IPAddr(mustIPAddr("10.0.0.0/8"))),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{},
+			want:   "resource.source_ip <<= ?::inet",
+			args:   []interface{}{"10.0.0.0/8"},
+		},
+		{
+			name: "host in a column range",
+			node: ast.IPAddr(mustIPAddr("10.1.2.3/32")).IsInRange(ast.Resource().Access("allowed_cidr")),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{},
+			want:   "resource.allowed_cidr >>= ?::inet",
+			args:   []interface{}{"10.1.2.3"},
+		},
+		{
+			name: "column equals an ip",
+			node: ast.Resource().Access("source_ip").Equal(ast.IPAddr(mustIPAddr("10.1.2.3"))),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{},
+			want:   "resource.source_ip = ?::inet",
+			args:   []interface{}{"10.1.2.3"},
+		},
+		{
+			name: "decimal column less than a decimal",
+			node: ast.Resource().Access("price").DecimalLessThan(ast.Value(mustDecimal("12.5"))),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.price": {Column: "document.price", Type: TypeDecimal}},
+			want:   "document.price < CAST(? AS numeric)",
+			args:   []interface{}{"12.5"},
+		},
+		{
+			name: "decimal at most a decimal column",
+			node: ast.Value(mustDecimal("12.5")).DecimalLessThanOrEqual(ast.Resource().Access("price")),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.price": {Column: "document.price", Type: TypeDecimal}},
+			want:   "document.price >= CAST(? AS numeric)",
+			args:   []interface{}{"12.5"},
+		},
+		{
+			name: "decimal range collapses to between",
+			node: ast.Resource().Access("price").DecimalGreaterThanOrEqual(ast.Value(mustDecimal("0.0001"))).
+				And(ast.Resource().Access("price").DecimalLessThanOrEqual(ast.Value(mustDecimal("19.99")))),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.price": {Column: "document.price", Type: TypeDecimal}},
+			opts:   Options{CollapseBetween: true},
+			want:   "document.price BETWEEN CAST(? AS numeric) AND CAST(? AS numeric)",
+			args:   []interface{}{"0.0001", "19.99"},
+		},
+		{
+			name: "datetime column before a datetime",
+			node: ast.Resource().Access("created_at").LessThan(ast.Datetime(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{},
+			want:   "resource.created_at < ?",
+			args:   []interface{}{time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		},
+		{
+			name: "datetime column offset by a duration",
+			node: ast.Resource().Access("created_at").Offset(ast.Duration(time.Hour)).LessThan(ast.Datetime(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{},
+			want:   "resource.created_at + ? * INTERVAL '1 millisecond' < ?",
+			args:   []interface{}{int64(3600000), time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		},
+		{
+			name: "mysql datetime column offset by a duration",
+			node: ast.Resource().Access("created_at").Offset(ast.Duration(-time.Minute)).GreaterThan(ast.Datetime(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{},
+			opts:   Options{Dialect: MySQL},
+			want:   "resource.created_at + INTERVAL (? * 1000) MICROSECOND > ?",
+			args:   []interface{}{int64(-60000), time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		},
+		{
+			name: "not and or nested three levels",
+			node: ast.Not(ast.Resource().Access("a").Equal(ast.Long(1)).And(
+				ast.Resource().Access("b").Equal(ast.Long(2)).Or(
+					ast.Not(ast.Resource().Access("c").Equal(ast.Long(3)).And(ast.Resource().Access("d").Equal(ast.Long(4)))),
+				),
+			)),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{},
+			want:   "NOT (resource.a = ? AND (resource.b = ? OR NOT (resource.c = ? AND resource.d = ?)))",
+			args:   []interface{}{int64(1), int64(2), int64(3), int64(4)},
+		},
+		{
+			name: "and compared with or",
+			node: ast.Resource().Access("a").And(ast.Resource().Access("b")).Equal(
+				ast.Resource().Access("c").Or(ast.Not(ast.Resource().Access("d").And(ast.Resource().Access("e")))),
+			),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{},
+			want:   "(resource.a AND resource.b) = (resource.c OR NOT (resource.d AND resource.e))",
+			args:   nil,
+		},
+		{
+			name: "and as a case condition",
+			node: ast.IfThenElse(
+				ast.Resource().Access("a").And(ast.Resource().Access("b").Or(ast.Resource().Access("c"))),
+				ast.Resource().Access("d"),
+				ast.False(),
+			),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{},
+			want:   "CASE WHEN (resource.a AND (resource.b OR resource.c)) THEN resource.d ELSE 1 = 0 END",
+			args:   nil,
+		},
+		{
+			name: "case insensitive column equality",
+			node: ast.Resource().Access("name").Equal(ast.String("Alice")),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.name": {Column: "users.name", Type: TypeString}},
+			opts:   Options{CaseInsensitive: []string{"users.name"}},
+			want:   "lower(users.name) = lower(?)",
+			args:   []interface{}{"Alice"},
+		},
+		{
+			name: "case insensitive like",
+			node: ast.Resource().Access("name").Like(types.NewPattern("Al", types.Wildcard{})),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.name": {Column: "users.name", Type: TypeString}},
+			opts:   Options{CaseInsensitive: []string{"users.name"}},
+			want:   `users.name ILIKE ? ESCAPE '\'`,
+			args:   []interface{}{"Al%"},
+		},
+		{
+			name: "case insensitive only applies to listed columns and strings",
+			node: ast.Resource().Access("email").Equal(ast.String("A@x")).
+				And(ast.Resource().Access("name").NotEqual(ast.Long(1))),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{
+				"resource.email": {Column: "users.email", Type: TypeString},
+				"resource.name":  {Column: "users.name", Type: TypeString},
+			},
+			opts: Options{CaseInsensitive: []string{"users.name"}},
+			want: "users.email = ? AND users.name != ?",
+			args: []interface{}{"A@x", int64(1)},
+		},
+		{
+			name: "negated entity set contains is not in",
+			node: ast.Not(ast.Set(ast.EntityUID("User", "bob"), ast.EntityUID("User", "alice")).Contains(ast.Resource().Access("owner"))),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.owner": {Column: "document.owner", Type: TypeEntity, Nullable: true}},
+			want:   "document.owner NOT IN (?, ?)",
+			args:   []interface{}{"alice", "bob"},
+		},
+		{
+			name: "negated membership of a nullable column keeps null rows with null safe",
+			node: ast.Not(ast.Set(ast.EntityUID("User", "bob"), ast.EntityUID("User", "alice")).Contains(ast.Resource().Access("owner"))),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.owner": {Column: "document.owner", Type: TypeEntity, Nullable: true}},
+			opts:   Options{NullSafe: true},
+			want:   "(document.owner IS NULL OR document.owner NOT IN (?, ?))",
+			args:   []interface{}{"alice", "bob"},
+		},
+		{
+			name: "negated literal set contains is not in",
+			node: ast.Not(ast.Set(ast.String("archived"), ast.String("deleted")).Contains(ast.Resource().Access("status"))),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.status": {Column: "document.status", Type: TypeString}},
+			opts:   Options{NullSafe: true},
+			want:   "document.status NOT IN (?, ?)",
+			args:   []interface{}{"archived", "deleted"},
+		},
+		{
+			name: "lowercased identifiers",
+			node: ast.Resource().Access("Owner").Equal(ast.String("bob")),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.Owner": {Column: `Document."Owner"`}},
+			opts:   Options{IdentifierCase: IdentifierLower},
+			want:   `document."Owner" = ?`,
+			args:   []interface{}{"bob"},
+		},
+		{
+			name: "case preserving identifiers",
+			node: ast.Resource().Access("Owner").Equal(ast.String("bob")),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.Owner": {Column: "Document.Owner"}},
+			opts:   Options{IdentifierCase: IdentifierPreserve},
+			want:   `"Document"."Owner" = ?`,
+			args:   []interface{}{"bob"},
+		},
+		{
+			name: "quoted reserved word on mysql",
+			node: ast.Resource().Access("order").Equal(ast.Long(1)),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.order": {Column: "document.order"}},
+			opts:   Options{IdentifierCase: IdentifierPreserve, Dialect: MySQL},
+			want:   "`document`.`order` = ?",
+			args:   []interface{}{int64(1)},
+		},
+		{
+			name: "quoted segments are kept",
+			node: ast.Resource().Access("owner").Equal(ast.String("bob")),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.owner": {Column: `"my.schema".Document.owner`}},
+			opts:   Options{IdentifierCase: IdentifierPreserve},
+			want:   `"my.schema"."Document"."owner" = ?`,
+			args:   []interface{}{"bob"},
+		},
+		{
+			name: "raw column is not quoted",
+			node: ast.Resource().Access("owner").Equal(ast.String("bob")),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.owner": {Column: "lower(document.owner)", Raw: true}},
+			opts:   Options{IdentifierCase: IdentifierPreserve},
+			want:   "lower(document.owner) = ?",
+			args:   []interface{}{"bob"},
+		},
+		{
+			name: "concrete principal attribute in array column",
+			node: ast.Principal().Access("clearance").In(ast.Resource().Access("allowed_clearances")),
+			env: eval.Env{
+				Entities: types.EntityMap{
+					types.NewEntityUID("User", "alice"): types.Entity{
+						UID: types.NewEntityUID("User", "alice"),
+						Attributes: types.NewRecord(types.RecordMap{
+							"clearance": types.NewEntityUID("Clearance", "secret"),
+						}),
+					},
+				},
+				Principal: types.NewEntityUID("User", "alice"),
+				Resource:  eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.allowed_clearances": {Column: "document.allowed_clearances", Type: TypeArray}},
+			want:   "? = ANY(document.allowed_clearances)",
+			args:   []interface{}{"secret"},
+		},
+		{
+			name: "concrete principal attribute in jsonb column",
+			node: ast.Principal().Access("clearance").In(ast.Resource().Access("allowed_clearances")),
+			env: eval.Env{
+				Entities: types.EntityMap{
+					types.NewEntityUID("User", "alice"): types.Entity{
+						UID: types.NewEntityUID("User", "alice"),
+						Attributes: types.NewRecord(types.RecordMap{
+							"clearance": types.NewEntityUID("Clearance", "secret"),
+						}),
+					},
+				},
+				Principal: types.NewEntityUID("User", "alice"),
+				Resource:  eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.allowed_clearances": {Column: "document.allowed_clearances", Type: TypeJSONB}},
+			want:   "document.allowed_clearances @> ?::jsonb",
+			args:   []interface{}{`["secret"]`},
+		},
+		{
+			name: "principal in acl of bare ids",
+			node: ast.Principal().In(ast.Resource().Access("acl")),
+			env: eval.Env{
+				Principal: types.NewEntityUID("User", "alice"),
+				Resource:  eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.acl": {Column: "document.acl", Type: TypeArray}},
+			want:   "? = ANY(document.acl)",
+			args:   []interface{}{"alice"},
+		},
+		{
+			name: "principal in acl of entity uids",
+			node: ast.Principal().In(ast.Resource().Access("acl")),
+			env: eval.Env{
+				Principal: types.NewEntityUID("User", "alice"),
+				Resource:  eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.acl": {Column: "document.acl", Type: TypeArray, ElementKey: ElementUID}},
+			want:   "? = ANY(document.acl)",
+			args:   []interface{}{`User::"alice"`},
+		},
+		{
+			name: "column in concrete set checks the entity and its ancestry",
+			node: ast.Resource().Access("folder").In(ast.Set(ast.EntityUID("Folder", "a"), ast.EntityUID("Folder", "b"))),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{
+				"resource.folder":               {Column: "document.folder", Type: TypeEntity, ElementKey: ElementUID},
+				"resource.folder.__ancestors__": {Column: "document.folder_path", Type: TypeArray, ElementKey: ElementUID},
+			},
+			want: "(document.folder IN (?, ?) OR document.folder_path && ?)",
+			args: []interface{}{`Folder::"a"`, `Folder::"b"`, pq.Array([]string{`Folder::"a"`, `Folder::"b"`})},
+		},
+		{
+			name: "column in three element set expands one placeholder per element",
+			node: ast.Resource().Access("folder").In(ast.Set(ast.EntityUID("Folder", "c"), ast.EntityUID("Folder", "a"), ast.EntityUID("Folder", "b"))),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{
+				"resource.folder":               {Column: "document.folder", Type: TypeEntity, ElementKey: ElementUID},
+				"resource.folder.__ancestors__": {Column: "document.folder_path", Type: TypeArray, ElementKey: ElementUID},
+			},
+			opts: Options{Placeholder: Dollar},
+			want: "(document.folder IN ($1, $2, $3) OR document.folder_path && $4)",
+			args: []interface{}{`Folder::"a"`, `Folder::"b"`, `Folder::"c"`, pq.Array([]string{`Folder::"a"`, `Folder::"b"`, `Folder::"c"`})},
+		},
+		{
+			name: "column in three element set binds one array",
+			node: ast.Resource().Access("folder").In(ast.Set(ast.EntityUID("Folder", "c"), ast.EntityUID("Folder", "a"), ast.EntityUID("Folder", "b"))),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{
+				"resource.folder":               {Column: "document.folder", Type: TypeEntity, ElementKey: ElementUID},
+				"resource.folder.__ancestors__": {Column: "document.folder_path", Type: TypeArray, ElementKey: ElementUID},
+			},
+			opts: Options{Placeholder: Dollar, ArrayIn: true},
+			want: "(document.folder = ANY($1) OR document.folder_path && $2)",
+			args: []interface{}{
+				pq.Array([]interface{}{`Folder::"a"`, `Folder::"b"`, `Folder::"c"`}),
+				pq.Array([]string{`Folder::"a"`, `Folder::"b"`, `Folder::"c"`}),
+			},
+		},
+		{
+			name: "negated literal set contains binds one array",
+			node: ast.Not(ast.Set(ast.String("archived"), ast.String("deleted")).Contains(ast.Resource().Access("status"))),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: defaultFieldMapper{},
+			opts:   Options{ArrayIn: true},
+			want:   "resource.status <> ALL(?)",
+			args:   []interface{}{pq.Array([]interface{}{"archived", "deleted"})},
+		},
+		{
+			name: "array in is postgres only",
+			node: ast.Resource().Access("folder").In(ast.Set(ast.EntityUID("Folder", "b"), ast.EntityUID("Folder", "a"))),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{
+				"resource.folder":               {Column: "document.folder", Type: TypeEntity, ElementKey: ElementUID},
+				"resource.folder.__ancestors__": {Column: "document.folder_path", Type: TypeJSONB, ElementKey: ElementUID},
+			},
+			opts: Options{Dialect: MySQL, ArrayIn: true},
+			want: "(document.folder IN (?, ?) OR JSON_OVERLAPS(document.folder_path, ?))",
+			args: []interface{}{`Folder::"a"`, `Folder::"b"`, `["Folder::\"a\"","Folder::\"b\""]`},
+		},
+		{
+			name: "nested arithmetic keeps its grouping",
+			node: ast.Resource().Access("a").Add(ast.Resource().Access("b")).Multiply(ast.Resource().Access("c")).GreaterThan(ast.Long(100)),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: defaultFieldMapper{},
+			want:   "(resource.a + resource.b) * resource.c > ?",
+			args:   []interface{}{int64(100)},
+		},
+		{
+			name: "arithmetic on the right keeps its grouping",
+			node: ast.Resource().Access("a").Subtract(ast.Resource().Access("b").Subtract(ast.Long(1))).Equal(ast.Long(0)),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: defaultFieldMapper{},
+			want:   "resource.a - (resource.b - ?) = ?",
+			args:   []interface{}{int64(1), int64(0)},
+		},
+		{
+			name: "arithmetic on a decimal column casts the long operand",
+			node: ast.Resource().Access("price").Multiply(ast.Long(2)).GreaterThan(ast.Long(100)),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.price": {Column: "document.price", Type: TypeDecimal}},
+			want:   "document.price * CAST(? AS numeric) > CAST(? AS numeric)",
+			args:   []interface{}{int64(2), int64(100)},
+		},
+		{
+			name: "negated column in a comparison",
+			node: ast.Negate(ast.Resource().Access("balance")).GreaterThan(ast.Long(0)),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: defaultFieldMapper{},
+			want:   "-(resource.balance) > ?",
+			args:   []interface{}{int64(0)},
+		},
+		{
+			name: "negated value is folded",
+			node: ast.Resource().Access("balance").GreaterThan(ast.Negate(ast.Long(5))),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: defaultFieldMapper{},
+			want:   "resource.balance > ?",
+			args:   []interface{}{int64(-5)},
+		},
+		{
+			name: "two columns of the same resource",
+			node: ast.Resource().Access("owner").Equal(ast.Resource().Access("created_by")),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{
+				"resource.owner":      {Column: "document.owner"},
+				"resource.created_by": {Column: "document.created_by"},
+			},
+			want: "document.owner = document.created_by",
+			args: nil,
+		},
+		{
+			name: "entity equality maps the bare variable",
+			node: ast.Resource().Equal(ast.EntityUID("Doc", "123")),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: defaultFieldMapper{},
+			want:   "resource = ?",
+			args:   []interface{}{"123"},
+		},
+		{
+			name: "entity equality maps the id field",
+			node: ast.Resource().Equal(ast.EntityUID("Doc", "123")),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: defaultFieldMapper{},
+			opts:   Options{IDField: "id"},
+			want:   "resource.id = ?",
+			args:   []interface{}{"123"},
+		},
+		{
+			name: "entity in set compares the type and id fields and the ancestry",
+			node: ast.Resource().In(ast.Set(ast.EntityUID("Doc", "2"), ast.EntityUID("Doc", "1"), ast.EntityUID("Folder", "1"))),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+				Entities: types.EntityMap{},
+			},
+			mapper: typedMapper{"resource.__ancestors__": {Column: "resource.ancestors", Type: TypeJSONB, ElementKey: ElementUID}},
+			opts:   Options{IDField: "id"},
+			want:   "(resource.type = ? AND resource.id IN (?, ?) OR resource.type = ? AND resource.id IN (?) OR resource.ancestors ?| ?::text[])",
+			args: []interface{}{"Doc", "1", "2", "Folder", "1",
+				pq.Array([]string{`Doc::"1"`, `Doc::"2"`, `Folder::"1"`})},
+		},
+		{
+			name: "column in array column",
+			node: ast.Resource().Access("owner").In(ast.Resource().Access("editors")),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.editors": {Column: "document.editors", Type: TypeArray}},
+			want:   "resource.owner = ANY(document.editors)",
+			args:   nil,
+		},
+		{
+			name: "not in jsonb acl",
+			node: ast.Not(ast.Principal().In(ast.Resource().Access("blocked"))),
+			env: eval.Env{
+				Principal: types.NewEntityUID("User", "alice"),
+				Resource:  eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.blocked": {Column: "document.blocked", Type: TypeJSONB, Nullable: true}},
+			opts:   Options{},
+			want:   "NOT (document.blocked @> ?::jsonb)",
+			args:   []interface{}{`["alice"]`},
+		},
+		{
+			name: "not in nullable jsonb acl",
+			node: ast.Not(ast.Principal().In(ast.Resource().Access("blocked"))),
+			env: eval.Env{
+				Principal: types.NewEntityUID("User", "alice"),
+				Resource:  eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.blocked": {Column: "document.blocked", Type: TypeJSONB, Nullable: true}},
+			opts:   Options{NullSafe: true},
+			want:   "(document.blocked IS NULL OR NOT (document.blocked @> ?::jsonb))",
+			args:   []interface{}{`["alice"]`},
+		},
+		{
+			name: "principal in acl table uses exists",
+			node: ast.Principal().In(ast.Resource().Access("viewers")),
+			env: eval.Env{
+				Entities: types.EntityMap{
+					types.NewEntityUID("User", "alice"): types.Entity{
+						UID:     types.NewEntityUID("User", "alice"),
+						Parents: types.NewEntityUIDSet(types.NewEntityUID("Group", "admin")),
+					},
+				},
+				Principal: types.NewEntityUID("User", "alice"),
+				Resource:  eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.viewers": {Column: "document.id", Type: TypeTable, Table: &TableSpec{
+				Name: "document_acl", ForeignKey: "document_id", Element: "principal_id",
+			}}},
+			want: "EXISTS (SELECT 1 FROM document_acl WHERE document_acl.document_id = document.id AND document_acl.principal_id IN (?, ?))",
+			args: []interface{}{"alice", "admin"},
+		},
+		{
+			name: "principal in untyped viewers column",
+			node: ast.Principal().In(ast.Resource().Access("viewers")),
+			env: eval.Env{
+				Principal: types.NewEntityUID("User", "alice"),
+				Resource:  eval.Variable("resource"),
+			},
+			mapper: defaultFieldMapper{},
+			want:   "resource.viewers ? ?",
+			args:   []interface{}{"alice"},
+		},
+		{
+			name: "principal with a group in untyped viewers column",
+			node: ast.Principal().In(ast.Resource().Access("viewers")),
+			env: eval.Env{
+				Entities: types.EntityMap{
+					types.NewEntityUID("User", "alice"): types.Entity{
+						UID:     types.NewEntityUID("User", "alice"),
+						Parents: types.NewEntityUIDSet(types.NewEntityUID("Group", "admin")),
+					},
+				},
+				Principal: types.NewEntityUID("User", "alice"),
+				Resource:  eval.Variable("resource"),
+			},
+			mapper: defaultFieldMapper{},
+			want:   "resource.viewers ?| ?::text[]",
+			args:   []interface{}{pq.Array([]string{"alice", "admin"})},
+		},
+		{
+			name: "principal with a group in jsonb viewers column",
+			node: ast.Principal().In(ast.Resource().Access("viewers")),
+			env: eval.Env{
+				Entities: types.EntityMap{
+					types.NewEntityUID("User", "alice"): types.Entity{
+						UID:     types.NewEntityUID("User", "alice"),
+						Parents: types.NewEntityUIDSet(types.NewEntityUID("Group", "admin")),
+					},
+				},
+				Principal: types.NewEntityUID("User", "alice"),
+				Resource:  eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.viewers": {Column: "document.viewers", Type: TypeJSONB, ElementKey: ElementUID}},
+			want:   "document.viewers ?| ?::text[]",
+			args:   []interface{}{pq.Array([]string{`User::"alice"`, `Group::"admin"`})},
+		},
+		{
+			name: "tags table contains uses exists",
+			node: ast.Resource().Access("tags").Contains(ast.String("finance")),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.tags": {Column: "document.id", Type: TypeTable, Table: &TableSpec{
+				Name: "document_tags", ForeignKey: "document_id", Element: "tag",
+			}}},
+			want: "EXISTS (SELECT 1 FROM document_tags WHERE document_tags.document_id = document.id AND document_tags.tag = ?)",
+			args: []interface{}{"finance"},
+		},
+		{
+			name: "tags table containsAll uses an exists per element",
+			node: ast.Resource().Access("tags").ContainsAll(ast.Set(ast.String("finance"), ast.String("legal"))),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.tags": {Column: "document.id", Type: TypeTable, Table: &TableSpec{
+				Name: "document_tags", ForeignKey: "document_id", Element: "tag",
+			}}},
+			want: "EXISTS (SELECT 1 FROM document_tags WHERE document_tags.document_id = document.id AND document_tags.tag = ?) AND " +
+				"EXISTS (SELECT 1 FROM document_tags WHERE document_tags.document_id = document.id AND document_tags.tag = ?)",
+			args: []interface{}{"finance", "legal"},
+		},
+		{
+			name: "tags table containsAny uses exists with in",
+			node: ast.Resource().Access("tags").ContainsAny(ast.Set(ast.String("finance"), ast.String("legal"))),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.tags": {Column: "document.id", Type: TypeTable, Table: &TableSpec{
+				Name: "document_tags", ForeignKey: "document_id", Element: "tag",
+			}}},
+			want: "EXISTS (SELECT 1 FROM document_tags WHERE document_tags.document_id = document.id AND document_tags.tag IN (?, ?))",
+			args: []interface{}{"finance", "legal"},
+		},
+		{
+			name: "inclusive range collapses to between",
+			node: ast.Resource().Access("version").GreaterThanOrEqual(ast.Long(1)).And(ast.Resource().Access("version").LessThanOrEqual(ast.Long(10))),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: defaultFieldMapper{},
+			opts:   Options{CollapseBetween: true},
+			want:   "resource.version BETWEEN ? AND ?",
+			args:   []interface{}{int64(1), int64(10)},
+		},
+		{
+			name: "inclusive range with upper bound first",
+			node: ast.Long(10).GreaterThanOrEqual(ast.Resource().Access("version")).And(ast.Long(1).LessThanOrEqual(ast.Resource().Access("version"))),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: defaultFieldMapper{},
+			opts:   Options{CollapseBetween: true},
+			want:   "resource.version BETWEEN ? AND ?",
+			args:   []interface{}{int64(1), int64(10)},
+		},
+		{
+			name: "inclusive range is kept by default",
+			node: ast.Resource().Access("version").GreaterThanOrEqual(ast.Long(1)).And(ast.Resource().Access("version").LessThanOrEqual(ast.Long(10))),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: defaultFieldMapper{},
+			want:   "resource.version >= ? AND resource.version <= ?",
+			args:   []interface{}{int64(1), int64(10)},
+		},
+		{
+			name: "exclusive range is kept",
+			node: ast.Resource().Access("version").GreaterThan(ast.Long(1)).And(ast.Resource().Access("version").LessThan(ast.Long(10))),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: defaultFieldMapper{},
+			opts:   Options{CollapseBetween: true},
+			want:   "resource.version > ? AND resource.version < ?",
+			args:   []interface{}{int64(1), int64(10)},
+		},
+		{
+			name: "mixed range is kept",
+			node: ast.Resource().Access("version").GreaterThanOrEqual(ast.Long(1)).And(ast.Resource().Access("version").LessThan(ast.Long(10))),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: defaultFieldMapper{},
+			opts:   Options{CollapseBetween: true},
+			want:   "resource.version >= ? AND resource.version < ?",
+			args:   []interface{}{int64(1), int64(10)},
+		},
+		{
+			name: "string equality is collated",
+			node: ast.Resource().Access("name").Equal(ast.String("Zoë")),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.name": {Column: "document.name", Type: TypeString, Collation: "en_US"}},
+			want:   `document.name = ? COLLATE "en_US"`,
+			args:   []interface{}{"Zoë"},
+		},
+		{
+			name: "collation ignored on non string column",
+			node: ast.Resource().Access("owner").NotEqual(ast.String("bob")),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.owner": {Column: "document.owner", Type: TypeEntity, Collation: "en_US"}},
+			want:   "document.owner != ?",
+			args:   []interface{}{"bob"},
+		},
+		{
+			name: "resource is type",
+			node: ast.Resource().Is("Document").And(ast.Resource().Access("owner").Equal(ast.Principal())),
+			env: eval.Env{
+				Resource:  eval.Variable("resource"),
+				Principal: types.NewEntityUID("User", "bob"),
+			},
+			mapper: defaultFieldMapper{},
+			want:   "resource.type = ? AND resource.owner = ?",
+			args:   []interface{}{"Document", "bob"},
+		},
+		{
+			name: "resource is type with a discriminator field",
+			node: ast.Resource().Is("Photo"),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.__entity_type__": {Column: "resources.resource_type"}},
+			opts:   Options{TypeField: "__entity_type__"},
+			want:   "resources.resource_type = ?",
+			args:   []interface{}{"Photo"},
+		},
+		{
+			name: "resource is type with a raw type column",
+			node: ast.Resource().Is("Photo"),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.type": {Column: "Resources.ResourceType", Raw: true}},
+			opts:   Options{IdentifierCase: IdentifierLower},
+			want:   "Resources.ResourceType = ?",
+			args:   []interface{}{"Photo"},
+		},
+		{
+			name: "resource is type in entity",
+			node: ast.Resource().IsIn("Photo", ast.EntityUID("Album", "vacation")),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+				Entities: types.EntityMap{},
+			},
+			mapper: typedMapper{
+				"resource.__entity_type__": {Column: "resources.resource_type"},
+				"resource.id":              {Column: "resources.id"},
+				"resource.__ancestors__": {Column: "resources.id", Type: TypeTable, ElementKey: ElementUID, Table: &TableSpec{
+					Name: "resource_ancestors", ForeignKey: "resource_id", Element: "ancestor",
+				}},
+			},
+			opts: Options{TypeField: "__entity_type__", IDField: "id"},
+			want: "resources.resource_type = ? AND " +
+				"EXISTS (SELECT 1 FROM resource_ancestors WHERE resource_ancestors.resource_id = resources.id AND resource_ancestors.ancestor IN (?))",
+			args: []interface{}{"Photo", `Album::"vacation"`},
+		},
+		{
+			name: "resource is type in a set with an entity of that type",
+			node: ast.Resource().IsIn("Photo", ast.Set(ast.EntityUID("Photo", "cover"), ast.EntityUID("Album", "vacation"))),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+				Entities: types.EntityMap{},
+			},
+			mapper: typedMapper{
+				"resource.id":            {Column: "photo.id"},
+				"resource.type":          {Column: "photo.type"},
+				"resource.__ancestors__": {Column: "photo.albums", Type: TypeArray, ElementKey: ElementUID},
+			},
+			opts: Options{IDField: "id"},
+			want: "photo.type = ? AND (photo.id IN (?) OR photo.albums && ?)",
+			args: []interface{}{"Photo", "cover", pq.Array([]string{`Album::"vacation"`, `Photo::"cover"`})},
+		},
+		{
+			name: "concrete entity of another type is not in",
+			node: ast.Principal().IsIn("Photo", ast.EntityUID("Album", "vacation")).Or(ast.Resource().Access("is_public").Equal(ast.True())),
+			env: eval.Env{
+				Principal: types.NewEntityUID("User", "bob"),
+				Resource:  eval.Variable("resource"),
+				Entities:  types.EntityMap{},
+			},
+			mapper: defaultFieldMapper{},
+			want:   "resource.is_public = ?",
+			args:   []interface{}{true},
+		},
+		{
+			name: "decimal column times long is cast to numeric",
+			node: ast.Resource().Access("price").Multiply(ast.Context().Access("quantity")).GreaterThan(ast.Context().Access("budget")),
+			env: eval.Env{
+				Context: types.NewRecord(types.RecordMap{
+					"quantity": types.Long(3),
+					"budget":   types.Long(100),
+				}),
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.price": {Column: "document.price", Type: TypeDecimal}},
+			want:   "document.price * CAST(? AS numeric) > CAST(? AS numeric)",
+			args:   []interface{}{int64(3), int64(100)},
+		},
+		{
+			name: "table aliases",
+			node: ast.Resource().Access("owner").Equal(ast.Principal()).And(ast.Context().Access("tenant").Equal(ast.Resource().Access("tenant"))),
+			env: eval.Env{
+				Context:   eval.Variable("context"),
+				Resource:  eval.Variable("resource"),
+				Principal: types.NewEntityUID("User", "bob"),
+			},
+			mapper: WithTableAlias("resource", "d").WithTableAlias("context", "c"),
+			want:   "d.owner = ? AND c.tenant = d.tenant",
+			args:   []interface{}{"bob"},
+		},
+		{
+			name: "null safe equal on postgres",
+			node: ast.Resource().Access("owner").Equal(ast.Principal()),
+			env: eval.Env{
+				Resource:  eval.Variable("resource"),
+				Principal: types.NewEntityUID("User", "bob"),
+			},
+			mapper: typedMapper{"resource.owner": {Column: "document.owner", Nullable: true}},
+			opts:   Options{NullSafe: true},
+			want:   "document.owner IS NOT DISTINCT FROM ?",
+			args:   []interface{}{"bob"},
+		},
+		{
+			name: "null safe equal on mysql",
+			node: ast.Resource().Access("owner").Equal(ast.Principal()),
+			env: eval.Env{
+				Resource:  eval.Variable("resource"),
+				Principal: types.NewEntityUID("User", "bob"),
+			},
+			mapper: typedMapper{"resource.owner": {Column: "document.owner", Nullable: true}},
+			opts:   Options{NullSafe: true, Dialect: MySQL},
+			want:   "document.owner <=> ?",
+			args:   []interface{}{"bob"},
+		},
+		{
+			name: "null safe not equal on postgres",
+			node: ast.Resource().Access("owner").NotEqual(ast.Principal()),
+			env: eval.Env{
+				Resource:  eval.Variable("resource"),
+				Principal: types.NewEntityUID("User", "bob"),
+			},
+			mapper: typedMapper{"resource.owner": {Column: "document.owner", Nullable: true}},
+			opts:   Options{NullSafe: true},
+			want:   "document.owner IS DISTINCT FROM ?",
+			args:   []interface{}{"bob"},
+		},
+		{
+			name: "null safe not equal on mysql",
+			node: ast.Resource().Access("owner").NotEqual(ast.Principal()),
+			env: eval.Env{
+				Resource:  eval.Variable("resource"),
+				Principal: types.NewEntityUID("User", "bob"),
+			},
+			mapper: typedMapper{"resource.owner": {Column: "document.owner", Nullable: true}},
+			opts:   Options{NullSafe: true, Dialect: MySQL},
+			want:   "NOT (document.owner <=> ?)",
+			args:   []interface{}{"bob"},
+		},
+		{
+			name: "not equal without null safety",
+			node: ast.Resource().Access("owner").NotEqual(ast.Principal()),
+			env: eval.Env{
+				Resource:  eval.Variable("resource"),
+				Principal: types.NewEntityUID("User", "bob"),
+			},
+			mapper: typedMapper{"resource.owner": {Column: "document.owner", Nullable: true}},
+			want:   "document.owner != ?",
+			args:   []interface{}{"bob"},
+		},
+		{
+			name: "equal without null safety",
+			node: ast.Resource().Access("owner").Equal(ast.Principal()),
+			env: eval.Env{
+				Resource:  eval.Variable("resource"),
+				Principal: types.NewEntityUID("User", "bob"),
+			},
+			mapper: typedMapper{"resource.owner": {Column: "document.owner", Nullable: true}},
+			opts:   Options{},
+			want:   "document.owner = ?",
+			args:   []interface{}{"bob"},
+		},
+		{
+			name: "owner in uuid column",
+			node: ast.Resource().Access("owner").Equal(ast.Principal()),
+			env: eval.Env{
+				Resource:  eval.Variable("resource"),
+				Principal: types.NewEntityUID("User", "3f2c1a9e-8d4b-4c7a-9e1f-2b6d5a8c0e47"),
+			},
+			mapper: typedMapper{"resource.owner": {Column: "document.owner_id", Type: TypeUUID}},
+			want:   "document.owner_id = ?::uuid",
+			args:   []interface{}{"3f2c1a9e-8d4b-4c7a-9e1f-2b6d5a8c0e47"},
+		},
+		{
+			name: "a list of uuids contains the owner",
+			node: ast.Set(
+				ast.EntityUID("User", "3f2c1a9e-8d4b-4c7a-9e1f-2b6d5a8c0e47"),
+				ast.EntityUID("User", "7b0e4d21-5c3a-4f8e-b1d9-6a2c8e0f3b54"),
+			).Contains(ast.Resource().Access("owner")),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.owner": {Column: "document.owner_id", Type: TypeUUID}},
+			want:   "document.owner_id IN (?::uuid, ?::uuid)",
+			args:   []interface{}{"3f2c1a9e-8d4b-4c7a-9e1f-2b6d5a8c0e47", "7b0e4d21-5c3a-4f8e-b1d9-6a2c8e0f3b54"},
+		},
+		{
+			name: "a list of uuids contains the owner as an array",
+			node: ast.Set(
+				ast.EntityUID("User", "3f2c1a9e-8d4b-4c7a-9e1f-2b6d5a8c0e47"),
+				ast.EntityUID("User", "7b0e4d21-5c3a-4f8e-b1d9-6a2c8e0f3b54"),
+			).Contains(ast.Resource().Access("owner")),
+			env: eval.Env{
+				Resource: eval.Variable("resource"),
+			},
+			mapper: typedMapper{"resource.owner": {Column: "document.owner_id", Type: TypeUUID}},
+			opts:   Options{ArrayIn: true},
+			want:   "document.owner_id = ANY(?::uuid[])",
+			args: []interface{}{pq.Array([]interface{}{
+				"3f2c1a9e-8d4b-4c7a-9e1f-2b6d5a8c0e47", "7b0e4d21-5c3a-4f8e-b1d9-6a2c8e0f3b54",
+			})},
+		},
+		{
+			name: "resource complex",
+			node: ast.Resource().Access("owner").Equal(ast.Principal()).Or(ast.Resource().Access("is_public").Equal(ast.Boolean(true))),
+			env: eval.Env{
+				Context:   eval.Variable("context"),
+				Resource:  eval.Variable("resource"),
+				Principal: types.NewEntityUID("user", "123"),
+			},
+			mapper: defaultFieldMapper{},
+			want:   "(resource.owner = ? OR resource.is_public = ?)",
+			args:   []interface{}{"123", true},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, args, err := ToSqlWithOptions(test.node.AsIsNode(), test.env, test.mapper, test.opts)
+			if err != nil {
+				t.Fatalf("ToSql(%v) = %v err: %v", test.node, test.want, err)
+			}
+			if got != test.want {
+				t.Fatalf("ToSql Sqlizer(%v) = %v, want %v", test.node, got, test.want)
+			}
+			if len(test.args) > 0 {
+				if len(args) != len(test.args) {
+					t.Fatalf("ToSql Args(%v) = %v, want %v", test.node, args, test.args)
+				}
+				for i, arg := range test.args {
+					if !reflect.DeepEqual(args[i], arg) {
+						t.Fatalf("ToSql Arg(%v) = %v, want %v", test.node, args[i], arg)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestToSqlPartialContextReject(t *testing.T) {
+	t.Parallel()
+	env := eval.Env{
+		Context:  eval.Variable("context"),
+		Resource: eval.Variable("resource"),
+	}
+	opts := Options{PartialContext: PartialContextReject}
+	tests := []struct {
+		name string
+		node ast.Node
+	}{
+		{
+			name: "access",
+			node: ast.Resource().Access("owner").Equal(ast.Context().Access("owner")),
+		},
+		{
+			name: "has",
+			node: ast.Context().Has("ip"),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, err := ToSqlWithOptions(test.node.AsIsNode(), env, defaultFieldMapper{}, opts)
+			if !errors.Is(err, ErrPartialContext) {
+				t.Fatalf("ToSql(%v) err = %v, want %v", test.node, err, ErrPartialContext)
+			}
+		})
+	}
+
+	sql, _, err := ToSqlWithOptions(ast.Resource().Access("owner").Equal(ast.String("bob")).AsIsNode(), env, defaultFieldMapper{}, opts)
+	if err != nil || sql != "resource.owner = ?" {
+		t.Fatalf("ToSql without context = %v, %v", sql, err)
+	}
+}
+
+func TestToSqlDatetime(t *testing.T) {
+	t.Parallel()
+	now := types.NewDatetime(time.Date(2024, 1, 1, 1, 30, 0, 0, time.FixedZone("+02:00", 2*60*60)))
+	env := eval.Env{
+		Context:  types.NewRecord(types.RecordMap{"now": now}),
+		Resource: eval.Variable("resource"),
+	}
+	node := ast.Resource().Access("expires_at").GreaterThan(ast.Context().Access("now"))
+	plus2 := time.FixedZone("+02:00", 2*60*60)
+	tests := []struct {
+		name string
+		opts Options
+		want time.Time
+	}{
+		{
+			name: "utc",
+			want: time.Date(2023, 12, 31, 23, 30, 0, 0, time.UTC),
+		},
+		{
+			name: "in location",
+			opts: Options{Datetime: DatetimeInLocation, Location: plus2},
+			want: time.Date(2024, 1, 1, 1, 30, 0, 0, plus2),
+		},
+		{
+			name: "date in location",
+			opts: Options{Datetime: DatetimeDate, Location: plus2},
+			want: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "date in utc",
+			opts: Options{Datetime: DatetimeDate},
+			want: time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, args, err := ToSqlWithOptions(node.AsIsNode(), env, defaultFieldMapper{}, test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, ok := args[0].(time.Time)
+			if !ok || !got.Equal(test.want) || got.Format(time.RFC3339) != test.want.Format(time.RFC3339) {
+				t.Fatalf("ToSql(%v) arg = %v, want %v", node, args[0], test.want)
+			}
+		})
+	}
+}
+
+func TestBindArgsCopies(t *testing.T) {
+	t.Parallel()
+	at := time.Date(2024, 1, 1, 1, 30, 0, 0, time.UTC)
+	args := []interface{}{true, decimalArg(mustDecimal("12.5")), at}
+	opts := &Options{Dialect: SQLite, Decimal: DecimalFloat64, Datetime: DatetimeDate}
+	got := opts.bindArgs(args)
+	want := []interface{}{1, 12.5, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("bindArgs() = %v, want %v", got, want)
+	}
+	if !reflect.DeepEqual(args, []interface{}{true, decimalArg(mustDecimal("12.5")), at}) {
+		t.Fatalf("bindArgs() changed its input to %v", args)
+	}
+}
+
+func TestToSqlDecimal(t *testing.T) {
+	t.Parallel()
+	env := eval.Env{Resource: eval.Variable("resource")}
+	mapper := typedMapper{"resource.price": {Column: "document.price", Type: TypeDecimal}}
+	less := ast.Resource().Access("price").DecimalLessThan(ast.Value(mustDecimal("12.5")))
+	between := ast.Resource().Access("price").DecimalGreaterThanOrEqual(ast.Value(mustDecimal("0.5"))).
+		And(ast.Resource().Access("price").DecimalLessThanOrEqual(ast.Value(mustDecimal("19.99"))))
+	tests := []struct {
+		name string
+		node ast.Node
+		opts Options
+		want string
+		args []interface{}
+	}{
+		{
+			name: "string",
+			node: less,
+			want: "document.price < CAST(? AS numeric)",
+			args: []interface{}{"12.5"},
+		},
+		{
+			name: "float64",
+			node: less,
+			opts: Options{Decimal: DecimalFloat64},
+			want: "document.price < CAST(? AS numeric)",
+			args: []interface{}{12.5},
+		},
+		{
+			name: "rat",
+			node: less,
+			opts: Options{Decimal: DecimalRat},
+			want: "document.price < CAST(? AS numeric)",
+			args: []interface{}{big.NewRat(25, 2)},
+		},
+		{
+			name: "float64 between bounds",
+			node: between,
+			opts: Options{Decimal: DecimalFloat64, CollapseBetween: true},
+			want: "document.price BETWEEN CAST(? AS numeric) AND CAST(? AS numeric)",
+			args: []interface{}{0.5, 19.99},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, args, err := ToSqlWithOptions(test.node.AsIsNode(), env, mapper, test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Fatalf("ToSql() = %v, want %v", got, test.want)
+			}
+			if !reflect.DeepEqual(args, test.args) {
+				t.Fatalf("ToSql() args = %#v, want %#v", args, test.args)
+			}
+		})
+	}
+}
+
+func TestChooseIn(t *testing.T) {
+	t.Parallel()
+	value := valueToResult(true, types.NewEntityUID("User", "alice"), nil)
+	column := func(typ ColumnType) result {
+		r := valueToResult(false, nil, Expr("c"))
+		r.column = ColumnSpec{Column: "c", Type: typ}
+		return r
+	}
+	tests := []struct {
+		name  string
+		left  result
+		right result
+		want  inStrategy
+	}{
+		{"value in entity column", value, column(TypeEntity), inEntityColumn},
+		{"value in table", value, column(TypeTable), inTable},
+		{"value in array", value, column(TypeArray), inArray},
+		{"column in array", column(TypeUnknown), column(TypeArray), inArray},
+		{"value in jsonb", value, column(TypeJSONB), inJSONB},
+		{"column in jsonb", column(TypeString), column(TypeJSONB), inJSONB},
+		{"column in value", column(TypeEntity), value, inList},
+		{"value in untyped column", value, column(TypeUnknown), inJSONBKey},
+		{"column in entity column", column(TypeEntity), column(TypeEntity), inUnsupported},
+		{"column in table", column(TypeEntity), column(TypeTable), inUnsupported},
+		{"value in string column", value, column(TypeString), inUnsupported},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := chooseIn(test.left, test.right); got != test.want {
+				t.Fatalf("chooseIn = %v, want %v", got, test.want)
+			}
+		})
+	}
+
+	_, _, err := ToSql(ast.Resource().Access("owner").In(ast.Resource().Access("name")).AsIsNode(), eval.Env{
+		Resource: eval.Variable("resource"),
+	}, typedMapper{"resource.name": {Column: "document.name", Type: TypeString}})
+	if !errors.Is(err, ErrUnsupportedIn) {
+		t.Fatalf("ToSql err = %v, want %v", err, ErrUnsupportedIn)
+	}
+}
+
+func TestRegisterValueConverter(t *testing.T) {
+	t.Parallel()
+	labels := types.NewRecord(types.RecordMap{"team": types.String("eng")})
+	RegisterValueConverter(func(v cedar.Value) (interface{}, bool, error) {
+		if r, ok := v.(types.Record); ok && r.Equal(labels) {
+			b, err := r.MarshalJSON()
+			return string(b), true, err
+		}
+		return nil, false, nil
+	})
+	node := ast.Resource().Access("labels").Equal(ast.Value(labels))
+	sql, args, err := ToSql(node.AsIsNode(), eval.Env{Resource: eval.Variable("resource")}, defaultFieldMapper{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sql != "resource.labels = ?::jsonb" || len(args) != 1 || args[0] != `{"team":"eng"}` {
+		t.Fatalf("ToSql(%v) = %v %v", node, sql, args)
+	}
+}
+
+func TestToSqlDurationSince(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	env := eval.Env{
+		Context:  types.NewRecord(types.RecordMap{"now": types.NewDatetime(now)}),
+		Resource: eval.Variable("resource"),
+	}
+	hour := ast.Value(types.NewDuration(time.Hour))
+	tests := []struct {
+		name string
+		node ast.Node
+		want string
+		arg  time.Time
+	}{
+		{
+			name: "seen within the last hour",
+			node: ast.Context().Access("now").DurationSince(ast.Resource().Access("last_seen")).LessThan(hour),
+			want: "resource.last_seen > ?",
+			arg:  now.Add(-time.Hour),
+		},
+		{
+			name: "column receiver",
+			node: ast.Resource().Access("expires_at").DurationSince(ast.Context().Access("now")).LessThan(hour),
+			want: "resource.expires_at < ?",
+			arg:  now.Add(time.Hour),
+		},
+		{
+			name: "duration on the left",
+			node: hour.LessThanOrEqual(ast.Context().Access("now").DurationSince(ast.Resource().Access("created_at"))),
+			want: "resource.created_at <= ?",
+			arg:  now.Add(-time.Hour),
+		},
+		{
+			name: "duration call on the left",
+			node: ast.ExtensionCall("duration", ast.String("1h")).LessThanOrEqual(ast.Context().Access("now").DurationSince(ast.Resource().Access("created_at"))),
+			want: "resource.created_at <= ?",
+			arg:  now.Add(-time.Hour),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sql, args, err := ToSql(test.node.AsIsNode(), env, defaultFieldMapper{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sql != test.want || len(args) != 1 || !args[0].(time.Time).Equal(test.arg) {
+				t.Fatalf("ToSql(%v) = %v %v, want %v %v", test.node, sql, args, test.want, test.arg)
+			}
+		})
+	}
+}
+
+func TestSafeMapper(t *testing.T) {
+	t.Parallel()
+	mapper := SafeMapper{"resource.owner": "document.owner"}
+	env := eval.Env{
+		Resource:  eval.Variable("resource"),
+		Principal: types.NewEntityUID("User", "bob"),
+	}
+	sql, _, err := ToSql(ast.Resource().Access("owner").Equal(ast.Principal()).AsIsNode(), env, mapper)
+	if err != nil || sql != "document.owner = ?" {
+		t.Fatalf("ToSql mapped = %v, %v", sql, err)
+	}
+	_, _, err = ToSql(ast.Resource().Access("ownerr").Equal(ast.Principal()).AsIsNode(), env, mapper)
+	if !errors.Is(err, ErrInvalidFieldName) {
+		t.Fatalf("ToSql unmapped err = %v, want %v", err, ErrInvalidFieldName)
+	}
+	_, _, err = ToSql(ast.Resource().Has("ownerr").AsIsNode(), env, mapper)
+	if !errors.Is(err, ErrInvalidFieldName) {
+		t.Fatalf("ToSql unmapped has err = %v, want %v", err, ErrInvalidFieldName)
+	}
+}
+
+func TestSchemaMapper(t *testing.T) {
+	t.Parallel()
+	columns := map[string]ColumnSpec{
+		"resource.owner": {Column: "document.owner", Type: TypeEntity},
+		"resource.tags":  {Column: "document.tags", Type: TypeJSONB},
+	}
+	env := eval.Env{
+		Resource:  eval.Variable("resource"),
+		Principal: types.NewEntityUID("User", "bob"),
+	}
+	tests := []struct {
+		name   string
+		mapper SchemaMapper
+		node   ast.Node
+		want   string
+		err    error
+	}{
+		{
+			name:   "typed column",
+			mapper: SchemaMapper{Columns: columns},
+			node:   ast.Principal().In(ast.Resource().Access("tags")),
+			want:   "document.tags @> ?::jsonb",
+		},
+		{
+			name:   "undeclared path is rejected",
+			mapper: SchemaMapper{Columns: columns},
+			node:   ast.Resource().Access("team").Equal(ast.String("eng")),
+			err:    ErrInvalidFieldName,
 		},
-		// and
 		{
-			name: "and",
-			node: ast.Context().Access("foo").Equal(ast.String("bar")).And(ast.Context().Access("baz").Equal(ast.Long(50))),
-			env: eval.Env{
-				Context: eval.Variable("context"),
-			},
-			mapper: defaultFieldMapper{},
-			want:   "context.foo = ? AND context.baz = ?",
-			args:   []interface{}{"bar", int64(50)},
+			name:   "undeclared path passes through",
+			mapper: SchemaMapper{Columns: columns, PassThrough: true},
+			node:   ast.Resource().Access("team").Equal(ast.String("eng")),
+			want:   "resource.team = ?",
 		},
-		// or
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, _, err := ToSql(test.node.AsIsNode(), env, test.mapper)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("ToSql(%v) err = %v, want %v", test.node, err, test.err)
+			}
+			if got != test.want {
+				t.Fatalf("ToSql(%v) = %v, want %v", test.node, got, test.want)
+			}
+		})
+	}
+}
+
+func TestChainMappers(t *testing.T) {
+	t.Parallel()
+	mapper := ChainMappers(
+		SchemaMapper{Columns: map[string]ColumnSpec{
+			"resource.team": {Column: "document.team", Type: TypeString},
+			"resource.tags": {Column: "document.tags", Type: TypeJSONB},
+		}},
+		SafeMapper{"principal.team": "users.team"},
+	)
+	env := eval.Env{
+		Resource:  eval.Variable("resource"),
+		Principal: eval.Variable("principal"),
+	}
+	tests := []struct {
+		name string
+		node ast.Node
+		want string
+		err  error
+	}{
 		{
-			name: "or",
-			node: ast.Context().Access("foo").Equal(ast.String("bar")).Or(ast.Context().Access("baz").Equal(ast.Long(50))),
-			env: eval.Env{
-				Context: eval.Variable("context"),
-			},
-			mapper: defaultFieldMapper{},
-			want:   "(context.foo = ? OR context.baz = ?)",
-			args:   []interface{}{"bar", int64(50)},
+			name: "each mapper maps its own prefix",
+			node: ast.Resource().Access("team").Equal(ast.Principal().Access("team")),
+			want: "document.team = users.team",
 		},
 		{
-			name: "equal entity",
-			node: ast.Resource().Equal(ast.EntityUID("doc", "123")),
-			env: eval.Env{
-				Context:  eval.Variable("context"),
-				Resource: eval.Variable("resource"),
-			},
-			mapper: defaultFieldMapper{},
-			want:   "resource = ?",
-			args:   []interface{}{"123"},
+			name: "column type of the mapper is kept",
+			node: ast.Resource().Access("tags").Contains(ast.String("hr")),
+			want: "document.tags ? ?",
 		},
 		{
-			name: "resource complex",
-			node: ast.Resource().Access("owner").Equal(ast.Principal()).Or(ast.Resource().Access("is_public").Equal(ast.Boolean(true))),
-			env: eval.Env{
-				Context:   eval.Variable("context"),
-				Resource:  eval.Variable("resource"),
-				Principal: types.NewEntityUID("user", "123"),
-			},
+			name: "path no mapper maps is rejected",
+			node: ast.Principal().Access("role").Equal(ast.String("admin")),
+			err:  ErrInvalidFieldName,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, _, err := ToSql(test.node.AsIsNode(), env, mapper)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("ToSql(%v) err = %v, want %v", test.node, err, test.err)
+			}
+			if got != test.want {
+				t.Fatalf("ToSql(%v) = %v, want %v", test.node, got, test.want)
+			}
+		})
+	}
+}
+
+func TestToSqlInWithoutAncestry(t *testing.T) {
+	t.Parallel()
+	env := eval.Env{
+		Resource: eval.Variable("resource"),
+		Entities: types.EntityMap{},
+	}
+	node := ast.Not(ast.Resource().In(ast.EntityUID("Folder", "secret")))
+	for _, mapper := range []FieldMapper{
+		defaultFieldMapper{},
+		SafeMapper{"resource": "document.id"},
+		// ids alone lose the ancestor's type
+		typedMapper{"resource.__ancestors__": {Column: "document.ancestors", Type: TypeArray}},
+	} {
+		_, _, err := ToSql(node.AsIsNode(), env, mapper)
+		if !errors.Is(err, ErrUnsupportedIn) {
+			t.Fatalf("ToSql(%v) with %T err = %v, want %v", node, mapper, err, ErrUnsupportedIn)
+		}
+	}
+	sql, args, err := ToSql(node.AsIsNode(), env, typedMapper{
+		"resource.__ancestors__": {Column: "document.ancestors", Type: TypeArray, ElementKey: ElementUID},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "NOT (resource.type = ? AND resource IN (?) OR document.ancestors && ?)"
+	wantArgs := []interface{}{"Folder", "secret", pq.Array([]string{`Folder::"secret"`})}
+	if sql != want || !reflect.DeepEqual(args, wantArgs) {
+		t.Fatalf("ToSql(%v) = %v %v, want %v %v", node, sql, args, want, wantArgs)
+	}
+}
+
+func TestToSqlIsInUnsupported(t *testing.T) {
+	t.Parallel()
+	env := eval.Env{
+		Resource: eval.Variable("resource"),
+		Entities: types.EntityMap{},
+	}
+	tests := []struct {
+		name   string
+		node   ast.Node
+		mapper FieldMapper
+	}{
+		{
+			name:   "untyped ancestry",
+			node:   ast.Resource().IsIn("Photo", ast.EntityUID("Album", "vacation")),
 			mapper: defaultFieldMapper{},
-			want:   "(resource.owner = ? OR resource.is_public = ?)",
-			args:   []interface{}{"123", true},
 		},
+		{
+			name:   "ancestry of bare ids",
+			node:   ast.Resource().IsIn("Photo", ast.EntityUID("Album", "vacation")),
+			mapper: typedMapper{"resource.__ancestors__": {Column: "photo.albums", Type: TypeArray}},
+		},
+		{
+			name:   "in a column",
+			node:   ast.Resource().IsIn("Photo", ast.Resource().Access("album")),
+			mapper: typedMapper{"resource.album": {Column: "photo.album", Type: TypeEntity}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, err := ToSql(test.node.AsIsNode(), env, test.mapper)
+			if !errors.Is(err, ErrUnsupportedIn) {
+				t.Fatalf("ToSql(%v) err = %v, want %v", test.node, err, ErrUnsupportedIn)
+			}
+		})
+	}
+}
+
+func TestToSqlInvalidUUID(t *testing.T) {
+	t.Parallel()
+	env := eval.Env{
+		Resource:  eval.Variable("resource"),
+		Principal: types.NewEntityUID("User", "bob"),
+	}
+	mapper := typedMapper{"resource.owner": {Column: "document.owner_id", Type: TypeUUID}}
+	for _, node := range []ast.Node{
+		ast.Resource().Access("owner").Equal(ast.Principal()),
+		ast.Set(ast.EntityUID("User", "not-a-uuid")).Contains(ast.Resource().Access("owner")),
+	} {
+		_, _, err := ToSql(node.AsIsNode(), env, mapper)
+		if !errors.Is(err, ErrInvalidUUID) {
+			t.Fatalf("ToSql(%v) err = %v, want %v", node, err, ErrInvalidUUID)
+		}
+	}
+}
+
+func TestToSqlSetOfStrings(t *testing.T) {
+	t.Parallel()
+	env := eval.Env{
+		Resource: eval.Variable("resource"),
+		Entities: types.EntityMap{},
+	}
+	tests := []struct {
+		name string
+		node ast.Node
+	}{
+		{
+			// `in` is entity membership in cedar, a string on the left is a type error
+			name: "column in a set of strings",
+			node: ast.Resource().Access("status").In(ast.Set(ast.String("open"), ast.String("pending"))),
+		},
+		{
+			name: "set with a remaining column",
+			node: ast.Set(ast.Resource().Access("owner"), ast.String("open")).Contains(ast.Resource().Access("status")),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if sql, _, err := ToSql(tt.node.AsIsNode(), env, typedMapper{}); err == nil {
+				t.Fatalf("ToSql(%v) = %v, want error", tt.node, sql)
+			}
+		})
+	}
+}
+
+func TestToSqlDecimalMethodOnDatetime(t *testing.T) {
+	t.Parallel()
+	// lessThan is a decimal method in cedar, a datetime operand is a type error
+	node := ast.Resource().Access("created_at").DecimalLessThan(ast.Datetime(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)))
+	_, _, err := ToSql(node.AsIsNode(), eval.Env{Resource: eval.Variable("resource")}, typedMapper{})
+	if !errors.Is(err, eval.ErrType) {
+		t.Fatalf("ToSql(%v) err = %v, want %v", node, err, eval.ErrType)
+	}
+}
+
+func TestExprErr(t *testing.T) {
+	t.Parallel()
+	if _, err := ExprErr("a = ? AND b = ?", 1); !errors.Is(err, ErrPlaceholderMismatch) {
+		t.Fatalf("ExprErr err = %v, want %v", err, ErrPlaceholderMismatch)
+	}
+	if _, err := ExprErr("a ?? ?", 1); err != nil {
+		t.Fatalf("ExprErr err = %v", err)
+	}
+
+	// a mapped name with a question mark must not panic
+	node := ast.Resource().Access("tags").Contains(ast.String("finance"))
+	_, _, err := ToSql(node.AsIsNode(), eval.Env{Resource: eval.Variable("resource")}, typedMapper{
+		"resource.tags": {Column: "document.id", Type: TypeTable, Table: &TableSpec{
+			Name: "document_tags", ForeignKey: "document_id", Element: "tag ? 'x'",
+		}},
+	})
+	if !errors.Is(err, ErrPlaceholderMismatch) {
+		t.Fatalf("ToSql(%v) err = %v, want %v", node, err, ErrPlaceholderMismatch)
+	}
+}
+
+func TestEscapedQuestionMark(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+		expr Sqlizer
+		want string
+	}{
+		{name: "alone", expr: Expr("a ?? b"), want: "a ? b"},
+		{name: "with a value", expr: Expr("a ??| ?", "x"), want: "a ?| ?"},
+		{name: "with a nested sqlizer", expr: Expr("? ??& ?", Expr("a"), "x"), want: "a ?& ?"},
+		{name: "nested in a nested sqlizer", expr: Expr("NOT (?)", Expr("? ?? ?", Expr("a"), "x")), want: "NOT (a ? ?)"},
+		{name: "in a conjunction", expr: AndExpr(Expr("a ?? b"), Expr("? ?? ?", Expr("c"), "x")), want: "a ? b AND c ? ?"},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			got, args, err := ToSql(test.node.AsIsNode(), test.env, test.mapper)
+			got, _, err := test.expr.ToSql()
 			if err != nil {
-				t.Fatalf("ToSql(%v) = %v err: %v", test.node, test.want, err)
+				t.Fatal(err)
 			}
 			if got != test.want {
-				t.Fatalf("ToSql Sqlizer(%v) = %v, want %v", test.node, got, test.want)
+				t.Fatalf("ToSql() = %v, want %v", got, test.want)
 			}
-			if len(test.args) > 0 {
-				if len(args) != len(test.args) {
-					t.Fatalf("ToSql Args(%v) = %v, want %v", test.node, args, test.args)
-				}
-				for i, arg := range test.args {
-					if args[i] != arg {
-						t.Fatalf("ToSql Arg(%v) = %v, want %v", test.node, args[i], arg)
-					}
-				}
+		})
+	}
+}
+
+// ownershipMapper maps resource.owner to a subquery on the ownership table,
+// and the resource's type and tags to subqueries on joined tables.
+type ownershipMapper struct {
+	typedMapper
+}
+
+func (m ownershipMapper) MapExpr(name string) (Sqlizer, bool, error) {
+	switch name {
+	case "resource.owner":
+		return Expr("(SELECT o.user_id FROM ownership o WHERE o.doc_id = document.id AND o.kind = ?)", "primary"), true, nil
+	case "resource." + DefaultTypeField:
+		return Expr("(SELECT k.name FROM kinds k WHERE k.id = document.kind_id)"), true, nil
+	case "resource." + TagsAttribute:
+		return Expr("(SELECT t.tags FROM document_tags t WHERE t.doc_id = document.id)"), true, nil
+	}
+	return nil, false, nil
+}
+
+func TestSqlFieldMapper(t *testing.T) {
+	t.Parallel()
+	env := eval.Env{
+		Resource:  eval.Variable("resource"),
+		Principal: types.NewEntityUID("User", "bob"),
+	}
+	mapper := ownershipMapper{typedMapper{"resource.is_public": {Column: "document.is_public"}}}
+	tests := []struct {
+		name string
+		node ast.Node
+		want string
+		args []interface{}
+	}{
+		{
+			name: "access",
+			node: ast.Resource().Access("owner").Equal(ast.Principal()).Or(ast.Resource().Access("is_public").Equal(ast.True())),
+			want: "((SELECT o.user_id FROM ownership o WHERE o.doc_id = document.id AND o.kind = ?) = ? OR document.is_public = ?)",
+			args: []interface{}{"primary", "bob", true},
+		},
+		{
+			name: "has",
+			node: ast.Resource().Has("owner"),
+			want: "(SELECT o.user_id FROM ownership o WHERE o.doc_id = document.id AND o.kind = ?) IS NOT NULL",
+			args: []interface{}{"primary"},
+		},
+		{
+			name: "is",
+			node: ast.Resource().Is("Document"),
+			want: "(SELECT k.name FROM kinds k WHERE k.id = document.kind_id) = ?",
+			args: []interface{}{"Document"},
+		},
+		{
+			name: "getTag",
+			node: ast.Resource().GetTag(ast.String("dept")).Equal(ast.String("finance")),
+			want: "(SELECT t.tags FROM document_tags t WHERE t.doc_id = document.id) ->> ? = ?",
+			args: []interface{}{"dept", "finance"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sql, args, err := ToSql(tt.node.AsIsNode(), env, mapper)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sql != tt.want {
+				t.Fatalf("ToSql(%v) = %v, want %v", tt.node, sql, tt.want)
+			}
+			if !reflect.DeepEqual(args, tt.args) {
+				t.Fatalf("ToSql(%v) args = %v, want %v", tt.node, args, tt.args)
 			}
 		})
 	}
@@ -144,6 +2296,56 @@ func TestConj(t *testing.T) {
 			),
 			want: "(1 = 1 OR 2 = 2)",
 		},
+		{
+			name: "and inside or",
+			expr: OrExpr(AndExpr(Expr("a"), Expr("b")), Expr("c")),
+			want: "(a AND b OR c)",
+		},
+		{
+			name: "and as an operand",
+			expr: Expr("? = ?", AndExpr(Expr("a"), Expr("b")), OrExpr(Expr("c"), Expr("d"))),
+			want: "(a AND b) = (c OR d)",
+		},
+		{
+			name: "and already grouped",
+			expr: Expr("NOT (?)", AndExpr(Expr("a"), Expr("b"))),
+			want: "NOT (a AND b)",
+		},
+		{
+			name: "or built one disjunct at a time",
+			expr: OrExpr(OrExpr(Expr("a"), Expr("b")), Expr("c")),
+			want: "(a OR b OR c)",
+		},
+		{
+			name: "and of a negated or",
+			expr: AndExpr(AndExpr(Expr("a"), Expr("b")), Expr("NOT ?", OrExpr(OrExpr(Expr("c"), Expr("d")), Expr("e")))),
+			want: "a AND b AND NOT (c OR d OR e)",
+		},
+		{
+			name: "or already grouped",
+			expr: Expr("NOT (?)", OrExpr(Expr("a"), Expr("b"))),
+			want: "NOT (a OR b)",
+		},
+		{
+			name: "in",
+			expr: AndExpr(InExpr("status", []interface{}{"draft", "review"}), Expr("owner = ?", "bob")),
+			want: "status IN (?, ?) AND owner = ?",
+		},
+		{
+			name: "empty in",
+			expr: OrExpr(InExpr("status", nil), Expr("c")),
+			want: "(1 = 0 OR c)",
+		},
+		{
+			name: "not in",
+			expr: NotInExpr("status", []interface{}{"archived"}),
+			want: "status NOT IN (?)",
+		},
+		{
+			name: "empty not in",
+			expr: NotInExpr("status", []interface{}{}),
+			want: "1 = 1",
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
@@ -217,6 +2419,22 @@ func ExampleToSql() {
 	// SELECT * from files where (files.owner = ? OR files.is_public = ?) offset 0 limit 10
 }
 
+// typedMapper maps the listed paths to typed columns and passes every other
+// path through untyped.
+type typedMapper map[string]ColumnSpec
+
+func (m typedMapper) Map(name string) (string, error) {
+	column, err := m.MapColumn(name)
+	return column.Column, err
+}
+
+func (m typedMapper) MapColumn(name string) (ColumnSpec, error) {
+	if column, ok := m[name]; ok {
+		return column, nil
+	}
+	return ColumnSpec{Column: name}, nil
+}
+
 type fileMapper struct {
 }
 
@@ -264,3 +2482,94 @@ func TestEval(t *testing.T) {
 		})
 	}
 }
+
+func mustIPAddr(s string) types.IPAddr {
+	ip, err := types.ParseIPAddr(s)
+	if err != nil {
+		panic(err)
+	}
+	return ip
+}
+
+func mustDecimal(s string) types.Decimal {
+	d, err := types.ParseDecimal(s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func TestCompile(t *testing.T) {
+	t.Parallel()
+	env := eval.Env{
+		Resource:  eval.Variable("resource"),
+		Principal: types.NewEntityUID("User", "bob"),
+	}
+	node := ast.Resource().Access("owner").Equal(ast.Principal()).Or(ast.Resource().Access("is_public").Equal(ast.Boolean(true)))
+	pred, concrete, _, err := Compile(node.AsIsNode(), env, defaultFieldMapper{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if concrete {
+		t.Fatal("want a predicate, got a concrete value")
+	}
+	sql, args, err := Render(AndExpr(Expr("tenant_id = ?", 7), pred), Dollar, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "tenant_id = $1 AND (resource.owner = $2 OR resource.is_public = $3)"
+	if sql != want {
+		t.Fatalf("want %s, got %s", want, sql)
+	}
+	if !reflect.DeepEqual(args, []interface{}{7, "bob", true}) {
+		t.Fatalf("want args [7 bob true], got %v", args)
+	}
+
+	pred, concrete, value, err := Compile(ast.Principal().Equal(ast.EntityUID("User", "bob")).AsIsNode(), env, defaultFieldMapper{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pred != nil || !concrete || value != cedar.True {
+		t.Fatalf("want concrete true, got %v %v %v", pred, concrete, value)
+	}
+}
+
+func TestPrefixUpperBound(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		prefix string
+		want   string
+		ok     bool
+	}{
+		{prefix: "acme/", want: "acme0", ok: true},
+		{prefix: "café", want: "cafê", ok: true},
+		{prefix: "a\U0010FFFF", want: "b", ok: true},
+		{prefix: "\uD7FF", want: "\uE000", ok: true},
+		{prefix: "\U0010FFFF", ok: false},
+	}
+	for _, test := range tests {
+		got, ok := prefixUpperBound(test.prefix)
+		if got != test.want || ok != test.ok {
+			t.Fatalf("prefixUpperBound(%q) = %q %v, want %q %v", test.prefix, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestQuoteIdentifier(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		dialect Dialect
+		name    string
+		want    string
+	}{
+		{dialect: Postgres, name: "order", want: `"order"`},
+		{dialect: SQLite, name: `say "hi"`, want: `"say ""hi"""`},
+		{dialect: MySQL, name: "User", want: "`User`"},
+		{dialect: MySQL, name: "a`b", want: "`a``b`"},
+	}
+	for _, test := range tests {
+		if got := QuoteIdentifier(test.dialect, test.name); got != test.want {
+			t.Fatalf("QuoteIdentifier(%v, %q) = %s, want %s", test.dialect, test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package sqlizer
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/cedar-policy/cedar-go/x/exp/ast"
+	"github.com/jaredzhou/cedar-sqlizer/utils"
+)
+
+// Tracer receives each node of a residual as it is rendered, innermost
+// first, with what it rendered to: "(value: ...)" when it folded to a value,
+// "(sql: ..., args: ...)" otherwise. err is set when the node failed.
+type Tracer interface {
+	Trace(node ast.IsNode, result string, err error)
+}
+
+// TracerFunc adapts a function to a Tracer.
+type TracerFunc func(node ast.IsNode, result string, err error)
+
+func (f TracerFunc) Trace(node ast.IsNode, result string, err error) {
+	f(node, result, err)
+}
+
+// SlogTracer logs each traced node to Logger at Level, with Context passed
+// along so handlers can pick up request scoped values. A nil Logger uses
+// slog.Default().
+type SlogTracer struct {
+	Context context.Context
+	Logger  *slog.Logger
+	Level   slog.Level
+}
+
+func (t SlogTracer) Trace(node ast.IsNode, result string, err error) {
+	logger := t.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	ctx := t.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if err != nil {
+		logger.Log(ctx, t.Level, "sqlizer node", "node", utils.NString(node), "error", err)
+		return
+	}
+	logger.Log(ctx, t.Level, "sqlizer node", "node", utils.NString(node), "result", result)
+}
+
+// stdoutTracer prints one line per node for the deprecated Debug flag. The
+// lock keeps the lines of concurrent calls from interleaving.
+var stdoutTracer = TracerFunc(func(node ast.IsNode, result string, err error) {
+	stdoutMu.Lock()
+	defer stdoutMu.Unlock()
+	if err != nil {
+		fmt.Println(utils.NString(node), "=> error", err)
+		return
+	}
+	fmt.Println(utils.NString(node), "=>", result)
+})
+
+var stdoutMu sync.Mutex
@@ -0,0 +1,40 @@
+package sqlizer
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/cedar-policy/cedar-go/types"
+	"github.com/cedar-policy/cedar-go/x/exp/ast"
+	"github.com/cedar-policy/cedar-go/x/exp/eval"
+	"github.com/jaredzhou/cedar-sqlizer/utils"
+)
+
+func TestTracer(t *testing.T) {
+	t.Parallel()
+	env := eval.Env{
+		Resource:  eval.Variable("resource"),
+		Principal: types.NewEntityUID("User", "bob"),
+	}
+	node := ast.Resource().Access("owner").Equal(ast.Principal())
+	var got []string
+	tracer := TracerFunc(func(n ast.IsNode, result string, err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, utils.NString(n)+" => "+result)
+	})
+	_, _, err := ToSqlWithOptions(node.AsIsNode(), env, fileMapper{}, Options{Tracer: tracer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"resource => (sql: resource, args: [])",
+		"resource.owner => (sql: files.owner, args: [])",
+		"principal => (value: User::\"bob\")",
+		"resource.owner = principal => (sql: files.owner = ?, args: [bob])",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("trace = %q, want %q", got, want)
+	}
+}
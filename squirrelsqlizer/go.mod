@@ -0,0 +1,18 @@
+module github.com/jaredzhou/cedar-sqlizer/squirrelsqlizer
+
+go 1.24.3
+
+replace github.com/jaredzhou/cedar-sqlizer => ../
+
+require (
+	github.com/Masterminds/squirrel v1.5.4
+	github.com/cedar-policy/cedar-go v1.2.6
+	github.com/jaredzhou/cedar-sqlizer v0.0.0-00010101000000-000000000000
+)
+
+require (
+	github.com/lann/builder v0.0.0-20180802200727-47ae307949d0 // indirect
+	github.com/lann/ps v0.0.0-20150810152359-62de8c46ede0 // indirect
+	github.com/lib/pq v1.10.9 // indirect
+	golang.org/x/exp v0.0.0-20220921023135-46d9e7742f1e // indirect
+)
@@ -0,0 +1,40 @@
+// Package squirrelsqlizer adapts cedar row filters to Squirrel query
+// builders. It lives in its own package so only callers that use Squirrel
+// depend on it.
+package squirrelsqlizer
+
+import (
+	sq "github.com/Masterminds/squirrel"
+	"github.com/cedar-policy/cedar-go/x/exp/ast"
+	"github.com/cedar-policy/cedar-go/x/exp/eval"
+	"github.com/jaredzhou/cedar-sqlizer/sqlizer"
+)
+
+// ToSquirrel returns node as a Squirrel predicate, e.g. for
+// `sq.Select("*").From("files").Where(ToSquirrel(node, env, mapper))`.
+// The node is rendered when the query is, so errors surface from its ToSql.
+func ToSquirrel(node ast.IsNode, env eval.Env, mapper sqlizer.FieldMapper) sq.Sqlizer {
+	return ToSquirrelWithOptions(node, env, mapper, sqlizer.Options{})
+}
+
+// ToSquirrelWithOptions is ToSquirrel with explicit rendering options. The
+// placeholders are left to the query's PlaceholderFormat: literal question
+// marks are written as "??", which Squirrel's positional formats such as
+// sq.Dollar turn back into "?". With sq.Question they stay doubled, so
+// operators like jsonb `?|` need a positional format.
+func ToSquirrelWithOptions(node ast.IsNode, env eval.Env, mapper sqlizer.FieldMapper, opts sqlizer.Options) sq.Sqlizer {
+	opts.Placeholder = sqlizer.Escaped
+	opts.StartIndex = 0
+	return predicate{node: node, env: env, mapper: mapper, opts: opts}
+}
+
+type predicate struct {
+	node   ast.IsNode
+	env    eval.Env
+	mapper sqlizer.FieldMapper
+	opts   sqlizer.Options
+}
+
+func (p predicate) ToSql() (string, []interface{}, error) {
+	return sqlizer.ToSqlWithOptions(p.node, p.env, p.mapper, p.opts)
+}
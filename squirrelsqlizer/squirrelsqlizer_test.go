@@ -0,0 +1,52 @@
+package squirrelsqlizer
+
+import (
+	"fmt"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/cedar-policy/cedar-go/types"
+	"github.com/cedar-policy/cedar-go/x/exp/ast"
+	"github.com/cedar-policy/cedar-go/x/exp/eval"
+	"github.com/jaredzhou/cedar-sqlizer/sqlizer"
+)
+
+type tagsMapper map[string]sqlizer.ColumnSpec
+
+func (m tagsMapper) Map(name string) (string, error) {
+	return m[name].Column, nil
+}
+
+func (m tagsMapper) MapColumn(name string) (sqlizer.ColumnSpec, error) {
+	spec, ok := m[name]
+	if !ok {
+		return spec, fmt.Errorf("%s: %w", name, sqlizer.ErrInvalidFieldName)
+	}
+	return spec, nil
+}
+
+func ExampleToSquirrel() {
+	env := eval.Env{
+		Resource:  eval.Variable("resource"),
+		Principal: types.NewEntityUID("User", "bob"),
+	}
+	node := ast.Resource().Access("owner").Equal(ast.Principal()).
+		Or(ast.Resource().Access("tags").ContainsAny(ast.Set(ast.String("public"))))
+	mapper := tagsMapper{
+		"resource.owner": {Column: "files.owner"},
+		"resource.tags":  {Column: "files.tags", Type: sqlizer.TypeJSONB},
+	}
+
+	query, args, err := sq.Select("id").From("files").
+		Where(sq.Eq{"files.tenant_id": 7}).
+		Where(ToSquirrel(node.AsIsNode(), env, mapper)).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(query)
+	fmt.Println(args[:2])
+	// Output:
+	// SELECT id FROM files WHERE files.tenant_id = $1 AND (files.owner = $2 OR files.tags ?| $3::text[])
+	// [7 bob]
+}
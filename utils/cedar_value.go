@@ -3,6 +3,9 @@ package utils
 import (
 	"encoding/json"
 	"fmt"
+	"slices"
+	"strings"
+	"sync"
 
 	"github.com/cedar-policy/cedar-go"
 	"github.com/cedar-policy/cedar-go/x/exp/eval"
@@ -17,7 +20,33 @@ func ValueToType[T cedar.Value](v cedar.Value) (T, error) {
 	return vv, nil
 }
 
+// ValueConverter converts a cedar value to a bind arg. ok is false when the
+// converter does not handle v, letting the next converter try.
+type ValueConverter func(v cedar.Value) (arg interface{}, ok bool, err error)
+
+var (
+	convertersMu sync.RWMutex
+	converters   []ValueConverter
+)
+
+// RegisterValueConverter adds c to the converters ValueToGoValue consults, in
+// registration order, before its built-in conversions. It is meant to be
+// called during program initialization.
+func RegisterValueConverter(c ValueConverter) {
+	convertersMu.Lock()
+	defer convertersMu.Unlock()
+	converters = append(converters, c)
+}
+
 func ValueToGoValue(v cedar.Value) (interface{}, error) {
+	convertersMu.RLock()
+	registered := converters
+	convertersMu.RUnlock()
+	for _, c := range registered {
+		if arg, ok, err := c(v); ok || err != nil {
+			return arg, err
+		}
+	}
 	switch v := v.(type) {
 	case cedar.String:
 		return string(v), nil
@@ -32,7 +61,7 @@ func ValueToGoValue(v cedar.Value) (interface{}, error) {
 	case cedar.Datetime:
 		return v.Time(), nil
 	case cedar.IPAddr:
-		return nil, fmt.Errorf("unsupported value type for SQL: %T", v)
+		return v.String(), nil
 	case cedar.Set:
 		var args []interface{}
 		for item := range v.All() {
@@ -44,13 +73,15 @@ func ValueToGoValue(v cedar.Value) (interface{}, error) {
 		}
 		return args, nil
 	case cedar.Record:
-		return nil, fmt.Errorf("unsupported value type for SQL: %T", v)
+		// a record has no SQL counterpart, it is bound as its json
+		return ValueToJSON(v)
 	}
 	return nil, fmt.Errorf("%w: expected string, got %v", eval.ErrType, eval.TypeName(v))
 }
 
+// ValueToJSON encodes v as json, records as objects.
 func ValueToJSON(v cedar.Value) (string, error) {
-	goValue, err := ValueToGoValue(v)
+	goValue, err := jsonValue(v)
 	if err != nil {
 		return "", err
 	}
@@ -60,3 +91,35 @@ func ValueToJSON(v cedar.Value) (string, error) {
 	}
 	return string(jsonBytes), nil
 }
+
+// jsonValue is ValueToGoValue keeping records, also nested in sets or other
+// records, as maps, so they encode as json objects rather than strings.
+func jsonValue(v cedar.Value) (interface{}, error) {
+	switch v := v.(type) {
+	case cedar.Set:
+		args := []interface{}{}
+		// sets are unordered, sort the elements so the json is stable
+		items := slices.SortedFunc(v.All(), func(a, b cedar.Value) int {
+			return strings.Compare(a.String(), b.String())
+		})
+		for _, item := range items {
+			arg, err := jsonValue(item)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+		}
+		return args, nil
+	case cedar.Record:
+		attrs := make(map[string]interface{}, v.Len())
+		for k, item := range v.All() {
+			arg, err := jsonValue(item)
+			if err != nil {
+				return nil, err
+			}
+			attrs[string(k)] = arg
+		}
+		return attrs, nil
+	}
+	return ValueToGoValue(v)
+}
@@ -7,33 +7,47 @@ import (
 	"github.com/cedar-policy/cedar-go/x/exp/ast"
 )
 
+// NString renders node as Cedar-like text for errors and traces. Values and
+// method calls use Cedar syntax; &&, || and == are written as AND, OR and =.
 func NString(node ast.IsNode) string {
 	if node == nil {
 		return "nil node"
 	}
 	switch n := node.(type) {
 	case ast.NodeTypeAccess:
-		return fmt.Sprintf("%s.%s", NString(n.Arg), n.Value)
+		return fmt.Sprintf("%s.%s", operand(n.Arg, precMember), n.Value)
 	case ast.NodeValue:
-		return n.Value.String()
+		return string(n.Value.MarshalCedar())
 	case ast.NodeTypeNot:
-		return fmt.Sprintf("!%s", NString(n.Arg))
+		return fmt.Sprintf("!%s", operand(n.Arg, precUnary))
 	case ast.NodeTypeVariable:
 		return n.Name.String()
 	case ast.NodeTypeIn:
-		return fmt.Sprintf("%s IN %s", NString(n.Left), NString(n.Right))
+		return fmt.Sprintf("%s IN %s", operand(n.Left, precAdd), operand(n.Right, precAdd))
 	case ast.NodeTypeAnd:
-		return fmt.Sprintf("%s AND %s", NString(n.Left), NString(n.Right))
+		return fmt.Sprintf("%s AND %s", operand(n.Left, precAnd), operand(n.Right, precAnd))
 	case ast.NodeTypeOr:
 		return fmt.Sprintf("(%s OR %s)", NString(n.Left), NString(n.Right))
 	case ast.NodeTypeEquals:
-		return fmt.Sprintf("%s = %s", NString(n.Left), NString(n.Right))
+		return fmt.Sprintf("%s = %s", operand(n.Left, precAdd), operand(n.Right, precAdd))
 	case ast.NodeTypeNotEquals:
-		return fmt.Sprintf("%s != %s", NString(n.Left), NString(n.Right))
+		return fmt.Sprintf("%s != %s", operand(n.Left, precAdd), operand(n.Right, precAdd))
+	case ast.NodeTypeLessThan:
+		return fmt.Sprintf("%s < %s", operand(n.Left, precAdd), operand(n.Right, precAdd))
+	case ast.NodeTypeLessThanOrEqual:
+		return fmt.Sprintf("%s <= %s", operand(n.Left, precAdd), operand(n.Right, precAdd))
 	case ast.NodeTypeGreaterThan:
-		return fmt.Sprintf("%s > %s", NString(n.Left), NString(n.Right))
+		return fmt.Sprintf("%s > %s", operand(n.Left, precAdd), operand(n.Right, precAdd))
+	case ast.NodeTypeGreaterThanOrEqual:
+		return fmt.Sprintf("%s >= %s", operand(n.Left, precAdd), operand(n.Right, precAdd))
+	case ast.NodeTypeAdd:
+		return fmt.Sprintf("%s + %s", operand(n.Left, precAdd), operand(n.Right, precMult))
+	case ast.NodeTypeSub:
+		return fmt.Sprintf("%s - %s", operand(n.Left, precAdd), operand(n.Right, precMult))
+	case ast.NodeTypeMult:
+		return fmt.Sprintf("%s * %s", operand(n.Left, precMult), operand(n.Right, precUnary))
 	case ast.NodeTypeIsEmpty:
-		return fmt.Sprintf("%s.isEmpty() ", NString(n.Arg))
+		return fmt.Sprintf("%s.isEmpty()", operand(n.Arg, precMember))
 	case ast.NodeTypeExtensionCall:
 		args := make([]string, len(n.Args))
 		for i, arg := range n.Args {
@@ -41,19 +55,21 @@ func NString(node ast.IsNode) string {
 		}
 		return fmt.Sprintf("%s(%s)", n.Name, strings.Join(args, ", "))
 	case ast.NodeTypeHas:
-		return fmt.Sprintf("%s has %s", NString(n.Arg), n.Value)
+		return fmt.Sprintf("%s has %s", operand(n.Arg, precAdd), n.Value)
+	case ast.NodeTypeHasTag:
+		return fmt.Sprintf("%s.hasTag(%s)", operand(n.Left, precMember), NString(n.Right))
 	case ast.NodeTypeGetTag:
-		return fmt.Sprintf("%s.getTag(%s)", NString(n.Left), NString(n.Right))
+		return fmt.Sprintf("%s.getTag(%s)", operand(n.Left, precMember), NString(n.Right))
 	case ast.NodeTypeLike:
-		return fmt.Sprintf("%s like %s", NString(n.Arg), n.Value)
+		return fmt.Sprintf("%s like %s", operand(n.Arg, precAdd), n.Value.MarshalCedar())
 	case ast.NodeTypeIfThenElse:
 		return fmt.Sprintf("if %s then %s else %s", NString(n.If), NString(n.Then), NString(n.Else))
 	case ast.NodeTypeIs:
-		return fmt.Sprintf("%s is %s", NString(n.Left), n.EntityType)
+		return fmt.Sprintf("%s is %s", operand(n.Left, precAdd), n.EntityType)
 	case ast.NodeTypeIsIn:
-		return fmt.Sprintf("%s in %s", NString(n.Left), NString(n.Entity))
+		return fmt.Sprintf("%s is %s in %s", operand(n.Left, precAdd), n.EntityType, operand(n.Entity, precAdd))
 	case ast.NodeTypeNegate:
-		return fmt.Sprintf("!%s", NString(n.Arg))
+		return fmt.Sprintf("-%s", operand(n.Arg, precUnary))
 	case ast.NodeTypeRecord:
 		elements := make([]string, len(n.Elements))
 		for i, element := range n.Elements {
@@ -63,17 +79,58 @@ func NString(node ast.IsNode) string {
 	case ast.NodeTypeSet:
 		elements := make([]string, len(n.Elements))
 		for i, element := range n.Elements {
-			elements[i] = fmt.Sprintf("%s", NString(element))
+			elements[i] = NString(element)
 		}
-		return fmt.Sprintf("{%s}", strings.Join(elements, ", "))
+		return fmt.Sprintf("[%s]", strings.Join(elements, ", "))
 	case ast.NodeTypeContains:
-		return fmt.Sprintf("%s contains %s", NString(n.Left), NString(n.Right))
+		return fmt.Sprintf("%s.contains(%s)", operand(n.Left, precMember), NString(n.Right))
 	case ast.NodeTypeContainsAny:
-		return fmt.Sprintf("%s containsAny %s", NString(n.Left), NString(n.Right))
+		return fmt.Sprintf("%s.containsAny(%s)", operand(n.Left, precMember), NString(n.Right))
 	case ast.NodeTypeContainsAll:
-		return fmt.Sprintf("%s containsAll %s", NString(n.Left), NString(n.Right))
+		return fmt.Sprintf("%s.containsAll(%s)", operand(n.Left, precMember), NString(n.Right))
 
 	default:
 		return fmt.Sprintf("NString unsupported node type: %T", n)
 	}
 }
+
+// Binding strength of the rendered operators, loosest first. OR is rendered
+// with its own parentheses, so it never needs another pair.
+const (
+	precIf = iota
+	precAnd
+	precRelation
+	precAdd
+	precMult
+	precUnary
+	precMember
+)
+
+func precedence(node ast.IsNode) int {
+	switch node.(type) {
+	case ast.NodeTypeIfThenElse:
+		return precIf
+	case ast.NodeTypeAnd:
+		return precAnd
+	case ast.NodeTypeEquals, ast.NodeTypeNotEquals, ast.NodeTypeLessThan, ast.NodeTypeLessThanOrEqual,
+		ast.NodeTypeGreaterThan, ast.NodeTypeGreaterThanOrEqual, ast.NodeTypeIn, ast.NodeTypeHas,
+		ast.NodeTypeLike, ast.NodeTypeIs, ast.NodeTypeIsIn:
+		return precRelation
+	case ast.NodeTypeAdd, ast.NodeTypeSub:
+		return precAdd
+	case ast.NodeTypeMult:
+		return precMult
+	case ast.NodeTypeNot, ast.NodeTypeNegate:
+		return precUnary
+	default:
+		return precMember
+	}
+}
+
+// operand renders node, parenthesized when it binds looser than min.
+func operand(node ast.IsNode, min int) string {
+	if precedence(node) < min {
+		return "(" + NString(node) + ")"
+	}
+	return NString(node)
+}
@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cedar-policy/cedar-go"
+	"github.com/cedar-policy/cedar-go/types"
+	"github.com/cedar-policy/cedar-go/x/exp/ast"
+)
+
+func TestNString(t *testing.T) {
+	t.Parallel()
+	// want is left empty when NString gives back the Cedar text unchanged
+	tests := []struct {
+		cedar string
+		want  string
+	}{
+		{cedar: `resource.owner == principal`, want: `resource.owner = principal`},
+		{cedar: `resource.owner != principal`},
+		{cedar: `context.n < 1`},
+		{cedar: `context.n <= 1`},
+		{cedar: `context.n > 1`},
+		{cedar: `context.n >= 1`},
+		{cedar: `context.n + 1 - 2 * 3 > -context.m`},
+		{cedar: `!context.ok`},
+		{cedar: `!(resource.a && resource.b)`, want: `!(resource.a AND resource.b)`},
+		{cedar: `(resource.a + resource.b) * 2 == 4`, want: `(resource.a + resource.b) * 2 = 4`},
+		{cedar: `-(resource.a + 1) < 0`},
+		{cedar: `resource.a - (resource.b - 1) > 0`},
+		{cedar: `context.a && context.b`, want: `context.a AND context.b`},
+		{cedar: `context.a || context.b`, want: `(context.a OR context.b)`},
+		{cedar: `resource in Folder::"root"`, want: `resource IN Folder::"root"`},
+		{cedar: `resource is Document`},
+		{cedar: `resource is Document in Folder::"root"`},
+		{cedar: `resource has owner`},
+		{cedar: `resource.hasTag("team")`},
+		{cedar: `resource.getTag("team") == "eng"`, want: `resource.getTag("team") = "eng"`},
+		{cedar: `resource.name like "acme/*"`},
+		{cedar: `resource.tags.contains("a")`},
+		{cedar: `resource.tags.containsAll(["a", "b"])`},
+		{cedar: `resource.tags.containsAny([["a"], []])`},
+		{cedar: `resource.tags.isEmpty()`},
+		{cedar: `context.r == {a: 1, b: [2]}`, want: `context.r = {a: 1, b: [2]}`},
+		{cedar: `ip("10.0.0.1").isInRange(context.net)`, want: `isInRange(ip("10.0.0.1"), context.net)`},
+		{cedar: `if context.a then 1 else 2`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cedar, func(t *testing.T) {
+			var p cedar.Policy
+			if err := p.UnmarshalCedar([]byte("permit(principal, action, resource) when {" + tt.cedar + "};")); err != nil {
+				t.Fatal(err)
+			}
+			want := tt.want
+			if want == "" {
+				want = tt.cedar
+			}
+			if got := NString(p.AST().Conditions[0].Body); got != want {
+				t.Fatalf("NString() = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestNStringValues(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+		node ast.Node
+		want string
+	}{
+		{name: "datetime", node: ast.Value(types.NewDatetime(time.UnixMilli(0).UTC())), want: `datetime("1970-01-01T00:00:00.000Z")`},
+		{name: "nested set", node: ast.Value(types.NewSet(types.NewSet(types.Long(1)), types.String("a"))), want: `[[1], "a"]`},
+		{name: "entity", node: ast.Value(types.NewEntityUID("User", "bob")), want: `User::"bob"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NString(tt.node.AsIsNode()); got != tt.want {
+				t.Fatalf("NString() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}